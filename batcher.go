@@ -0,0 +1,414 @@
+package idefixgo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	// DefaultBatchMaxCount is the number of buffered events triggering a batch by default
+	DefaultBatchMaxCount = 100
+	// DefaultBatchMaxBytes is the (approximate) size of the buffered events triggering a batch by default
+	DefaultBatchMaxBytes = 256 * 1024
+	// DefaultBatchMaxAge is the maximum time an event is buffered by default
+	DefaultBatchMaxAge = time.Second * 5
+	// DefaultBatchConcurrency is the number of events of a batch created at the same time by default.
+	// The events are created one by one, so they reach the cloud in order.
+	DefaultBatchConcurrency = 1
+	// DefaultBatchMaxPending is the number of events buffered in memory by default
+	DefaultBatchMaxPending = 10000
+
+	spillFileExt = ".spill"
+)
+
+// EventBatcherOptions defines how an [EventBatcher] buffers and sends its events.
+type EventBatcherOptions struct {
+	MaxCount    uint          // Number of buffered events triggering a batch. Defaults to DefaultBatchMaxCount.
+	MaxBytes    uint          // Approximate size (msgpack encoded) of the buffered events triggering a batch. Defaults to DefaultBatchMaxBytes.
+	MaxAge      time.Duration // Maximum time an event is buffered before being sent. Defaults to DefaultBatchMaxAge.
+	Concurrency uint          // Number of events of a batch created at the same time. Above 1, the events may reach the cloud out of order. Defaults to DefaultBatchConcurrency.
+	MaxPending  uint          // Number of events buffered in memory, beyond which Add fails with ErrEventBatcherFull. Defaults to DefaultBatchMaxPending.
+	RetryPolicy *RetryPolicy  // Retries of each events.create request. Defaults to the client policy or DefaultRetryPolicy.
+	SpillDir    string        // Optional directory where the events that can't be sent are saved until the client is back online.
+}
+
+// ErrEventBatcherFull is returned by [EventBatcher.Add] when MaxPending events are buffered
+// in memory, e.g. because the client has been offline for a while and there is no SpillDir.
+var ErrEventBatcherFull = ie.ErrTryAgain.With("event batcher full")
+
+// EventBatcher buffers events and sends them in batches from the background, so that the
+// producers do not wait for the cloud.
+//
+// A batch is sent when the buffered events reach MaxCount or MaxBytes, when the oldest one
+// reaches MaxAge, or when [EventBatcher.Flush] is called. The cloud protocol has no command
+// creating several events at once (events.create takes a single event), so each event of a
+// batch is still created with its own request. By default they are sent one after the other,
+// keeping their order; see Concurrency. Each request is retried (with its original UID, so the
+// cloud drops the duplicates) according to the retry policy.
+//
+// Once an event fails with a transient error, it is kept for the next batch along with the
+// following ones, which are not sent. So are the events flushed while the client is offline.
+// They are kept in memory (up to MaxPending events), or in SpillDir if set, so they survive
+// a restart of the program. A spill file is only removed once its events are created (or
+// spilled again), so a crash while sending them may create them twice, but never loses them.
+type EventBatcher struct {
+	c    *Client
+	opts EventBatcherOptions
+
+	mutex        sync.Mutex
+	pending      []*m.EventMsg
+	pendingBytes uint
+	oldest       time.Time
+	closed       bool
+
+	flushMutex sync.Mutex
+	full       chan struct{}
+	ctx        context.Context
+	cancel     context.CancelFunc
+	done       chan struct{}
+	spillSeq   uint64
+}
+
+// NewEventBatcher creates an [EventBatcher] sending its events through the client.
+// It must be closed with [EventBatcher.Close] to send the last buffered events.
+func (c *Client) NewEventBatcher(opts ...EventBatcherOptions) (*EventBatcher, error) {
+	b := &EventBatcher{c: c}
+	if len(opts) > 0 {
+		b.opts = opts[0]
+	}
+	if b.opts.MaxCount == 0 {
+		b.opts.MaxCount = DefaultBatchMaxCount
+	}
+	if b.opts.MaxBytes == 0 {
+		b.opts.MaxBytes = DefaultBatchMaxBytes
+	}
+	if b.opts.MaxAge <= 0 {
+		b.opts.MaxAge = DefaultBatchMaxAge
+	}
+	if b.opts.Concurrency == 0 {
+		b.opts.Concurrency = DefaultBatchConcurrency
+	}
+	if b.opts.MaxPending == 0 {
+		b.opts.MaxPending = DefaultBatchMaxPending
+	}
+	if b.opts.RetryPolicy == nil {
+		b.opts.RetryPolicy = c.opts.RetryPolicy
+	}
+	if b.opts.RetryPolicy == nil {
+		b.opts.RetryPolicy = &DefaultRetryPolicy
+	}
+	if b.opts.SpillDir != "" {
+		if err := os.MkdirAll(b.opts.SpillDir, 0o755); err != nil {
+			return nil, ie.ErrInternal.Withf("can't create spill directory: %v", err)
+		}
+	}
+
+	b.full = make(chan struct{}, 1)
+	b.done = make(chan struct{})
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	go b.run()
+	return b, nil
+}
+
+// Add buffers an event. An event without UID gets a random one. It fails with
+// [ErrEventBatcherFull] when MaxPending events are already buffered.
+func (b *EventBatcher) Add(e m.EventMsg) error {
+	if e.UID == "" {
+		uid := make([]byte, 16)
+		if _, err := rand.Read(uid); err != nil {
+			return ie.ErrInternal.Withf("can't create event UID: %v", err)
+		}
+		e.UID = hex.EncodeToString(uid)
+	}
+	data, err := msgpack.Marshal(&e)
+	if err != nil {
+		return ie.ErrMarshal.WithErr(err)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return ie.ErrChannelClosed.With("event batcher closed")
+	}
+	if uint(len(b.pending)) >= b.opts.MaxPending {
+		return ErrEventBatcherFull
+	}
+	if len(b.pending) == 0 {
+		b.oldest = time.Now()
+	}
+	b.pending = append(b.pending, &e)
+	b.pendingBytes += uint(len(data))
+	if uint(len(b.pending)) >= b.opts.MaxCount || b.pendingBytes >= b.opts.MaxBytes {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Pending returns the number of events buffered in memory
+func (b *EventBatcher) Pending() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.pending)
+}
+
+// Flush sends the buffered events (and the spilled ones, if the client is online) and waits
+// for their responses. The events rejected by the cloud are dropped, and their errors returned.
+// If some events are kept for later, an [ie.ErrTryAgain] is returned.
+func (b *EventBatcher) Flush(ctx context.Context) error {
+	b.flushMutex.Lock()
+	defer b.flushMutex.Unlock()
+
+	b.mutex.Lock()
+	events := b.pending
+	b.pending, b.pendingBytes = nil, 0
+	b.mutex.Unlock()
+
+	var errs []error
+	var spillFiles []string
+	online := b.c.Status() == Connected
+	if online && b.opts.SpillDir != "" {
+		spilled, files, err := b.loadSpilled()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		events = append(spilled, events...)
+		spillFiles = files
+	}
+	if len(events) == 0 {
+		return errors.Join(errs...)
+	}
+
+	var kept []*m.EventMsg
+	if online {
+		kept, errs = b.send(ctx, events, errs)
+	} else {
+		kept = events
+	}
+	if len(kept) > 0 {
+		if err := b.keep(kept); err != nil {
+			// The loaded spill files still hold the kept events
+			errs = append(errs, err)
+			return errors.Join(errs...)
+		}
+		errs = append(errs, ie.ErrTryAgain.Withf("%d events kept for a later batch", len(kept)))
+	}
+	if err := b.removeSpilled(spillFiles); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops buffering events and flushes the remaining ones. Without SpillDir, the events
+// that could not be sent are returned (along with an [ie.ErrTryAgain]), so they are not lost;
+// with SpillDir, they are saved there.
+func (b *EventBatcher) Close(ctx context.Context) (unsent []m.EventMsg, err error) {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return nil, nil
+	}
+	b.closed = true
+	b.mutex.Unlock()
+
+	b.cancel()
+	<-b.done
+	err = b.Flush(ctx)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, e := range b.pending {
+		unsent = append(unsent, *e)
+	}
+	b.pending, b.pendingBytes = nil, 0
+	return unsent, err
+}
+
+func (b *EventBatcher) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(max(b.opts.MaxAge/4, time.Millisecond*10))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-b.full:
+		case <-ticker.C:
+			b.mutex.Lock()
+			expired := len(b.pending) > 0 && time.Since(b.oldest) >= b.opts.MaxAge
+			b.mutex.Unlock()
+			if !expired && !b.spilledPending() {
+				continue
+			}
+		}
+		if err := b.Flush(b.ctx); err != nil {
+			b.c.Logger().Debug("event batch not completed", "error", err)
+		}
+	}
+}
+
+// send creates the events, returning the ones failing with a transient error and the ones
+// not sent after it
+func (b *EventBatcher) send(ctx context.Context, events []*m.EventMsg, errs []error) (kept []*m.EventMsg, _ []error) {
+	ctx = WithRetryPolicy(ctx, b.opts.RetryPolicy)
+	results := make([]error, len(events))
+	skipped := make([]bool, len(events))
+	workers := make(chan struct{}, b.opts.Concurrency)
+	var stopped atomic.Bool
+	var wg sync.WaitGroup
+	for i, e := range events {
+		workers <- struct{}{}
+		if stopped.Load() {
+			<-workers
+			skipped[i] = true
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+			callCtx, cancel := context.WithTimeout(ctx, defaultSyscallTimeout)
+			defer cancel()
+			_, results[i] = b.c.EventCreate(e, callCtx)
+			if b.transient(results[i]) {
+				stopped.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range results {
+		if skipped[i] {
+			kept = append(kept, events[i])
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		if b.transient(err) {
+			kept = append(kept, events[i])
+			continue
+		}
+		b.c.Logger().Warn("event dropped", "event_id", events[i].UID, "error", err)
+		errs = append(errs, fmt.Errorf("event %s: %w", events[i].UID, err))
+	}
+	return kept, errs
+}
+
+// transient reports whether an event failing with err must be kept for a later batch
+func (b *EventBatcher) transient(err error) bool {
+	return err != nil && (b.opts.RetryPolicy.Retryable(err) || ie.ErrContextClosed.Is(err))
+}
+
+// keep saves the events for a later batch
+func (b *EventBatcher) keep(events []*m.EventMsg) error {
+	if b.opts.SpillDir != "" {
+		return b.spill(events)
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, e := range events {
+		if data, err := msgpack.Marshal(e); err == nil {
+			b.pendingBytes += uint(len(data))
+		}
+	}
+	if len(b.pending) == 0 {
+		b.oldest = time.Now()
+	}
+	b.pending = append(events, b.pending...)
+	return nil
+}
+
+// spill writes the events to a new file in the spill directory
+func (b *EventBatcher) spill(events []*m.EventMsg) error {
+	data, err := msgpack.Marshal(events)
+	if err != nil {
+		return ie.ErrMarshal.WithErr(err)
+	}
+	b.spillSeq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), b.spillSeq, spillFileExt)
+	tmp := filepath.Join(b.opts.SpillDir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return ie.ErrInternal.Withf("can't spill events: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(b.opts.SpillDir, name)); err != nil {
+		return ie.ErrInternal.Withf("can't spill events: %v", err)
+	}
+	b.c.Logger().Debug("events spilled to disk", "count", len(events), "file", name)
+	return nil
+}
+
+// spillFiles returns the spilled files, oldest first
+func (b *EventBatcher) spillFiles() ([]string, error) {
+	entries, err := os.ReadDir(b.opts.SpillDir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), spillFileExt) {
+			files = append(files, entry.Name())
+		}
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+func (b *EventBatcher) spilledPending() bool {
+	if b.opts.SpillDir == "" || b.c.Status() != Connected {
+		return false
+	}
+	files, err := b.spillFiles()
+	return err == nil && len(files) > 0
+}
+
+// loadSpilled reads the spilled events, along with the files holding them. The files must
+// be removed with removeSpilled once the events are sent.
+func (b *EventBatcher) loadSpilled() ([]*m.EventMsg, []string, error) {
+	files, err := b.spillFiles()
+	if err != nil {
+		return nil, nil, ie.ErrInternal.Withf("can't read spill directory: %v", err)
+	}
+	var events []*m.EventMsg
+	var loaded []string
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(b.opts.SpillDir, name))
+		if err != nil {
+			return events, loaded, ie.ErrInternal.Withf("can't read spilled events: %v", err)
+		}
+		var spilled []*m.EventMsg
+		if err := msgpack.Unmarshal(data, &spilled); err != nil {
+			b.c.Logger().Warn("invalid spill file dropped", "file", name, "error", err)
+		} else {
+			events = append(events, spilled...)
+		}
+		loaded = append(loaded, name)
+	}
+	return events, loaded, nil
+}
+
+// removeSpilled removes the spill files whose events were sent or spilled again
+func (b *EventBatcher) removeSpilled(files []string) error {
+	for _, name := range files {
+		if err := os.Remove(filepath.Join(b.opts.SpillDir, name)); err != nil && !os.IsNotExist(err) {
+			return ie.ErrInternal.Withf("can't remove spilled events: %v", err)
+		}
+	}
+	return nil
+}
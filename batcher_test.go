@@ -0,0 +1,243 @@
+package idefixgo_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ifx "github.com/nayarsystems/idefix-go"
+	ie "github.com/nayarsystems/idefix-go/errors"
+	"github.com/nayarsystems/idefix-go/idefixtest"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func eventUIDs(s *idefixtest.Server) []string {
+	var uids []string
+	for _, e := range s.Events() {
+		uids = append(uids, e.UID)
+	}
+	return uids
+}
+
+func TestEventBatcher(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.CreateAddress("dev1", "t1", "acme")
+
+	c, err := s.Connect(context.Background(), "dev1", "t1")
+	require.NoError(t, err)
+	defer c.Disconnect()
+
+	// Each event fails once with a transient error
+	var mutex sync.Mutex
+	attempts := map[string]int{}
+	c.Use(func(next ifx.CallFunc) ifx.CallFunc {
+		return func(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+			if msg.To != m.CmdEventsCreate {
+				return next(ctx, remoteAddress, msg)
+			}
+			uid := msg.Data.(map[string]any)["uid"].(string)
+			mutex.Lock()
+			attempts[uid]++
+			first := attempts[uid] == 1
+			mutex.Unlock()
+			if first {
+				return nil, ie.ErrTryAgain
+			}
+			return next(ctx, remoteAddress, msg)
+		}
+	})
+
+	b, err := c.NewEventBatcher(ifx.EventBatcherOptions{
+		MaxCount:    3,
+		MaxAge:      time.Hour,
+		RetryPolicy: &ifx.RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond},
+	})
+	require.NoError(t, err)
+
+	// Reaching MaxCount sends a batch
+	for i := range 3 {
+		require.NoError(t, b.Add(m.EventMsg{UID: fmt.Sprintf("e%d", i), Payload: i}))
+	}
+	require.Eventually(t, func() bool { return len(s.Events()) == 3 }, time.Second*2, time.Millisecond*10)
+	require.Equal(t, []string{"e0", "e1", "e2"}, eventUIDs(s))
+	mutex.Lock()
+	require.Equal(t, map[string]int{"e0": 2, "e1": 2, "e2": 2}, attempts)
+	mutex.Unlock()
+
+	// Events below the limits wait for a flush, and get an UID if they lack it
+	require.NoError(t, b.Add(m.EventMsg{Payload: "no uid"}))
+	require.Equal(t, 1, b.Pending())
+	require.NoError(t, b.Flush(context.Background()))
+	require.Equal(t, 0, b.Pending())
+	events := s.Events()
+	require.Len(t, events, 4)
+	require.NotEmpty(t, events[3].UID)
+
+	// Close flushes the remaining events
+	require.NoError(t, b.Add(m.EventMsg{UID: "e4", Payload: 4}))
+	_, err = b.Close(context.Background())
+	require.NoError(t, err)
+	require.Len(t, s.Events(), 5)
+	require.ErrorIs(t, b.Add(m.EventMsg{UID: "e5"}), ie.ErrChannelClosed)
+}
+
+func TestEventBatcherAge(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.CreateAddress("dev1", "t1", "acme")
+
+	c, err := s.Connect(context.Background(), "dev1", "t1")
+	require.NoError(t, err)
+	defer c.Disconnect()
+
+	b, err := c.NewEventBatcher(ifx.EventBatcherOptions{MaxAge: time.Millisecond * 100})
+	require.NoError(t, err)
+	defer b.Close(context.Background())
+
+	require.NoError(t, b.Add(m.EventMsg{UID: "e0", Payload: 0}))
+	require.Eventually(t, func() bool { return len(s.Events()) == 1 }, time.Second*2, time.Millisecond*10)
+}
+
+func TestEventBatcherSpill(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.CreateAddress("dev1", "t1", "acme")
+	dir := t.TempDir()
+
+	c, err := s.Connect(context.Background(), "dev1", "t1")
+	require.NoError(t, err)
+	c.Disconnect()
+
+	// Offline events are spilled to disk
+	b, err := c.NewEventBatcher(ifx.EventBatcherOptions{SpillDir: dir, MaxAge: time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, b.Add(m.EventMsg{UID: "e0", Payload: []byte{0}}))
+	require.NoError(t, b.Add(m.EventMsg{UID: "e1", Payload: []byte{1}}))
+	unsent, err := b.Close(context.Background())
+	require.ErrorIs(t, err, ie.ErrTryAgain)
+	require.Empty(t, unsent) // Spilled
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Empty(t, s.Events())
+
+	c, err = s.Connect(context.Background(), "dev1", "t1")
+	require.NoError(t, err)
+	defer c.Disconnect()
+
+	// The spilled events are kept on disk until they are sent
+	b, err = c.NewEventBatcher(ifx.EventBatcherOptions{SpillDir: dir, MaxAge: time.Hour})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, b.Flush(ctx), ie.ErrTryAgain)
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Empty(t, s.Events())
+	_, err = b.Close(context.Background())
+	require.NoError(t, err)
+	require.Len(t, s.Events(), 2)
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// A new batcher sends them once online
+	b, err = c.NewEventBatcher(ifx.EventBatcherOptions{SpillDir: dir, MaxAge: time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, b.Add(m.EventMsg{UID: "e2", Payload: []byte{2}}))
+	c.Disconnect()
+	unsent, err = b.Close(context.Background())
+	require.ErrorIs(t, err, ie.ErrTryAgain)
+	require.Empty(t, unsent)
+	c, err = s.Connect(context.Background(), "dev1", "t1")
+	require.NoError(t, err)
+	defer c.Disconnect()
+	b, err = c.NewEventBatcher(ifx.EventBatcherOptions{SpillDir: dir, MaxAge: time.Millisecond * 100})
+	require.NoError(t, err)
+	defer b.Close(context.Background())
+	require.Eventually(t, func() bool { return len(s.Events()) == 3 }, time.Second*2, time.Millisecond*10)
+	require.Equal(t, []string{"e0", "e1", "e2"}, eventUIDs(s))
+	require.Eventually(t, func() bool {
+		entries, err := os.ReadDir(dir)
+		return err == nil && len(entries) == 0
+	}, time.Second*2, time.Millisecond*10)
+}
+
+func TestEventBatcherOrder(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.CreateAddress("dev1", "t1", "acme")
+
+	c, err := s.Connect(context.Background(), "dev1", "t1")
+	require.NoError(t, err)
+	defer c.Disconnect()
+
+	// e1 fails until it is allowed
+	var failing atomic.Bool
+	failing.Store(true)
+	c.Use(func(next ifx.CallFunc) ifx.CallFunc {
+		return func(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+			if msg.To == m.CmdEventsCreate && msg.Data.(map[string]any)["uid"] == "e1" && failing.Load() {
+				return nil, ie.ErrTryAgain
+			}
+			return next(ctx, remoteAddress, msg)
+		}
+	})
+
+	b, err := c.NewEventBatcher(ifx.EventBatcherOptions{
+		MaxAge:      time.Hour,
+		RetryPolicy: &ifx.RetryPolicy{MaxAttempts: 1},
+	})
+	require.NoError(t, err)
+	for i := range 4 {
+		require.NoError(t, b.Add(m.EventMsg{UID: fmt.Sprintf("e%d", i), Payload: i}))
+	}
+
+	// The events following a transient failure wait for it
+	require.ErrorIs(t, b.Flush(context.Background()), ie.ErrTryAgain)
+	require.Equal(t, []string{"e0"}, eventUIDs(s))
+	require.Equal(t, 3, b.Pending())
+
+	failing.Store(false)
+	require.NoError(t, b.Flush(context.Background()))
+	require.Equal(t, []string{"e0", "e1", "e2", "e3"}, eventUIDs(s))
+	_, err = b.Close(context.Background())
+	require.NoError(t, err)
+}
+
+func TestEventBatcherOffline(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.CreateAddress("dev1", "t1", "acme")
+
+	c, err := s.Connect(context.Background(), "dev1", "t1")
+	require.NoError(t, err)
+	c.Disconnect()
+
+	// Without SpillDir, the buffered events are bounded
+	b, err := c.NewEventBatcher(ifx.EventBatcherOptions{MaxPending: 2, MaxAge: time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, b.Add(m.EventMsg{UID: "e0", Payload: 0}))
+	require.NoError(t, b.Add(m.EventMsg{UID: "e1", Payload: 1}))
+	require.ErrorIs(t, b.Add(m.EventMsg{UID: "e2", Payload: 2}), ifx.ErrEventBatcherFull)
+
+	// and returned by Close if they can't be sent
+	unsent, err := b.Close(context.Background())
+	require.ErrorIs(t, err, ie.ErrTryAgain)
+	require.Len(t, unsent, 2)
+	require.Equal(t, "e0", unsent[0].UID)
+	require.Equal(t, "e1", unsent[1].UID)
+	require.Empty(t, s.Events())
+}
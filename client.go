@@ -4,14 +4,13 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/hex"
 	"fmt"
+	"log/slog"
 	"regexp"
+	"sync"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	ie "github.com/nayarsystems/idefix-go/errors"
 	m "github.com/nayarsystems/idefix-go/messages"
 	"github.com/nayarsystems/idefix-go/minips"
@@ -29,6 +28,7 @@ const (
 // ConnectionStatusHandler is a function type that defines a handler for connection status changes
 // in the [Client]. This handler is called whenever the connection status of the [Client] changes,
 // allowing users to implement custom behavior based on the new status.
+// When the status is [Connected], [Client.ActiveBroker] reports the broker in use.
 type ConnectionStatusHandler func(*Client, ConnectionStatus)
 
 // Client represents a connection to Idefix, providing methods to interact with. It encapsulates the context, configuration, and connection details necessary for operation.
@@ -38,11 +38,30 @@ type Client struct {
 	cancelFunc              context.CancelFunc
 	opts                    *ClientOptions
 	ps                      *minips.Minips[*m.Message]
-	client                  mqtt.Client
+	transport               Transport
 	prefix                  string
 	sessionID               string
+	stateMutex              sync.Mutex
 	connectionState         ConnectionStatus
 	ConnectionStatusHandler ConnectionStatusHandler
+	reconnectHooks          reconnectHooks
+	reconnectMutex          sync.Mutex
+	reconnecting            sync.WaitGroup
+	middlewareMutex         sync.RWMutex
+	middlewares             []Middleware
+	telemetryOnce           sync.Once
+	tel                     *telemetry
+	loggerOnce              sync.Once
+	log                     *slog.Logger
+	outboxMutex             sync.Mutex
+	outboxQueued            bool
+	outboxReplay            sync.Mutex
+	limitersMutex           sync.Mutex
+	globalLimiter           *limiter
+	addressLimiters         map[string]*limiter
+	limitersSwept           time.Time
+	streamsOnce             sync.Once
+	streams                 *StreamManager
 }
 
 // NewClient returns a new [Client] with the options and the context given
@@ -77,13 +96,22 @@ func NewClientFromFile(pctx context.Context, configFile string) (*Client, error)
 // The Connect method initializes the MQTT client with the provided options,
 // including the broker address, credentials, and optional TLS configuration if
 // a CA certificate is provided. It generates a unique session ID if one is not
-// specified in the options.
+// specified in the options. If [ClientOptions.Transport] is set, it is used
+// instead of the default MQTT transport.
+//
+// The default transport tries [ClientOptions.Broker] and then each of the
+// [ClientOptions.Brokers] in order, until one of them accepts the connection.
 //
 // Upon successful connection, it subscribes to the client's designated
 // response topic and performs a login operation. The connection state is then
 // updated to Connected.
+//
+// If [ClientOptions.Reconnect] is enabled, a connection loss does not cancel the
+// client context. Instead, the client keeps retrying the connection in background
+// using an exponential backoff with jitter, and restores the session once the
+// broker is reachable again.
 func (c *Client) Connect() (err error) {
-	if c.connectionState != Disconnected {
+	if c.Status() != Disconnected {
 		return ie.ErrAlreadyExists
 	}
 
@@ -91,30 +119,17 @@ func (c *Client) Connect() (err error) {
 	// make sure that the client has been disconnected
 	// or connectionlosthandler might trigger after creating
 	// the new context, thus cancelling the wrong ctx
-	if c.client != nil {
+	if c.transport != nil {
 		c.Disconnect()
 	}
 
+	c.reconnectMutex.Lock()
 	c.ctx, c.cancelFunc = context.WithCancel(c.pctx)
+	c.reconnectMutex.Unlock()
 
 	c.prefix = m.MqttIdefixPrefix
 	c.ps = minips.NewMinips[*m.Message](c.ctx)
 
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(c.opts.Broker)
-	opts.SetCleanSession(true)
-	opts.SetUsername("device")
-	opts.SetPassword("77dev22p1")
-
-	if len(c.opts.CACert) > 0 {
-		certpool := x509.NewCertPool()
-		certpool.AppendCertsFromPEM(c.opts.CACert)
-
-		opts.SetTLSConfig(&tls.Config{
-			RootCAs: certpool,
-		})
-	}
-
 	if c.opts.SkipLogin && c.opts.SessionID == "" {
 		return ie.ErrInvalidParams.With("SkipLogin option requires a SessionID to be set")
 	}
@@ -129,23 +144,42 @@ func (c *Client) Connect() (err error) {
 			}
 		}
 	}
-	opts.SetClientID(c.sessionID)
 
-	opts.SetConnectionLostHandler(c.connectionLostHandler)
-	opts.SetDefaultPublishHandler(c.receiveMessage)
+	if c.opts.Transport != nil {
+		c.transport = c.opts.Transport
+	} else {
+		c.transport = newMqttTransport(c.opts)
+	}
+	c.transport.SetConnectionLostHandler(c.connectionLostHandler)
 
-	c.client = mqtt.NewClient(opts)
+	if err := c.dial(); err != nil {
+		return err
+	}
+
+	if ol, ok := c.opts.Outbox.(outboxLogger); ok {
+		ol.SetLogger(c.Logger())
+	}
+	if c.opts.Outbox != nil {
+		c.outboxMutex.Lock()
+		c.outboxQueued = true
+		c.outboxMutex.Unlock()
+	}
+	c.setState(Connected)
+	if c.opts.Outbox != nil {
+		go c.replayOutbox()
+	}
+	return nil
+}
 
-	token := c.client.Connect()
-	token.Wait()
-	if token.Error() != nil {
-		return ie.ErrInternal.With(token.Error().Error())
+// dial connects the underlying transport, subscribes to the response topic
+// and performs the login (unless SkipLogin is set).
+func (c *Client) dial() error {
+	if err := c.transport.Connect(c.sessionID); err != nil {
+		return ie.ErrInternal.With(err.Error())
 	}
 
-	token = c.client.Subscribe(fmt.Sprintf("%s/%s/r/+", c.prefix, c.sessionID), 1, nil)
-	token.Wait()
-	if token.Error() != nil {
-		return ie.ErrInternal.With(token.Error().Error())
+	if err := c.transport.Subscribe(fmt.Sprintf("%s/%s/r/+", c.prefix, c.sessionID), 1, c.receiveMessage); err != nil {
+		return ie.ErrInternal.With(err.Error())
 	}
 
 	if !c.opts.SkipLogin {
@@ -153,8 +187,6 @@ func (c *Client) Connect() (err error) {
 			return err
 		}
 	}
-
-	c.setState(Connected)
 	return nil
 }
 
@@ -167,11 +199,16 @@ func (c *Client) SetSessionID(sessionID string) {
 // This method changes the client's state to Disconnected and invokes
 // the cancel function associated with the client's context, which
 // may trigger any pending operations or goroutines related to the
-// client's connection.
+// client's connection. A reconnection in progress is stopped, and the
+// remote streams of the client are lost (see [StreamManager]).
+//
+// It must not be called from a [ConnectionStatusHandler], as it waits
+// for the reconnection that runs the handler to end.
 func (c *Client) Disconnect() {
+	c.stopReconnect()
 	c.setState(Disconnected)
-	c.cancelFunc()
-	c.client.Disconnect(200)
+	c.StreamManager().loseAll(ie.ErrContextClosed.With("client disconnected"))
+	c.transport.Disconnect()
 }
 
 // Returns the client address
@@ -184,24 +221,75 @@ func (c *Client) Context() context.Context {
 	return c.ctx
 }
 
+// Returns the broker the client is (or was last) connected to. It is empty
+// if the transport does not report it (see [BrokerReporter]).
+func (c *Client) ActiveBroker() string {
+	if br, ok := c.transport.(BrokerReporter); ok {
+		return br.ActiveBroker()
+	}
+	return ""
+}
+
+// Returns the logger of the client (see [ClientOptions.Logger]). Its records
+// carry the client address under the "client" key.
+func (c *Client) Logger() *slog.Logger {
+	c.loggerOnce.Do(func() {
+		l := c.opts.Logger
+		if l == nil {
+			l = slog.Default()
+		}
+		c.log = l.With("client", c.opts.Address)
+	})
+	return c.log
+}
+
+// Returns the transport used by the client (nil before the first [Client.Connect])
+func (c *Client) Transport() Transport {
+	return c.transport
+}
+
 // Sets the connection status to the client and updates the [ConnectionStatusHandler] if initialized
 func (c *Client) setState(cs ConnectionStatus) {
+	c.stateMutex.Lock()
 	if c.connectionState == cs {
+		c.stateMutex.Unlock()
 		return
 	}
-
 	c.connectionState = cs
+	c.stateMutex.Unlock()
 
 	if c.ConnectionStatusHandler != nil {
-		c.ConnectionStatusHandler(c, c.connectionState)
+		c.ConnectionStatusHandler(c, cs)
 	}
 }
 
 // Returns the connection status of a given client.
 func (c *Client) Status() ConnectionStatus {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
 	return c.connectionState
 }
 
+// ClientStatus is a snapshot of the state of a [Client] (see [Client.StatusInfo])
+type ClientStatus struct {
+	Connection  ConnectionStatus // Connection status
+	Broker      string           // Broker the client is (or was last) connected to, if known
+	OutboxDepth int              // Messages waiting in the outbox to be published, -1 if it can't be read
+}
+
+// Returns the connection status of the client along with the depth of its outbox
+// (see [ClientOptions.Outbox]).
+func (c *Client) StatusInfo() ClientStatus {
+	st := ClientStatus{Connection: c.Status(), Broker: c.ActiveBroker()}
+	depth, err := c.OutboxDepth()
+	if err != nil {
+		c.Logger().Warn("can't read the outbox depth", "error", err)
+		depth = -1
+	}
+	st.OutboxDepth = depth
+	return st
+}
+
 func (c *Client) responseTopic() string {
 	return fmt.Sprintf("%s/%s/r/", c.prefix, c.sessionID)
 }
@@ -226,16 +314,8 @@ func (c *Client) login() (err error) {
 		Data: lm,
 	}
 
-	m, err := c.Call("idefix", tm, time.Second*3)
-	if err != nil {
-		return err
-	}
-
-	if m.Err != "" {
-		return fmt.Errorf(m.Err)
-	}
-
-	return nil
+	_, err = c.Call("idefix", tm, time.Second*3)
+	return err
 }
 
 func randSessionID() (string, error) {
@@ -247,9 +327,14 @@ func randSessionID() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-func (c *Client) connectionLostHandler(client mqtt.Client, err error) {
+func (c *Client) connectionLostHandler(err error) {
 	c.setState(Disconnected)
-	c.cancelFunc()
+	if !c.opts.Reconnect {
+		c.cancelFunc()
+		go c.StreamManager().loseAll(ie.ErrContextClosed.Withf("connection lost: %v", err))
+		return
+	}
+	c.startReconnect()
 }
 
 // Given an address, this method will return a valid address. If the provided address is valid, the returned address will be the same. Otherwise, if the given address contains characters other than numbers, letters, or dashes, this method will generate the SHA256 hash of that address and return the first 16 characters.
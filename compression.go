@@ -0,0 +1,122 @@
+package idefixgo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	e "github.com/nayarsystems/idefix-go/errors"
+)
+
+const (
+	// DefaultCompressionThreshold is the minimum size (in bytes) of an encoded message
+	// to be compressed (see [ClientOptions.CompressionThreshold]). Every message is
+	// compressed by default.
+	DefaultCompressionThreshold = 0
+
+	// RecommendedCompressionThreshold is a threshold leaving uncompressed the messages too
+	// small to benefit from compression. It must be set explicitly in the client options.
+	RecommendedCompressionThreshold = 128
+
+	// maxDecompressedSize bounds the size of the decompressed inbound messages
+	maxDecompressedSize = 64 << 20
+)
+
+// Compression flags of the message encoding, in order of preference
+const (
+	flagZstd = "z"
+	flagGzip = "g"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// The zstd encoder and decoder are safe for concurrent use through EncodeAll and DecodeAll
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil)
+	})
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	})
+)
+
+// compressionThreshold returns the threshold applying to a threshold option:
+// zero and negative values compress every message.
+func compressionThreshold(threshold int) int {
+	if threshold <= 0 {
+		return DefaultCompressionThreshold
+	}
+	return threshold
+}
+
+// compress compresses data with the preferred codec of the encoding flags ('z' for zstd,
+// 'g' for gzip). With a positive threshold, data is only compressed if it is at least
+// threshold bytes long and compression reduces its size; otherwise it is always compressed.
+// It returns the flag of the codec used, empty if data was left uncompressed.
+func compress(data []byte, encoding string, threshold int) (flag string, out []byte) {
+	if len(data) < threshold {
+		return "", data
+	}
+
+	var comp []byte
+	switch {
+	case strings.Contains(encoding, flagZstd):
+		enc, err := zstdEncoder()
+		if err != nil {
+			return "", data
+		}
+		flag, comp = flagZstd, enc.EncodeAll(data, nil)
+	case strings.Contains(encoding, flagGzip):
+		buf := new(bytes.Buffer)
+		wr := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(wr)
+		wr.Reset(buf)
+		if _, err := wr.Write(data); err != nil {
+			return "", data
+		}
+		if err := wr.Close(); err != nil {
+			return "", data
+		}
+		flag, comp = flagGzip, buf.Bytes()
+	default:
+		return "", data
+	}
+
+	if threshold > 0 && len(comp) >= len(data) {
+		return "", data
+	}
+	return flag, comp
+}
+
+// decompress is the counterpart of compress
+func decompress(flags string, payload []byte) ([]byte, error) {
+	switch {
+	case strings.Contains(flags, flagZstd):
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, e.ErrInternal.WithErr(err)
+		}
+		payload, err = dec.DecodeAll(payload, nil)
+		if err != nil {
+			return nil, e.ErrMarshal.Withf("can't decompress zstd: %v", err)
+		}
+	case strings.Contains(flags, flagGzip):
+		gzr, err := gzip.NewReader(bytes.NewReader(payload))
+		if err == nil {
+			payload, err = io.ReadAll(io.LimitReader(gzr, maxDecompressedSize+1))
+			gzr.Close()
+		}
+		if err != nil {
+			return nil, e.ErrMarshal.Withf("can't decompress gzip: %v", err)
+		}
+		if len(payload) > maxDecompressedSize {
+			return nil, e.ErrMarshal.With("decompressed gzip message too large")
+		}
+	}
+	return payload, nil
+}
@@ -0,0 +1,57 @@
+package idefixgo
+
+import (
+	"strings"
+	"testing"
+
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	large := &m.Message{To: "dev.data", Data: map[string]any{"s": strings.Repeat("idefix", 1000)}}
+	small := &m.Message{To: "dev.data", Data: map[string]any{"s": "idefix"}}
+
+	tests := []struct {
+		encoding  string
+		threshold int
+		msg       *m.Message
+		flags     string
+	}{
+		{"m", 0, large, "m"},
+		{"mg", DefaultCompressionThreshold, large, "mg"},
+		{"mz", DefaultCompressionThreshold, large, "mz"},
+		{"jz", DefaultCompressionThreshold, large, "jz"},
+		{"cg", DefaultCompressionThreshold, large, "cg"},
+		{"mgz", DefaultCompressionThreshold, large, "mz"},
+		{"mg", DefaultCompressionThreshold, small, "mg"}, // Every message is compressed by default
+		{"mz", RecommendedCompressionThreshold, small, "m"},
+		{"mz", RecommendedCompressionThreshold, large, "mz"},
+		{"mg", 1 << 20, large, "m"},
+	}
+	for _, tt := range tests {
+		flags, data, size, err := encodeMessage(tt.msg, tt.encoding, tt.threshold)
+		require.NoError(t, err)
+		require.Equal(t, tt.flags, flags, tt.encoding)
+		if len(flags) > 1 {
+			if tt.msg == large {
+				require.Less(t, len(data), size)
+			}
+		} else {
+			require.Equal(t, len(data), size)
+		}
+
+		msg, err := DecodeMessage(flags, data)
+		require.NoError(t, err)
+		require.Equal(t, tt.msg.Data, msg.Data)
+	}
+
+	_, err := DecodeMessage("mz", []byte("not zstd"))
+	require.Error(t, err)
+	_, err = DecodeMessage("mg", []byte("not gzip"))
+	require.Error(t, err)
+
+	require.Equal(t, DefaultCompressionThreshold, compressionThreshold(0))
+	require.Equal(t, DefaultCompressionThreshold, compressionThreshold(-1))
+	require.Equal(t, 10, compressionThreshold(10))
+}
@@ -2,11 +2,14 @@ package idefixgo
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
 	"runtime/debug"
 
+	"github.com/nayarsystems/mapstructure"
 	"github.com/spf13/viper"
 )
 
@@ -38,7 +41,19 @@ func ReadConfig(name string) (*ClientOptions, error) {
 		return c, err
 	}
 
-	if err := c.vp.Unmarshal(c); err != nil {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           c,
+		WeaklyTypedInput: true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			stringToBytesHookFunc(),
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+	})
+	if err != nil {
+		return c, err
+	}
+	if err := decoder.Decode(c.vp.AllSettings()); err != nil {
 		return c, err
 	}
 
@@ -71,3 +86,18 @@ func UpdateConfig(c *ClientOptions) error {
 
 	return c.vp.WriteConfig()
 }
+
+// stringToBytesHookFunc decodes the byte slice options (certificates and keys) from strings.
+// Base64 strings (as written by [UpdateConfig]) are decoded, any other string (e.g. a PEM block) is taken as is.
+func stringToBytesHookFunc() mapstructure.DecodeHookFuncType {
+	return func(f reflect.Type, t reflect.Type, data any) (any, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf([]byte(nil)) {
+			return data, nil
+		}
+		s := data.(string)
+		if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+			return b, nil
+		}
+		return []byte(s), nil
+	}
+}
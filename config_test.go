@@ -0,0 +1,51 @@
+package idefixgo
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfigTLS(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".idefix"), 0755))
+
+	cert, key := newTestCert(t)
+	data, err := json.Marshal(map[string]any{
+		"broker":        "ssl://broker.local:8883",
+		"address":       "test",
+		"token":         "testToken",
+		"username":      "user",
+		"password":      "pass",
+		"cacert":        string(cert),
+		"clientCert":    string(cert),
+		"clientKey":     string(key),
+		"serverName":    "broker.local",
+		"minTlsVersion": "1.2",
+	})
+	require.NoError(t, err)
+	path := filepath.Join(home, ".idefix", "tlstest.json")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	opts, err := ReadConfig("tlstest")
+	require.NoError(t, err)
+	require.Equal(t, "user", opts.Username)
+	require.Equal(t, "pass", opts.Password)
+	require.Equal(t, cert, opts.CACert)
+	require.Equal(t, cert, opts.ClientCert)
+	require.Equal(t, key, opts.ClientKey)
+	require.Equal(t, "broker.local", opts.ServerName)
+	require.Equal(t, "1.2", opts.MinTLSVersion)
+
+	// Certificates must survive a config update
+	require.NoError(t, UpdateConfig(opts))
+	opts, err = ReadConfig("tlstest")
+	require.NoError(t, err)
+	require.Equal(t, cert, opts.ClientCert)
+	require.Equal(t, key, opts.ClientKey)
+}
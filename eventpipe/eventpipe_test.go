@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/nayarsystems/idefix-go/idefixtest"
 	m "github.com/nayarsystems/idefix-go/messages"
 	"github.com/stretchr/testify/require"
 )
@@ -243,3 +244,48 @@ func testProcessedFalseRetry(t *testing.T, storagePath string) {
 	require.NoError(t, err)
 	require.Empty(t, events)
 }
+
+func TestPipelineFakeCloud(t *testing.T) {
+	srv, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	t.Cleanup(srv.Close)
+	srv.CreateAddress("test-device", "token", "test-domain")
+	srv.AddEvents(generateTestEvents(2)...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := srv.Connect(ctx, "test-client", "token")
+	require.NoError(t, err)
+	t.Cleanup(client.Disconnect)
+
+	esm, err := NewEventSourceManager(EventSourceManagerParams{
+		Client:      client,
+		Context:     ctx,
+		StoragePath: ":memory",
+	})
+	require.NoError(t, err)
+	require.NoError(t, esm.Init())
+	t.Cleanup(func() { esm.Close() })
+
+	source, err := esm.NewSource(EventSourceParams{
+		Id:                 "test-source-cloud",
+		Domain:             "test-domain",
+		LongPollingTimeout: time.Second,
+	})
+	require.NoError(t, err)
+
+	stage := &mockStage{}
+	require.NoError(t, source.Push(stage, OptName("stage")))
+
+	runSource(t, cancel, source)
+
+	require.Eventually(t, func() bool {
+		return stage.count() == 2
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// Events created while the source is long polling must be delivered too
+	more := generateTestEvents(4)[2:]
+	srv.AddEvents(more...)
+	require.Eventually(t, func() bool {
+		return stage.count() == 4
+	}, 10*time.Second, 50*time.Millisecond)
+}
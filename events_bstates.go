@@ -167,7 +167,7 @@ func fillStateMap(ic *Client, events []*messages.Event, p *GetBstatesParams, sta
 		if ok {
 			blobI, ok := rawMsi["Data"]
 			if !ok {
-				fmt.Println("no 'Data' field found")
+				ic.Logger().Warn("can't get bstates blob", "event_id", e.UID, "error", "no 'Data' field found")
 				continue
 			}
 
@@ -184,18 +184,19 @@ func fillStateMap(ic *Client, events []*messages.Event, p *GetBstatesParams, sta
 				payloadErr = fmt.Errorf("can't get a buffer from 'Data' field")
 			}
 			if payloadErr != nil {
-				fmt.Printf("%v\n", payloadErr)
+				ic.Logger().Warn("can't get bstates blob", "event_id", e.UID, "error", payloadErr)
 				continue
 			}
 		} else {
 			b64Str, ok := e.Payload.(string)
 			if !ok {
-				fmt.Println("wrong payload format")
+				ic.Logger().Warn("can't get bstates blob", "event_id", e.UID, "error", "wrong payload format")
+				continue
 			}
 			var derr error
 			blob, derr = base64.StdEncoding.DecodeString(b64Str)
 			if derr != nil {
-				fmt.Printf("payload is a string but is not a valid base64: %v", derr)
+				ic.Logger().Warn("can't get bstates blob", "event_id", e.UID, "error", fmt.Errorf("payload is a string but is not a valid base64: %v", derr))
 				continue
 			}
 		}
@@ -204,16 +205,17 @@ func fillStateMap(ic *Client, events []*messages.Event, p *GetBstatesParams, sta
 		if schema = getSchemaFromCache(schemaId); schema == nil {
 			schemaMsg, serr := ic.GetSchema(schemaId, time.Second)
 			if serr != nil {
-				fmt.Printf("schema '%s' was not found: %v\n", schemaId, serr)
+				ic.Logger().Warn("can't get bstates schema", "event_id", e.UID, "schema_id", schemaId, "error", serr)
 				continue
 			}
 			schema = &be.StateSchema{}
 			serr = schema.UnmarshalJSON([]byte(schemaMsg.Payload))
 			if serr != nil {
-				fmt.Printf("can't parse schema '%s': %v\n", schemaId, serr)
+				ic.Logger().Warn("can't parse bstates schema", "event_id", e.UID, "schema_id", schemaId, "error", serr)
 				continue
 			}
 			saveSchemaOnCache(schemaId, schema)
+			ic.Logger().Debug("bstates schema fetched", "schema_id", schemaId)
 		}
 
 		var domainMap map[string]map[string]map[string]*BstatesSource
@@ -236,7 +238,7 @@ func fillStateMap(ic *Client, events []*messages.Event, p *GetBstatesParams, sta
 
 		metaRaw, merr := json.Marshal(e.Meta)
 		if merr != nil {
-			fmt.Printf("can't get raw meta: %v\n", merr)
+			ic.Logger().Warn("can't marshal event meta", "event_id", e.UID, "error", merr)
 			continue
 		}
 		metaHashRaw := sha256.Sum256(metaRaw)
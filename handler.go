@@ -0,0 +1,193 @@
+package idefixgo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/nayarsystems/idefix-go/minips"
+)
+
+const (
+	// DefaultHandlerConcurrency is the number of requests a [Handler] serves at the same time by default
+	DefaultHandlerConcurrency = 16
+	// DefaultHandlerCapacity is the number of pending requests a [Handler] queues by default
+	DefaultHandlerCapacity = 100
+)
+
+// HandlerFunc serves a request addressed to the client. The returned value is sent back
+// as the response data, and a returned error is sent as the response 'Err' field.
+//
+// The context is cancelled when the handler timeout expires, when the [Handler] is closed
+// or when the client disconnects.
+type HandlerFunc func(ctx context.Context, msg *m.Message) (any, error)
+
+// HandlerOptions defines how a [Handler] serves its requests.
+type HandlerOptions struct {
+	Concurrency uint          // Maximum number of requests served concurrently. Defaults to DefaultHandlerConcurrency.
+	Capacity    uint          // Number of requests queued while all the workers are busy (the rest are dropped). Defaults to DefaultHandlerCapacity.
+	Timeout     time.Duration // Maximum time to serve a request. On expiration an ErrTimeout is answered. Zero means no timeout.
+}
+
+// Handler dispatches the requests received on a topic to a [HandlerFunc].
+type Handler struct {
+	c       *Client
+	topic   string
+	fn      HandlerFunc
+	opts    HandlerOptions
+	ctx     context.Context
+	cancel  context.CancelFunc
+	sub     *minips.Subscriber[*m.Message]
+	workers chan struct{}
+	wg      sync.WaitGroup
+	mutex   sync.Mutex
+	closed  bool
+}
+
+// Handle registers a handler serving the requests sent to the client address on the given
+// topic (and its subtopics, e.g. "cmd" also receives "cmd.reboot"). When a request carries a
+// 'Res' field, the result of the handler is automatically sent back with [Client.Answer].
+//
+// Struct and map results are converted with [m.ToMsi], the rest are sent as they are.
+// A panic in the handler is answered as an [ie.ErrInternal].
+//
+// The client must be connected. The handler keeps serving across reconnections (see
+// [ClientOptions.Reconnect]) until [Handler.Close] is called or the client is disconnected.
+func (c *Client) Handle(topic string, fn HandlerFunc, opts ...HandlerOptions) (*Handler, error) {
+	if topic == "" {
+		return nil, ie.ErrEmptyTopic
+	}
+	if fn == nil {
+		return nil, ie.ErrInvalidParams.With("missing handler function")
+	}
+	if c.ps == nil || c.ctx == nil || c.ctx.Err() != nil {
+		return nil, ie.ErrContextClosed.With("client is not connected")
+	}
+
+	h := &Handler{c: c, topic: topic, fn: fn}
+	if len(opts) > 0 {
+		h.opts = opts[0]
+	}
+	if h.opts.Concurrency == 0 {
+		h.opts.Concurrency = DefaultHandlerConcurrency
+	}
+	if h.opts.Capacity == 0 {
+		h.opts.Capacity = DefaultHandlerCapacity
+	}
+	h.workers = make(chan struct{}, h.opts.Concurrency)
+	h.ctx, h.cancel = context.WithCancel(c.ctx)
+	h.sub = c.ps.NewSubscriber(h.opts.Capacity, topic)
+
+	go h.serve()
+	return h, nil
+}
+
+// Topic returns the topic served by the handler
+func (h *Handler) Topic() string {
+	return h.topic
+}
+
+// Close stops receiving requests and waits for the ones being served, including the
+// handler functions still running after their timeout.
+func (h *Handler) Close() {
+	h.mutex.Lock()
+	h.closed = true
+	h.mutex.Unlock()
+	h.cancel()
+	h.wg.Wait()
+}
+
+// begin registers a request being served, unless the handler is closed
+func (h *Handler) begin() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		return false
+	}
+	h.wg.Add(1)
+	return true
+}
+
+func (h *Handler) serve() {
+	defer h.sub.Close()
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case msg, ok := <-h.sub.Channel():
+			if !ok {
+				return
+			}
+			select {
+			case h.workers <- struct{}{}:
+			case <-h.ctx.Done():
+				return
+			}
+			if !h.begin() {
+				<-h.workers
+				return
+			}
+			go func() {
+				defer h.wg.Done()
+				defer func() { <-h.workers }()
+				h.handle(msg)
+			}()
+		}
+	}
+}
+
+func (h *Handler) handle(msg *m.Message) {
+	ctx, cancel := h.ctx, context.CancelFunc(func() {})
+	if h.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(h.ctx, h.opts.Timeout)
+	}
+	defer cancel()
+
+	type result struct {
+		data any
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{err: ie.ErrInternal.Withf("handler panic: %v", r)}
+			}
+		}()
+		data, err := h.fn(ctx, msg)
+		done <- result{data, err}
+	}()
+
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		if h.ctx.Err() != nil {
+			res.err = ie.ErrContextClosed
+		} else {
+			res.err = ie.ErrTimeout
+		}
+		// The cancelled context tells the handler function to stop. It keeps its worker
+		// until it returns, so that the concurrency limit holds.
+		defer func() { <-done }()
+	}
+
+	if res.err != nil {
+		h.c.Logger().Debug("request failed", "topic", msg.To, "error", res.err)
+	}
+	if msg.Res == "" {
+		return
+	}
+
+	answer := &m.Message{}
+	if res.err != nil {
+		answer.Err = res.err.Error()
+	} else if answer.Data, res.err = msgData(res.data); res.err != nil {
+		answer.Err = ie.ErrMarshal.WithErr(res.err).Error()
+	}
+	if err := h.c.Answer(msg, answer); err != nil {
+		h.c.Logger().Warn("can't answer request", "topic", msg.To, "error", err)
+	}
+}
@@ -0,0 +1,126 @@
+package idefixgo_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ifx "github.com/nayarsystems/idefix-go"
+	ie "github.com/nayarsystems/idefix-go/errors"
+	"github.com/nayarsystems/idefix-go/idefixtest"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+
+	svc, err := s.Connect(context.Background(), "svc", "svcToken")
+	require.NoError(t, err)
+	defer svc.Disconnect()
+	c, err := s.Connect(context.Background(), "caller", "callerToken")
+	require.NoError(t, err)
+	defer c.Disconnect()
+
+	_, err = svc.Handle("", nil)
+	require.ErrorIs(t, err, ie.ErrEmptyTopic)
+
+	h, err := svc.Handle("math", func(ctx context.Context, msg *m.Message) (any, error) {
+		switch msg.To {
+		case "math.sum":
+			req := struct{ A, B int }{}
+			if err := m.ParseMsg(msg.Data, &req); err != nil {
+				return nil, ie.ErrInvalidParams.WithErr(err)
+			}
+			return map[string]any{"res": req.A + req.B}, nil
+		case "math.zero":
+			return 0, nil
+		case "math.panic":
+			panic("boom")
+		}
+		return nil, ie.ErrNotImplemented.With(msg.To)
+	})
+	require.NoError(t, err)
+	require.Equal(t, "math", h.Topic())
+
+	res := map[string]any{}
+	require.NoError(t, c.Call2("svc", &m.Message{To: "math.sum", Data: map[string]any{"A": 1, "B": 2}}, &res, time.Second))
+	require.EqualValues(t, 3, res["res"])
+
+	ret, err := c.Call("svc", &m.Message{To: "math.zero"}, time.Second)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, ret.Data)
+
+	_, err = c.Call("svc", &m.Message{To: "math.div"}, time.Second)
+	require.ErrorContains(t, err, ie.ErrNotImplemented.Message)
+	_, err = c.Call("svc", &m.Message{To: "math.panic"}, time.Second)
+	require.ErrorContains(t, err, ie.ErrInternal.Message)
+
+	// Closed handlers stop answering
+	h.Close()
+	_, err = c.Call("svc", &m.Message{To: "math.sum"}, time.Millisecond*200)
+	require.ErrorIs(t, err, ie.ErrTimeout)
+}
+
+func TestHandleLimits(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+
+	svc, err := s.Connect(context.Background(), "svc", "svcToken")
+	require.NoError(t, err)
+	defer svc.Disconnect()
+	c, err := s.Connect(context.Background(), "caller", "callerToken")
+	require.NoError(t, err)
+	defer c.Disconnect()
+
+	var running, maxRunning atomic.Int32
+	h, err := svc.Handle("slow", func(ctx context.Context, msg *m.Message) (any, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			max := maxRunning.Load()
+			if n <= max || maxRunning.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		select {
+		case <-time.After(time.Millisecond * 100):
+			return true, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}, ifx.HandlerOptions{Concurrency: 2, Timeout: time.Millisecond * 500})
+	require.NoError(t, err)
+	defer h.Close()
+
+	errs := make(chan error, 6)
+	for range 6 {
+		go func() {
+			_, err := c.Call("svc", &m.Message{To: "slow"}, time.Second*5)
+			errs <- err
+		}()
+	}
+	for range 6 {
+		require.NoError(t, <-errs)
+	}
+	require.EqualValues(t, 2, maxRunning.Load())
+
+	var stopped atomic.Value
+	h2, err := svc.Handle("stuck", func(ctx context.Context, msg *m.Message) (any, error) {
+		<-ctx.Done()
+		time.Sleep(time.Millisecond * 100)
+		stopped.Store(ctx.Err())
+		return true, nil
+	}, ifx.HandlerOptions{Timeout: time.Millisecond * 100})
+	require.NoError(t, err)
+	_, err = c.Call("svc", &m.Message{To: "stuck"}, time.Second)
+	require.True(t, ie.ErrTimeout.Is(err))
+
+	// The timed out function is told to stop through its context, and Close waits for it
+	h2.Close()
+	require.Equal(t, context.DeadlineExceeded, stopped.Load())
+}
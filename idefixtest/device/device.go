@@ -0,0 +1,265 @@
+// Package device implements a simulated Idefix device agent, meant to test
+// the code that talks to real devices (file transfers, updates, remote streams...)
+// without any hardware.
+//
+// A [Device] serves the "os.cmd.*", "sys.cmd.*", "updater.cmd.*" and "streams.cmd.*"
+// topics through an already connected [ifx.Client], logged in as the device address.
+// The device file system is backed by a sandbox directory: every path received
+// (absolute or relative) is resolved inside it.
+//
+// The device has a local message bus, fed by [Device.Emit] and by the remote
+// publisher streams, where the remote subscriber streams take their messages from.
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	ifx "github.com/nayarsystems/idefix-go"
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/nayarsystems/idefix-go/minips"
+)
+
+// DefaultFreeSpace is the free space reported by "os.cmd.free" when [Params.FreeSpace] is not set.
+const DefaultFreeSpace = 1 << 30
+
+// Topic served by the device to handle firmware updates.
+const TopicCmdUpdate = "updater.cmd.update"
+
+// Topics served by the device to handle system commands.
+const (
+	TopicCmdSysInfo = "sys.cmd.info"
+	TopicCmdSysExit = "sys.cmd.exit"
+)
+
+// ExecFunc runs a shell command on the device.
+type ExecFunc func(ctx context.Context, cmd string) (*m.ExecResMsg, error)
+
+type Params struct {
+	// Connected client, logged in as the device address
+	Client *ifx.Client
+
+	// Directory backing the device file system. It is created if it does not exist.
+	Sandbox string
+
+	// (optional) Information returned by "sys.cmd.info". The device address, the uptime
+	// and the boot counter are filled in by the device.
+	Info *m.SysInfo
+
+	// (optional) Free space reported by "os.cmd.free". Defaults to [DefaultFreeSpace]
+	FreeSpace uint64
+
+	// (optional) Shell command runner. By default, commands are run with "sh -c"
+	// inside the sandbox directory, with their absolute paths resolved inside it
+	// (this is a convenience, not a security boundary).
+	Exec ExecFunc
+
+	// (optional) Called when the device is requested to exit, either by "sys.cmd.exit"
+	// or by an update request ("updater.cmd.update").
+	OnExit func(req *m.ExitReqMsg)
+}
+
+// Device is a simulated Idefix device.
+type Device struct {
+	p       Params
+	c       *ifx.Client
+	ctx     context.Context
+	cancel  context.CancelFunc
+	sandbox string
+	bus     *minips.Minips[*m.Message]
+	sub     *minips.Subscriber[*m.Message]
+	start   time.Time
+
+	mutex   sync.Mutex
+	bootCnt uint32
+	streams map[string]*stream
+}
+
+type handler func(d *Device, msg *m.Message) (any, error)
+
+var handlers map[string]handler
+
+func init() {
+	handlers = map[string]handler{
+		ifx.TopicCmdFileRead:        (*Device).fileRead,
+		ifx.TopicCmdFileWrite:       (*Device).fileWrite,
+		ifx.TopicCmdFileSize:        (*Device).fileSize,
+		ifx.TopicCmdFileCopy:        (*Device).fileCopy,
+		ifx.TopicCmdFileSHA256:      (*Device).fileSHA256,
+		ifx.TopicCmdExec:            (*Device).exec,
+		ifx.TopicCmdMkdir:           (*Device).mkdir,
+		ifx.TopicCmdRemove:          (*Device).remove,
+		ifx.TopicCmdMove:            (*Device).move,
+		ifx.TopicCmdFree:            (*Device).free,
+		ifx.TopicCmdListDir:         (*Device).listDir,
+		TopicCmdSysInfo:             (*Device).sysInfo,
+		TopicCmdSysExit:             (*Device).sysExit,
+		TopicCmdUpdate:              (*Device).update,
+		m.TopicRemoteSubscribe:      (*Device).streamSub,
+		m.TopicRemoteUnsubscribe:    (*Device).streamUnsub,
+		m.TopicRemoteStartPublisher: (*Device).streamStartPub,
+		m.TopicRemoteStopPublisher:  (*Device).streamStopPub,
+	}
+}
+
+// New creates a device serving its topics through the given client, until
+// [Device.Close] is called or the client context is done.
+func New(params Params) (*Device, error) {
+	if params.Client == nil {
+		return nil, ie.ErrInvalidParams.With("missing client")
+	}
+	if params.Sandbox == "" {
+		return nil, ie.ErrInvalidParams.With("missing sandbox")
+	}
+	sandbox, err := filepath.Abs(params.Sandbox)
+	if err != nil {
+		return nil, ie.ErrInvalidParams.WithErr(err)
+	}
+	if err := os.MkdirAll(sandbox, 0755); err != nil {
+		return nil, ie.ErrInternal.WithErr(err)
+	}
+	if params.FreeSpace == 0 {
+		params.FreeSpace = DefaultFreeSpace
+	}
+	if params.Exec == nil {
+		params.Exec = shellExec(sandbox)
+	}
+
+	d := &Device{
+		p:       params,
+		c:       params.Client,
+		sandbox: sandbox,
+		start:   time.Now(),
+		bootCnt: 1,
+		streams: make(map[string]*stream),
+	}
+	if params.Info != nil && params.Info.BootCnt != 0 {
+		d.bootCnt = params.Info.BootCnt
+	}
+	d.ctx, d.cancel = context.WithCancel(d.c.Context())
+	d.bus = minips.NewMinips[*m.Message](d.ctx)
+
+	topics := make([]string, 0, len(handlers))
+	for topic := range handlers {
+		topics = append(topics, topic)
+	}
+	d.sub = d.c.NewSubscriber(100, topics...)
+	go d.serve()
+	return d, nil
+}
+
+// Close stops serving the device topics and closes all its streams.
+func (d *Device) Close() {
+	d.cancel()
+	d.closeStreams()
+}
+
+// Context returns the device context, which is done when the device is closed.
+func (d *Device) Context() context.Context {
+	return d.ctx
+}
+
+// Address returns the device address.
+func (d *Device) Address() string {
+	return d.c.Address()
+}
+
+// Sandbox returns the absolute path of the sandbox directory.
+func (d *Device) Sandbox() string {
+	return d.sandbox
+}
+
+// Path returns where a device path is stored in the sandbox directory.
+func (d *Device) Path(path string) string {
+	return filepath.Join(d.sandbox, filepath.Clean("/"+path))
+}
+
+// Emit publishes a message on the device local bus, so it is delivered
+// to the remote subscriber streams whose topic matches.
+func (d *Device) Emit(topic string, payload any) uint {
+	return d.bus.Publish(topic, &m.Message{To: topic, Data: payload})
+}
+
+// NewSubscriber subscribes to the device local bus, which receives the
+// messages sent through the remote publisher streams.
+func (d *Device) NewSubscriber(capacity uint, topics ...string) *minips.Subscriber[*m.Message] {
+	return d.bus.NewSubscriber(capacity, topics...)
+}
+
+// Reboot simulates a device reboot: all its streams are lost (so they
+// become unknown to the clients refreshing them) and the boot counter is increased.
+func (d *Device) Reboot() {
+	d.closeStreams()
+	d.mutex.Lock()
+	d.bootCnt++
+	d.start = time.Now()
+	d.mutex.Unlock()
+}
+
+func (d *Device) serve() {
+	defer d.sub.Close()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case msg, ok := <-d.sub.Channel():
+			if !ok {
+				return
+			}
+			go d.handle(msg)
+		}
+	}
+}
+
+func (d *Device) handle(msg *m.Message) {
+	var data any
+	var err error
+	if h, ok := handlers[msg.To]; ok {
+		data, err = h(d, msg)
+	} else {
+		err = ie.ErrNotImplemented.With(msg.To)
+	}
+
+	if msg.Res == "" {
+		return
+	}
+	res := &m.Message{}
+	if err != nil {
+		res.Err = err.Error()
+	} else if res.Data, err = m.ToMsi(data); err != nil {
+		res.Err = ie.ErrMarshal.WithErr(err).Error()
+	}
+	d.c.Answer(msg, res)
+}
+
+// parse fills the request struct from the message data
+func parse(msg *m.Message, req any) error {
+	if err := m.ParseMsg(msg.Data, req); err != nil {
+		return ie.ErrInvalidParams.WithErr(err)
+	}
+	return nil
+}
+
+func randID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// resolveCmdPaths rewrites the absolute paths of a shell command so they point inside the sandbox
+func resolveCmdPaths(sandbox, cmd string) string {
+	fields := strings.Fields(cmd)
+	for i, f := range fields {
+		if strings.HasPrefix(f, "/") {
+			fields[i] = filepath.Join(sandbox, filepath.Clean(f))
+		}
+	}
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,418 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ifx "github.com/nayarsystems/idefix-go"
+	"github.com/nayarsystems/idefix-go/idefixtest"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+const tout = time.Second * 5
+
+func setup(t *testing.T, params Params) (*Device, *ifx.Client) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+
+	dc, err := s.Connect(context.Background(), "dev", "devToken")
+	require.NoError(t, err)
+	t.Cleanup(dc.Disconnect)
+
+	params.Client = dc
+	if params.Sandbox == "" {
+		params.Sandbox = t.TempDir()
+	}
+	d, err := New(params)
+	require.NoError(t, err)
+	t.Cleanup(d.Close)
+
+	c, err := s.Connect(context.Background(), "svc", "svcToken")
+	require.NoError(t, err)
+	t.Cleanup(c.Disconnect)
+	return d, c
+}
+
+func TestFiles(t *testing.T) {
+	d, c := setup(t, Params{})
+
+	data := bytes.Repeat([]byte("0123456789"), 100*1024)
+	hash, err := ifx.FileWrite(c, "dev", "/opt/app/firmware.bin", data, 0744, tout)
+	require.NoError(t, err)
+
+	stored, err := os.ReadFile(filepath.Join(d.Sandbox(), "opt/app/firmware.bin"))
+	require.NoError(t, err)
+	require.Equal(t, data, stored)
+
+	remoteHash, err := ifx.FileSHA256Hex(c, "dev", "/opt/app/firmware.bin", tout)
+	require.NoError(t, err)
+	require.Equal(t, hash, remoteHash)
+
+	// Chunks must have been cleaned up
+	files, err := ifx.ListDir(c, "dev", "/tmp", tout)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	require.NoError(t, ifx.FileCopy(c, "dev", "/opt/app/firmware.bin", "/opt/app/firmware.bak", tout))
+	read, err := ifx.FileRead(c, "dev", "/opt/app/firmware.bak", tout)
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+
+	require.NoError(t, ifx.Move(c, "dev", "/opt/app/firmware.bak", "/opt/old/firmware.bin", tout))
+	files, err = ifx.ListDir(c, "dev", "/opt", tout)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	for _, f := range files {
+		require.True(t, f.IsDir)
+	}
+
+	require.NoError(t, ifx.Remove(c, "dev", "/opt/old", tout))
+	_, err = ifx.FileRead(c, "dev", "/opt/old/firmware.bin", tout)
+	require.Error(t, err)
+
+	// Paths can not escape from the sandbox
+	require.NoError(t, ifx.FileCopy(c, "dev", "/opt/app/firmware.bin", "../../firmware.bin", tout))
+	_, err = os.Stat(filepath.Join(d.Sandbox(), "firmware.bin"))
+	require.NoError(t, err)
+
+	free, err := ifx.GetFree(c, "dev", "", tout)
+	require.NoError(t, err)
+	require.EqualValues(t, DefaultFreeSpace, free)
+}
+
+func TestExec(t *testing.T) {
+	_, c := setup(t, Params{})
+
+	res, err := ifx.ShellExec(c, "dev", "echo hello > /out.txt && cat /out.txt", tout)
+	require.NoError(t, err)
+	require.True(t, res.Success)
+	require.Equal(t, "hello\n", res.Stdout)
+
+	res, err = ifx.ShellExec(c, "dev", "exit 3", tout)
+	require.NoError(t, err)
+	require.False(t, res.Success)
+	require.Equal(t, 3, res.Code)
+}
+
+func TestSys(t *testing.T) {
+	exits := make(chan *m.ExitReqMsg, 2)
+	d, c := setup(t, Params{
+		Info:   &m.SysInfo{DeviceInfo: m.DeviceInfo{Version: "1.2.3"}},
+		OnExit: func(req *m.ExitReqMsg) { exits <- req },
+	})
+
+	info := &m.SysInfoResMsg{}
+	require.NoError(t, c.Call2("dev", &m.Message{To: TopicCmdSysInfo, Data: &m.SysInfoReqMsg{}}, info, tout))
+	require.Equal(t, "dev", info.Address)
+	require.Equal(t, "1.2.3", info.Version)
+	require.EqualValues(t, 1, info.BootCnt)
+
+	d.Reboot()
+	require.NoError(t, c.Call2("dev", &m.Message{To: TopicCmdSysInfo, Data: &m.SysInfoReqMsg{}}, info, tout))
+	require.EqualValues(t, 2, info.BootCnt)
+
+	exit := &m.ExitReqMsg{ExitCode: 2, ExitCause: "test"}
+	data, err := exit.ToMsi()
+	require.NoError(t, err)
+	_, err = c.Call("dev", &m.Message{To: TopicCmdSysExit, Data: data}, tout)
+	require.NoError(t, err)
+	require.Equal(t, "test", (<-exits).ExitCause)
+
+	res, err := ifx.ExitToUpdate(c, "dev", m.UpdateTypeIdefixUpgrade, "upgrade", time.Second, time.Second, tout)
+	require.NoError(t, err)
+	require.Equal(t, "upgrade", res.Cause)
+	require.Equal(t, TopicCmdUpdate, (<-exits).Source)
+
+	_, err = c.Call("dev", &m.Message{To: "os.cmd.file.read.unknown"}, tout)
+	require.Error(t, err)
+}
+
+func TestStreams(t *testing.T) {
+	d, c := setup(t, Params{})
+
+	sub, err := c.NewSubscriberStream("dev", "sensor.temp", 10, false, time.Minute)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return d.Emit("sensor.temp", 21.5) > 0 }, tout, time.Millisecond*10)
+	select {
+	case msg := <-sub.Channel():
+		require.Equal(t, "sensor.temp", msg.To)
+		require.Equal(t, 21.5, msg.Data)
+	case <-time.After(tout):
+		t.Fatal("stream message not received")
+	}
+
+	local := d.NewSubscriber(10, "actuator")
+	defer local.Close()
+	pub, err := c.NewPublisherStream("dev", "actuator", 10, false, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, pub.Publish(map[string]any{"on": true}, "led"))
+	msg, err := local.WaitOne(tout)
+	require.NoError(t, err)
+	require.Equal(t, "actuator.led", msg.To)
+	require.Equal(t, true, msg.Data.(map[string]any)["on"])
+
+	require.Equal(t, 2, d.Streams())
+	require.NoError(t, sub.Close())
+	require.NoError(t, pub.Close())
+	require.Equal(t, 0, d.Streams())
+
+	// Refreshing a stream unknown to the device fails
+	_, err = c.Call("dev", &m.Message{To: m.TopicRemoteSubscribe, Data: map[string]any{"id": "unknown"}}, tout)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), errInvalidId.Error())
+}
+
+func TestMultiTopicStreams(t *testing.T) {
+	d, c := setup(t, Params{})
+
+	// The topics are received through a single channel, with a remote stream per unrelated topic
+	sub, err := c.NewMultiSubscriberStream("dev", []string{"sensor.temp", "alarm.*"}, 10, false, time.Minute)
+	require.NoError(t, err)
+	defer sub.Close()
+	require.Equal(t, 2, d.Streams())
+
+	receive := func() *m.Message {
+		select {
+		case msg := <-sub.Channel():
+			return msg
+		case <-time.After(tout):
+			t.Fatal("stream message not received")
+		}
+		return nil
+	}
+
+	require.Eventually(t, func() bool { return d.Emit("sensor.temp", 21.5) > 0 }, tout, time.Millisecond*10)
+	require.Equal(t, "sensor.temp", receive().To)
+	d.Emit("other", 1)
+	d.Emit("alarm.fire.kitchen", true)
+	require.Equal(t, "alarm.fire.kitchen", receive().To)
+
+	require.NoError(t, sub.AddTopics("other", "sensor.temp"))
+	require.Equal(t, []string{"sensor.temp", "alarm.*", "other"}, sub.Topics())
+	require.Equal(t, 3, d.Streams())
+	require.Eventually(t, func() bool { return d.Emit("other", 2) > 0 }, tout, time.Millisecond*10)
+	require.Equal(t, "other", receive().To)
+
+	require.NoError(t, sub.RemoveTopics("alarm.*"))
+	require.Error(t, sub.RemoveTopics("alarm.*"))
+	require.Equal(t, []string{"sensor.temp", "other"}, sub.Topics())
+	require.Equal(t, 2, d.Streams())
+	d.Emit("alarm.fire.kitchen", true)
+	d.Emit("sensor.temp", 22.0)
+	require.Equal(t, "sensor.temp", receive().To)
+
+	// Related topics share a remote stream
+	require.NoError(t, sub.AddTopics("sensor.hum"))
+	require.Equal(t, 2, d.Streams())
+	require.Eventually(t, func() bool { return d.Emit("sensor.hum", 40) > 0 }, tout, time.Millisecond*10)
+	require.Equal(t, "sensor.hum", receive().To)
+	d.Emit("sensor.pressure", 1013)
+	d.Emit("sensor.temp", 23.0)
+	require.Equal(t, "sensor.temp", receive().To)
+
+	require.NoError(t, sub.Close())
+	require.Equal(t, 0, d.Streams())
+
+	// Payload-only streams deliver the messages along with their device topic
+	all, err := c.NewMultiSubscriberStream("dev", []string{"*"}, 10, true, time.Minute)
+	require.NoError(t, err)
+	defer all.Close()
+	require.Eventually(t, func() bool { return d.Emit("sensor.hum", 40) > 0 }, tout, time.Millisecond*10)
+	select {
+	case msg := <-all.Channel():
+		require.Equal(t, "sensor.hum", msg.To)
+		require.EqualValues(t, 40, msg.Data)
+	case <-time.After(tout):
+		t.Fatal("stream message not received")
+	}
+}
+
+func TestStreamsSurviveReboot(t *testing.T) {
+	d, c := setup(t, Params{})
+
+	events := make(chan ifx.StreamEvent, 100)
+	defer c.StreamManager().OnEvent(func(ev ifx.StreamEvent) {
+		if ev.Kind != ifx.StreamRefreshed {
+			events <- ev
+		}
+	})()
+	type eventKey struct {
+		kind      ifx.StreamEventKind
+		publisher bool
+	}
+	seen := map[eventKey]bool{}
+	waitEvent := func(kind ifx.StreamEventKind, publisher bool) {
+		for !seen[eventKey{kind, publisher}] {
+			select {
+			case ev := <-events:
+				seen[eventKey{ev.Kind, ev.Publisher}] = true
+			case <-time.After(tout):
+				t.Fatalf("stream %s event not received", kind)
+			}
+		}
+	}
+
+	sub, err := c.NewSubscriberStream("dev", "sensor.temp", 10, false, time.Millisecond*400)
+	require.NoError(t, err)
+	defer sub.Close()
+	waitEvent(ifx.StreamOpened, false)
+	local := d.NewSubscriber(10, "actuator")
+	defer local.Close()
+	pub, err := c.NewPublisherStream("dev", "actuator", 10, false, time.Millisecond*400)
+	require.NoError(t, err)
+	defer pub.Close()
+	waitEvent(ifx.StreamOpened, true)
+	require.Equal(t, 2, c.StreamManager().Len())
+
+	// The device forgets the streams, which are created again on the next keepalive
+	d.Reboot()
+	require.Equal(t, 0, d.Streams())
+	waitEvent(ifx.StreamRestored, false)
+	waitEvent(ifx.StreamRestored, true)
+	require.Equal(t, 2, d.Streams())
+	require.NoError(t, sub.Context().Err())
+	require.NoError(t, pub.Context().Err())
+
+	require.Eventually(t, func() bool { return d.Emit("sensor.temp", 21.5) > 0 }, tout, time.Millisecond*10)
+	select {
+	case msg := <-sub.Channel():
+		require.Equal(t, 21.5, msg.Data)
+	case <-time.After(tout):
+		t.Fatal("stream message not received")
+	}
+	require.NoError(t, pub.Publish(true, "led"))
+	msg, err := local.WaitOne(tout)
+	require.NoError(t, err)
+	require.Equal(t, "actuator.led", msg.To)
+
+	require.NoError(t, sub.Close())
+	require.NoError(t, pub.Close())
+	require.Equal(t, 0, c.StreamManager().Len())
+}
+
+func TestStreamConn(t *testing.T) {
+	d, c := setup(t, Params{})
+
+	local := d.NewSubscriber(10, "tun.cmd.frame")
+	defer local.Close()
+	conn, err := c.NewStreamConn("dev", ifx.StreamConnOptions{ReadTopic: "tun.evt.frame", WriteTopic: "tun.cmd.frame", CloseFrame: true})
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, "dev/tun.cmd.frame", conn.RemoteAddr().String())
+
+	n, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	msg, err := local.WaitOne(tout)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ping"), msg.Data.(map[string]any)["frame"])
+
+	// Each Read returns the data of one frame
+	require.Eventually(t, func() bool { return d.Emit("tun.evt.frame", map[string]any{"frame": []byte("pong")}) > 0 }, tout, time.Millisecond*10)
+	d.Emit("tun.evt.frame", map[string]any{"frame": []byte("again")})
+	buf := make([]byte, 3)
+	n, err = conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "pon", string(buf[:n]))
+	n, err = conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "g", string(buf[:n]))
+	buf = make([]byte, 100)
+	n, err = conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "again", string(buf[:n]))
+
+	// Deadlines
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Millisecond*50)))
+	_, err = conn.Read(buf)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.NoError(t, conn.SetDeadline(time.Now().Add(-time.Second)))
+	_, err = conn.Write([]byte("late"))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.NoError(t, conn.SetDeadline(time.Time{}))
+
+	// The device closes its end
+	d.Emit("tun.evt.frame", map[string]any{"close": true})
+	_, err = conn.Read(buf)
+	require.ErrorIs(t, err, io.EOF)
+
+	// Closing tells the device and unblocks the pending reads
+	require.NoError(t, conn.Close())
+	msg, err = local.WaitOne(tout)
+	require.NoError(t, err)
+	require.Equal(t, true, msg.Data.(map[string]any)["close"])
+	_, err = conn.Write([]byte("closed"))
+	require.ErrorIs(t, err, net.ErrClosed)
+	require.Equal(t, 0, d.Streams())
+}
+
+func TestStreamConnClose(t *testing.T) {
+	d, c := setup(t, Params{})
+
+	local := d.NewSubscriber(10, "tun.cmd.frame")
+	defer local.Close()
+	conn, err := c.NewStreamConn("dev", ifx.StreamConnOptions{ReadTopic: "tun.evt.frame", WriteTopic: "tun.cmd.frame"})
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		_, err := conn.Read(make([]byte, 10))
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	require.NoError(t, conn.Close())
+	select {
+	case err := <-done:
+		require.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(tout):
+		t.Fatal("read not unblocked")
+	}
+
+	// Without the CloseFrame option, the device is not sent anything
+	_, err = local.WaitOne(time.Millisecond * 100)
+	require.Error(t, err)
+}
+
+func TestSequencedStreams(t *testing.T) {
+	d, c := setup(t, Params{})
+
+	sub, err := c.NewSubscriberStream("dev", "sensor.temp", 10, false, time.Minute, ifx.StreamOptions{Sequenced: true, QoS: 1})
+	require.NoError(t, err)
+	defer sub.Close()
+	require.Eventually(t, func() bool { return d.Emit("sensor.temp", 20.0) > 0 }, tout, time.Millisecond*10)
+	d.Emit("sensor.temp", 21.0)
+	for _, v := range []float64{20, 21} {
+		select {
+		case msg := <-sub.Channel():
+			require.Equal(t, v, msg.Data)
+		case <-time.After(tout):
+			t.Fatal("stream message not received")
+		}
+	}
+	st := sub.Stats()
+	require.EqualValues(t, 2, st.Received)
+	require.Zero(t, st.Gaps)
+	require.Zero(t, st.Duplicates)
+
+	_, err = c.NewSubscriberStream("dev", "sensor.temp", 10, true, time.Minute, ifx.StreamOptions{Sequenced: true})
+	require.Error(t, err)
+}
+
+func TestStreamExpiration(t *testing.T) {
+	d, c := setup(t, Params{})
+
+	res := &m.StreamCreateSubResMsg{}
+	require.NoError(t, c.Call2("dev", &m.Message{To: m.TopicRemoteSubscribe, Data: &m.StreamCreateMsg{
+		TargetTopic: "sensor",
+		Timeout:     time.Second,
+	}}, res, tout))
+	require.Equal(t, 1, d.Streams())
+	require.Eventually(t, func() bool { return d.Streams() == 0 }, tout, time.Millisecond*50)
+}
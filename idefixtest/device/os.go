@@ -0,0 +1,242 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+)
+
+// fsError maps file system errors to idefix errors
+func fsError(err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return ie.ErrNotFound.WithErr(err)
+	case errors.Is(err, fs.ErrPermission):
+		return ie.ErrPermissionDenied.WithErr(err)
+	case errors.Is(err, fs.ErrExist):
+		return ie.ErrAlreadyExists.WithErr(err)
+	}
+	return ie.ErrInternal.WithErr(err)
+}
+
+func (d *Device) fileRead(msg *m.Message) (any, error) {
+	var req m.FileReadMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(d.Path(req.Path))
+	if err != nil {
+		return nil, fsError(err)
+	}
+	return &m.FileReadResMsg{Data: data}, nil
+}
+
+func (d *Device) fileWrite(msg *m.Message) (any, error) {
+	var req m.FileWriteMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	path := d.Path(req.Path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fsError(err)
+	}
+	mode := fs.FileMode(req.Mode)
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := os.WriteFile(path, req.Data, mode); err != nil {
+		return nil, fsError(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return nil, fsError(err)
+	}
+	hash := sha256.Sum256(req.Data)
+	return &m.FileWriteResMsg{Hash: hash[:]}, nil
+}
+
+func (d *Device) fileSize(msg *m.Message) (any, error) {
+	var req m.FileSizeMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(d.Path(req.Path))
+	if err != nil {
+		return nil, fsError(err)
+	}
+	return &m.FileSizeResMsg{Size: info.Size()}, nil
+}
+
+func (d *Device) fileSHA256(msg *m.Message) (any, error) {
+	var req m.FileSHA256Msg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(d.Path(req.Path))
+	if err != nil {
+		return nil, fsError(err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, fsError(err)
+	}
+	return &m.FileSHA256ResMsg{Hash: hash.Sum(nil)}, nil
+}
+
+func (d *Device) fileCopy(msg *m.Message) (any, error) {
+	var req m.FileCopyMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	src, err := os.Open(d.Path(req.SrcPath))
+	if err != nil {
+		return nil, fsError(err)
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return nil, fsError(err)
+	}
+
+	dstPath := d.Path(req.DstPath)
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return nil, fsError(err)
+	}
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return nil, fsError(err)
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		return nil, fsError(err)
+	}
+	return &m.FileCopyResMsg{}, nil
+}
+
+func (d *Device) mkdir(msg *m.Message) (any, error) {
+	var req m.MkdirMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	var path string
+	switch req.Type {
+	case m.MkdirTypeVolatile:
+		path = filepath.Join("/tmp", req.Path)
+	case m.MkdirTypeScratch:
+		path = filepath.Join("/scratch", req.Path)
+	case m.MkdirTypeAbsolute:
+		path = req.Path
+	default:
+		return nil, ie.ErrInvalidParams.Withf("unknown mkdir type %d", req.Type)
+	}
+	if err := os.MkdirAll(d.Path(path), 0755); err != nil {
+		return nil, fsError(err)
+	}
+	return &m.MkdirResMsg{Path: path}, nil
+}
+
+func (d *Device) remove(msg *m.Message) (any, error) {
+	var req m.RemoveMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	path := d.Path(req.Path)
+	if path == d.sandbox {
+		return nil, ie.ErrPermissionDenied.With("cannot remove the root directory")
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return nil, fsError(err)
+	}
+	return &m.RemoveResMsg{}, nil
+}
+
+func (d *Device) move(msg *m.Message) (any, error) {
+	var req m.MoveMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	dstPath := d.Path(req.DstPath)
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return nil, fsError(err)
+	}
+	if err := os.Rename(d.Path(req.SrcPath), dstPath); err != nil {
+		return nil, fsError(err)
+	}
+	return &m.MoveResMsg{}, nil
+}
+
+func (d *Device) free(msg *m.Message) (any, error) {
+	var req m.FreeSpaceMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(d.Path(req.Path)); err != nil {
+		return nil, fsError(err)
+	}
+	return &m.FreeSpaceResMsg{Free: d.p.FreeSpace}, nil
+}
+
+func (d *Device) listDir(msg *m.Message) (any, error) {
+	var req m.ListDirMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(d.Path(req.Path))
+	if err != nil {
+		return nil, fsError(err)
+	}
+	res := &m.ListDirResMsg{Files: []*m.FileInfo{}}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		res.Files = append(res.Files, &m.FileInfo{
+			Name:  e.Name(),
+			IsDir: e.IsDir(),
+			Size:  info.Size(),
+			Mode:  uint32(info.Mode()),
+		})
+	}
+	return res, nil
+}
+
+func (d *Device) exec(msg *m.Message) (any, error) {
+	var req m.ExecReqMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	return d.p.Exec(d.ctx, req.Cmd)
+}
+
+// shellExec returns the default [ExecFunc], which runs the commands inside the sandbox directory
+func shellExec(sandbox string) ExecFunc {
+	return func(ctx context.Context, cmd string) (*m.ExecResMsg, error) {
+		var stdout, stderr bytes.Buffer
+		c := exec.CommandContext(ctx, "sh", "-c", resolveCmdPaths(sandbox, cmd))
+		c.Dir = sandbox
+		c.Stdout = &stdout
+		c.Stderr = &stderr
+
+		res := &m.ExecResMsg{}
+		err := c.Run()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			return nil, ie.ErrInternal.WithErr(err)
+		}
+		res.Code = c.ProcessState.ExitCode()
+		res.Success = res.Code == 0
+		res.Stdout = stdout.String()
+		res.Stderr = stderr.String()
+		return res, nil
+	}
+}
@@ -0,0 +1,230 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/nayarsystems/idefix-go/minips"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// stream is a remote subscriber (device -> client) or publisher (client -> device) stream
+type stream struct {
+	id          string
+	publisher   bool
+	target      string
+	publicTopic string
+	payloadOnly bool
+	subtopics   bool
+	sequenced   bool
+	qos         byte
+	seq         uint64
+	ctx         context.Context
+	cancel      context.CancelFunc
+	timer       *time.Timer
+}
+
+// errInvalidId is returned when a client refers to a stream the device does not know
+// (e.g. it expired or the device was rebooted)
+var errInvalidId = ie.ErrNotFound.With("invalid id")
+
+// Streams returns the number of active streams.
+func (d *Device) Streams() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return len(d.streams)
+}
+
+func (d *Device) streamSub(msg *m.Message) (any, error) {
+	return d.streamCreate(msg, false)
+}
+
+func (d *Device) streamStartPub(msg *m.Message) (any, error) {
+	return d.streamCreate(msg, true)
+}
+
+func (d *Device) streamUnsub(msg *m.Message) (any, error) {
+	return d.streamDelete(msg, false)
+}
+
+func (d *Device) streamStopPub(msg *m.Message) (any, error) {
+	return d.streamDelete(msg, true)
+}
+
+func (d *Device) streamCreate(msg *m.Message, publisher bool) (any, error) {
+	var req m.StreamCreateMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	if req.Id != "" {
+		return d.streamRefresh(&req, publisher)
+	}
+
+	if req.TargetTopic == "" && !req.AllowSubtopics {
+		return nil, ie.ErrEmptyTopic
+	}
+
+	s := &stream{
+		id:          randID(),
+		publisher:   publisher,
+		target:      req.TargetTopic,
+		payloadOnly: req.PayloadOnly,
+		subtopics:   req.AllowSubtopics,
+		sequenced:   req.Sequenced && !req.PayloadOnly,
+		qos:         req.QoS,
+	}
+	public := fmt.Sprintf("%s/%s", d.Address(), s.id)
+	s.publicTopic = fmt.Sprintf("%s/%s", m.MqttPublicPrefix, public)
+	s.ctx, s.cancel = context.WithCancel(d.ctx)
+
+	if publisher {
+		if err := d.c.Transport().Subscribe(s.publicTopic, s.qos, d.publisherHandler(s)); err != nil {
+			s.cancel()
+			return nil, ie.ErrInternal.WithErr(err)
+		}
+	} else {
+		go d.runSubscriber(s, d.bus.NewSubscriber(100, s.target))
+	}
+
+	d.mutex.Lock()
+	d.streams[s.id] = s
+	if req.Timeout > 0 {
+		s.timer = time.AfterFunc(req.Timeout, func() { d.removeStream(s.id) })
+	}
+	d.mutex.Unlock()
+
+	if publisher {
+		return &m.StreamCreatePubResMsg{Id: s.id, PublicTopic: public, PayloadOnly: s.payloadOnly}, nil
+	}
+	return &m.StreamCreateSubResMsg{Id: s.id, PublicTopic: public, PayloadOnly: s.payloadOnly}, nil
+}
+
+// streamRefresh handles the keepalive of an existing stream
+func (d *Device) streamRefresh(req *m.StreamCreateMsg, publisher bool) (any, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	s, ok := d.streams[req.Id]
+	if !ok || s.publisher != publisher {
+		return nil, errInvalidId
+	}
+	if s.timer != nil && req.Timeout > 0 {
+		s.timer.Reset(req.Timeout)
+	}
+	public := strings.TrimPrefix(s.publicTopic, m.MqttPublicPrefix+"/")
+	if publisher {
+		return &m.StreamCreatePubResMsg{Id: s.id, PublicTopic: public, PayloadOnly: s.payloadOnly}, nil
+	}
+	return &m.StreamCreateSubResMsg{Id: s.id, PublicTopic: public, PayloadOnly: s.payloadOnly}, nil
+}
+
+func (d *Device) streamDelete(msg *m.Message, publisher bool) (any, error) {
+	var req m.StreamDeleteMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	d.mutex.Lock()
+	s, ok := d.streams[req.Id]
+	d.mutex.Unlock()
+	if !ok || s.publisher != publisher {
+		return nil, errInvalidId
+	}
+	d.removeStream(req.Id)
+	return &m.StreamDeleteResMsg{}, nil
+}
+
+func (d *Device) removeStream(id string) {
+	d.mutex.Lock()
+	s, ok := d.streams[id]
+	delete(d.streams, id)
+	d.mutex.Unlock()
+	if ok {
+		d.stopStream(s)
+	}
+}
+
+func (d *Device) closeStreams() {
+	d.mutex.Lock()
+	streams := d.streams
+	d.streams = make(map[string]*stream)
+	d.mutex.Unlock()
+	for _, s := range streams {
+		d.stopStream(s)
+	}
+}
+
+func (d *Device) stopStream(s *stream) {
+	s.cancel()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	if s.publisher {
+		if tr := d.c.Transport(); tr != nil {
+			tr.Unsubscribe(s.publicTopic)
+		}
+	}
+}
+
+// runSubscriber forwards the local bus messages to the stream public topic
+func (d *Device) runSubscriber(s *stream, sub *minips.Subscriber[*m.Message]) {
+	defer sub.Close()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case msg := <-sub.Channel():
+			if !s.subtopics && msg.To != s.target {
+				continue
+			}
+			var payload any = msg.Data
+			if !s.payloadOnly {
+				streamMsg := m.StreamMsg{SourceTopic: msg.To, Payload: msg.Data}
+				if s.sequenced {
+					s.seq++
+					streamMsg.Seq = s.seq
+					streamMsg.Time = time.Now().UnixMilli()
+				}
+				payload = streamMsg
+			}
+			data, err := msgpack.Marshal(payload)
+			if err != nil {
+				continue
+			}
+			d.c.Transport().Publish(s.ctx, s.publicTopic, s.qos, data)
+		}
+	}
+}
+
+// publisherHandler returns the handler delivering the messages received on
+// the stream public topic to the local bus
+func (d *Device) publisherHandler(s *stream) func(topic string, payload []byte) {
+	return func(topic string, payload []byte) {
+		if s.ctx.Err() != nil {
+			return
+		}
+		if s.payloadOnly {
+			var data any
+			if err := msgpack.Unmarshal(payload, &data); err != nil {
+				return
+			}
+			d.bus.Publish(s.target, &m.Message{To: s.target, Data: data})
+			return
+		}
+		var msg m.StreamMsg
+		if err := msgpack.Unmarshal(payload, &msg); err != nil {
+			return
+		}
+		target := msg.SourceTopic
+		if target == "" {
+			target = s.target
+		}
+		if target != s.target && !strings.HasPrefix(target, s.target+".") {
+			return
+		}
+		d.bus.Publish(target, &m.Message{To: target, Data: msg.Payload})
+	}
+}
@@ -0,0 +1,55 @@
+package device
+
+import (
+	"time"
+
+	m "github.com/nayarsystems/idefix-go/messages"
+)
+
+func (d *Device) sysInfo(msg *m.Message) (any, error) {
+	var req m.SysInfoReqMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	res := &m.SysInfoResMsg{}
+	if d.p.Info != nil {
+		res.SysInfo = *d.p.Info
+	}
+	res.Address = d.Address()
+	d.mutex.Lock()
+	res.BootCnt = d.bootCnt
+	res.Uptime = time.Since(d.start)
+	d.mutex.Unlock()
+	if req.Report {
+		res.Report = map[string]m.SysInfoReportMsg{}
+	}
+	return res, nil
+}
+
+func (d *Device) sysExit(msg *m.Message) (any, error) {
+	var req m.ExitReqMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	if d.p.OnExit != nil {
+		d.p.OnExit(&req)
+	}
+	return nil, nil
+}
+
+func (d *Device) update(msg *m.Message) (any, error) {
+	var req m.UpdateMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	if d.p.OnExit != nil {
+		d.p.OnExit(&m.ExitReqMsg{
+			Source:        TopicCmdUpdate,
+			StopDelay:     req.StopDelay,
+			WaitHaltDelay: req.WaitHaltDelay,
+			ExitCause:     req.Cause,
+		})
+	}
+	return &m.UpdateResMsg{UpdateMsg: req}, nil
+}
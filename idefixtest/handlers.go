@@ -0,0 +1,823 @@
+package idefixtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nayarsystems/bstates"
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+)
+
+const (
+	defaultEventsLimit = 100
+	maxEventsLimit     = 1000
+)
+
+type commandHandler func(s *Server, sess *session, msg *m.Message) (any, error)
+
+var commandHandlers map[string]commandHandler
+
+func init() {
+	commandHandlers = map[string]commandHandler{
+		m.CmdDomainCreate:            (*Server).domainCreate,
+		m.CmdDomainUpdate:            (*Server).domainUpdate,
+		m.CmdDomainUpdateAccessRules: (*Server).domainUpdateAccessRules,
+		m.CmdDomainDelete:            (*Server).domainDelete,
+		m.CmdDomainGet:               (*Server).domainGet,
+		m.CmdDomainAssign:            (*Server).domainAssign,
+		m.CmdDomainListAddresses:     (*Server).domainListAddresses,
+		m.CmdDomainListGroups:        (*Server).domainListGroups,
+		m.CmdDomainCountAddresses:    (*Server).domainCountAddresses,
+		m.CmdDomainTree:              (*Server).domainTree,
+		m.CmdDomainEnvironmentGet:    (*Server).domainEnvironmentGet,
+		m.CmdDomainEnvironmentSet:    (*Server).domainEnvironmentSet,
+		m.CmdDomainEnvironmentUnset:  (*Server).domainEnvironmentUnset,
+		m.CmdGroupAddAddress:         (*Server).groupAdd,
+		m.CmdGroupRemoveAddress:      (*Server).groupRemove,
+		m.CmdGroupGetAddresses:       (*Server).groupGet,
+		m.CmdAddressDomainGet:        (*Server).addressDomainGet,
+		m.CmdAddressGetGroups:        (*Server).addressGetGroups,
+		m.CmdEventsCreate:            (*Server).eventsCreate,
+		m.CmdEventsGet:               (*Server).eventsGet,
+		m.CmdSchemasCreate:           (*Server).schemasCreate,
+		m.CmdSchemasGet:              (*Server).schemasGet,
+		m.CmdSessionCreate:           (*Server).sessionCreate,
+		m.CmdSessionList:             (*Server).sessionList,
+		m.CmdSessionDelete:           (*Server).sessionDelete,
+	}
+}
+
+// parse fills the request struct from the message data
+func parse(msg *m.Message, req any) error {
+	if err := m.ParseMsg(msg.Data, req); err != nil {
+		return ie.ErrInvalidParams.WithErr(err)
+	}
+	return nil
+}
+
+// response converts struct based responses to msi, as the real service does
+func response(v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch reflect.Indirect(reflect.ValueOf(v)).Kind() {
+	case reflect.Struct:
+		return m.ToMsi(v)
+	}
+	return v, nil
+}
+
+/************/
+/*   Login  */
+/************/
+
+func (s *Server) login(sessionID string, msg *m.Message) (any, error) {
+	var req m.LoginMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	if req.Address == "" {
+		return nil, ie.ErrMissingAddress
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	a, ok := s.addresses[req.Address]
+	if !ok {
+		if req.NoCreate {
+			return nil, ie.ErrAddressNotFound
+		}
+		a = &address{name: req.Address, token: req.Token}
+		s.addresses[req.Address] = a
+	}
+	if a.token != req.Token {
+		return nil, ie.ErrInvalidToken
+	}
+	a.meta = req.Meta
+
+	if a.domain != "" {
+		d := s.createDomain(a.domain)
+		for _, g := range req.Groups {
+			addToGroup(d, g, a.name)
+		}
+	}
+
+	s.sessions[sessionID] = &session{
+		id:       sessionID,
+		address:  a.name,
+		encoding: req.Encoding,
+	}
+	return true, nil
+}
+
+/*************/
+/*  Domains  */
+/*************/
+
+func (s *Server) domainCreate(sess *session, msg *m.Message) (any, error) {
+	var req m.DomainCreateMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	if req.Domain == "" {
+		return nil, ie.ErrMissingDomain
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.domains[req.Domain]; ok {
+		return nil, ie.ErrAlreadyExists
+	}
+	d := s.createDomain(req.Domain)
+	d.AccessRules = req.AccessRules
+	if req.Env != nil {
+		d.Env = req.Env
+	}
+	d.Creation = time.Now()
+	d.LastUpdate = d.Creation
+	return response(&m.DomainCreateResponseMsg{Domain: d.Domain})
+}
+
+func (s *Server) domainUpdate(sess *session, msg *m.Message) (any, error) {
+	var req m.DomainUpdateMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d, ok := s.domains[req.Domain]
+	if !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	if req.AccessRules != "" {
+		d.AccessRules = req.AccessRules
+	}
+	if req.Env != nil {
+		d.Env = req.Env
+	}
+	d.LastUpdate = time.Now()
+	return response(&m.DomainUpdateResponseMsg{Domain: d.Domain})
+}
+
+func (s *Server) domainUpdateAccessRules(sess *session, msg *m.Message) (any, error) {
+	var req m.DomainUpdateAccessRulesMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d, ok := s.domains[req.Domain]
+	if !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	d.AccessRules = req.AccessRules
+	d.LastUpdate = time.Now()
+	return response(&m.DomainUpdateAccessRulesResponseMsg{})
+}
+
+func (s *Server) domainDelete(sess *session, msg *m.Message) (any, error) {
+	var req m.DomainDeleteMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.domains[req.Domain]; !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	delete(s.domains, req.Domain)
+	for _, a := range s.addresses {
+		if a.domain == req.Domain {
+			a.domain = ""
+		}
+	}
+	return true, nil
+}
+
+func (s *Server) domainGet(sess *session, msg *m.Message) (any, error) {
+	var req m.DomainGetMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	// Client.AddressDomainGet queries this command with an address instead of a domain
+	if req.Domain == "" {
+		return s.addressDomainGet(sess, msg)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d, ok := s.domains[req.Domain]
+	if !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	return response(&d.Domain)
+}
+
+func (s *Server) domainAssign(sess *session, msg *m.Message) (any, error) {
+	var req m.DomainAssignMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.domains[req.Domain]; !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	a, ok := s.addresses[req.Address]
+	if !ok {
+		return nil, ie.ErrAddressNotFound
+	}
+	a.domain = req.Domain
+	return true, nil
+}
+
+func (s *Server) domainListAddresses(sess *session, msg *m.Message) (any, error) {
+	var req m.DomainListAddressesMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.domains[req.Domain]; !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	names := s.domainAddresses(req.Domain)
+	if req.Skip >= uint(len(names)) {
+		names = nil
+	} else {
+		names = names[req.Skip:]
+	}
+	if req.Limit > 0 && req.Limit < uint(len(names)) {
+		names = names[:req.Limit]
+	}
+	res := &m.DomainListAddressesResponseMsg{Addresses: map[string]string{}}
+	for _, name := range names {
+		res.Addresses[name] = req.Domain
+	}
+	return response(res)
+}
+
+func (s *Server) domainCountAddresses(sess *session, msg *m.Message) (any, error) {
+	var req m.DomainCountAddressesMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.domains[req.Domain]; !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	return response(&m.DomainCountAddressesResponseMsg{Addresses: len(s.domainAddresses(req.Domain))})
+}
+
+func (s *Server) domainListGroups(sess *session, msg *m.Message) (any, error) {
+	var req m.DomainGetGroupsMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d, ok := s.domains[req.Domain]
+	if !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	res := &m.DomainGetGroupsResponseMsg{Groups: []string{}}
+	for g := range d.groups {
+		res.Groups = append(res.Groups, d.Domain.Domain+"#"+g)
+	}
+	sort.Strings(res.Groups)
+	return response(res)
+}
+
+func (s *Server) domainTree(sess *session, msg *m.Message) (any, error) {
+	var req m.DomainGetTreeMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.domains[req.Domain]; !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	tree := []string{}
+	for name := range s.domains {
+		if isSubdomain(name, req.Domain) {
+			tree = append(tree, name)
+		}
+	}
+	sort.Strings(tree)
+	return tree, nil
+}
+
+func (s *Server) domainEnvironmentGet(sess *session, msg *m.Message) (any, error) {
+	var req m.DomainEnvironmentGetMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d, ok := s.domains[req.Domain]
+	if !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	return response(&m.DomainEnvironmentGetResponseMsg{Environment: d.Env})
+}
+
+func (s *Server) domainEnvironmentSet(sess *session, msg *m.Message) (any, error) {
+	var req m.DomainEnvironmentSetMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d, ok := s.domains[req.Domain]
+	if !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	for k, v := range req.Environment {
+		d.Env[k] = v
+	}
+	return response(&m.DomainEnvironmentSetResponseMsg{Environment: d.Env})
+}
+
+func (s *Server) domainEnvironmentUnset(sess *session, msg *m.Message) (any, error) {
+	var req m.DomainEnvironmentUnsetMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d, ok := s.domains[req.Domain]
+	if !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	for _, k := range req.Keys {
+		delete(d.Env, k)
+	}
+	return response(&m.DomainEnvironmentUnsetResponseMsg{Environment: d.Env})
+}
+
+// domainAddresses returns the sorted addresses assigned to a domain
+func (s *Server) domainAddresses(domain string) []string {
+	names := []string{}
+	for _, a := range s.addresses {
+		if a.domain == domain {
+			names = append(names, a.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isSubdomain reports whether domain is equal to or nested under parent (e.g. "a.b" is nested under "b")
+func isSubdomain(domain, parent string) bool {
+	return domain == parent || strings.HasSuffix(domain, "."+parent)
+}
+
+/************/
+/*  Groups  */
+/************/
+
+func addToGroup(d *domain, group, address string) {
+	members, ok := d.groups[group]
+	if !ok {
+		members = make(map[string]struct{})
+		d.groups[group] = members
+	}
+	members[address] = struct{}{}
+}
+
+func (s *Server) groupAdd(sess *session, msg *m.Message) (any, error) {
+	var req m.GroupAddAddressMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d, ok := s.domains[req.Domain]
+	if !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	if _, ok := s.addresses[req.Address]; !ok {
+		return nil, ie.ErrAddressNotFound
+	}
+	addToGroup(d, req.Group, req.Address)
+	return response(&m.GroupAddAddressResponseMsg{})
+}
+
+func (s *Server) groupRemove(sess *session, msg *m.Message) (any, error) {
+	var req m.GroupRemoveAddressMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d, ok := s.domains[req.Domain]
+	if !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	members, ok := d.groups[req.Group]
+	if !ok {
+		return nil, ie.ErrNotFound.With("group not found")
+	}
+	delete(members, req.Address)
+	if len(members) == 0 {
+		delete(d.groups, req.Group)
+	}
+	return response(&m.GroupRemoveAddressResponseMsg{})
+}
+
+func (s *Server) groupGet(sess *session, msg *m.Message) (any, error) {
+	var req m.GroupGetAddressesMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.domains[req.Domain]; !ok {
+		return nil, ie.ErrDomainNotFound
+	}
+	res := &m.GroupGetAddressesResponseMsg{Addresses: map[string][]string{}}
+	for name, d := range s.domains {
+		if req.BubbleUp && !isSubdomain(req.Domain, name) {
+			continue
+		}
+		if !req.BubbleUp && !isSubdomain(name, req.Domain) {
+			continue
+		}
+		members := d.groups[req.Group]
+		if len(members) == 0 {
+			continue
+		}
+		addresses := make([]string, 0, len(members))
+		for a := range members {
+			addresses = append(addresses, a)
+		}
+		sort.Strings(addresses)
+		res.Addresses[name] = addresses
+	}
+	return response(res)
+}
+
+/*************/
+/*  Address  */
+/*************/
+
+func (s *Server) addressDomainGet(sess *session, msg *m.Message) (any, error) {
+	var req m.AddressDomainGetMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	if req.Address == "" {
+		req.Address = sess.address
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	a, ok := s.addresses[req.Address]
+	if !ok {
+		return nil, ie.ErrAddressNotFound
+	}
+	d, ok := s.domains[a.domain]
+	if !ok {
+		return nil, ie.ErrAddressNotAssigned
+	}
+	return response(&d.Domain)
+}
+
+func (s *Server) addressGetGroups(sess *session, msg *m.Message) (any, error) {
+	var req m.AddressGetGroupsMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.addresses[req.Address]; !ok {
+		return nil, ie.ErrAddressNotFound
+	}
+	res := &m.AddressGetGroupsResponseMsg{Groups: []string{}}
+	for name, d := range s.domains {
+		if req.Domain != "" && req.Domain != name {
+			continue
+		}
+		for g, members := range d.groups {
+			if _, ok := members[req.Address]; ok {
+				res.Groups = append(res.Groups, name+"#"+g)
+			}
+		}
+	}
+	sort.Strings(res.Groups)
+	return response(res)
+}
+
+/************/
+/*  Events  */
+/************/
+
+func (s *Server) eventsCreate(sess *session, msg *m.Message) (any, error) {
+	var req m.EventMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	if req.UID == "" {
+		return nil, ie.ErrInvalidParams.With("missing uid")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	domain := req.Domain
+	if domain == "" {
+		domain = s.addresses[sess.address].domain
+	}
+	if domain == "" {
+		return nil, ie.ErrAddressNotAssigned
+	}
+	s.storeEvent(&m.Event{
+		EventMsg: req,
+		Domain:   domain,
+		Address:  sess.address,
+	})
+
+	res := &m.EventResponseMsg{Ok: true}
+	if schemaId, err := m.BstatesParseSchemaIdFromType(req.Type); err == nil {
+		_, res.Schema = s.schemas[schemaId]
+	}
+	return response(res)
+}
+
+// storeEvent saves an event (if its UID is new) and wakes up the pending long polling requests
+func (s *Server) storeEvent(e *m.Event) bool {
+	if _, ok := s.eventUIDs[e.UID]; ok {
+		return false
+	}
+	stored := *e
+	if stored.Timestamp.IsZero() {
+		stored.Timestamp = time.Now()
+	}
+	s.eventUIDs[stored.UID] = struct{}{}
+	s.events = append(s.events, &storedEvent{seq: uint64(len(s.events) + 1), event: &stored})
+
+	close(s.newEvents)
+	s.newEvents = make(chan struct{})
+	return true
+}
+
+func (s *Server) eventsGet(sess *session, msg *m.Message) (any, error) {
+	var req m.EventsGetMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	if req.UID != "" {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for _, se := range s.events {
+			if se.event.UID == req.UID {
+				return response(&m.EventsGetUIDResponseMsg{Event: *se.event})
+			}
+		}
+		return nil, ie.ErrNotFound
+	}
+
+	if req.Domain == "" && req.Address == "" {
+		s.mutex.Lock()
+		req.Domain = s.addresses[sess.address].domain
+		s.mutex.Unlock()
+		if req.Domain == "" {
+			return nil, ie.ErrMissingDomain
+		}
+	}
+
+	var after uint64
+	if req.ContinuationID != "" {
+		var err error
+		after, err = strconv.ParseUint(req.ContinuationID, 10, 64)
+		if err != nil {
+			return nil, ie.ErrInvalidParams.With("invalid continuation id")
+		}
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultEventsLimit
+	}
+	if limit > maxEventsLimit {
+		limit = maxEventsLimit
+	}
+
+	deadline := time.Now().Add(req.Timeout)
+	for {
+		s.mutex.Lock()
+		events, last := s.queryEvents(&req, after, limit)
+		wait := s.newEvents
+		s.mutex.Unlock()
+
+		remaining := time.Until(deadline)
+		if len(events) > 0 || remaining <= 0 {
+			res := &m.EventsGetResponseMsg{Events: events, ContinuationID: req.ContinuationID}
+			if len(events) > 0 {
+				res.ContinuationID = strconv.FormatUint(last, 10)
+			}
+			return response(res)
+		}
+
+		t := time.NewTimer(remaining)
+		select {
+		case <-s.ctx.Done():
+			t.Stop()
+			return nil, ie.ErrContextClosed
+		case <-wait:
+			t.Stop()
+		case <-t.C:
+		}
+	}
+}
+
+// queryEvents returns the events matching the query stored after the given sequence number
+func (s *Server) queryEvents(req *m.EventsGetMsg, after uint64, limit uint) (events []*m.Event, last uint64) {
+	events = []*m.Event{}
+	for _, se := range s.events {
+		if se.seq <= after {
+			continue
+		}
+		e := se.event
+		if req.Domain != "" && !isSubdomain(e.Domain, req.Domain) {
+			continue
+		}
+		if req.Address != "" && e.Address != req.Address {
+			continue
+		}
+		if req.Type != "" && e.Type != req.Type {
+			continue
+		}
+		if !req.Since.IsZero() && e.Timestamp.Before(req.Since) {
+			continue
+		}
+		ec := *e
+		if req.NoPayload {
+			ec.Payload = nil
+		}
+		events = append(events, &ec)
+		last = se.seq
+		if uint(len(events)) >= limit {
+			break
+		}
+	}
+	return
+}
+
+/*************/
+/*  Schemas  */
+/*************/
+
+func (s *Server) schemasCreate(sess *session, msg *m.Message) (any, error) {
+	var req m.SchemaMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	schema := &bstates.StateSchema{}
+	if err := schema.UnmarshalJSON([]byte(req.Payload)); err != nil {
+		return nil, ie.ErrInvalidSchemaSyntax.WithErr(err)
+	}
+
+	res := &m.SchemaResponseMsg{SchemaMsg: req, Hash: schema.GetHashString()}
+	s.mutex.Lock()
+	s.schemas[res.Hash] = res
+	s.mutex.Unlock()
+	return response(res)
+}
+
+func (s *Server) schemasGet(sess *session, msg *m.Message) (any, error) {
+	var req m.SchemaGetMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	schema, ok := s.schemas[req.Hash]
+	if !ok {
+		return nil, ie.ErrSchemaNotFound
+	}
+	res := &m.SchemaGetResponseMsg{SchemaMsg: schema.SchemaMsg, Hash: schema.Hash}
+	if req.Check {
+		res.Payload = ""
+	}
+	return response(res)
+}
+
+/**************/
+/*  Sessions  */
+/**************/
+
+func (s *Server) sessionCreate(sess *session, msg *m.Message) (any, error) {
+	var req m.SessionCreateMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	if req.Address == "" {
+		req.Address = sess.address
+	}
+	id, err := randomID()
+	if err != nil {
+		return nil, ie.ErrInternal.WithErr(err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.addresses[req.Address]; !ok {
+		return nil, ie.ErrAddressNotFound
+	}
+	created := &session{id: id, address: req.Address, groups: req.Groups}
+	if req.Duration > 0 {
+		created.expires = time.Now().Add(req.Duration)
+	}
+	s.sessions[id] = created
+	return response(&m.SessionCreateResponseMsg{Session: id})
+}
+
+func (s *Server) sessionList(sess *session, msg *m.Message) (any, error) {
+	var req m.SessionListMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	if req.Address == "" {
+		req.Address = sess.address
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ids := []string{}
+	for id, ss := range s.sessions {
+		if ss.address == req.Address && !ss.expired() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	// Built by hand, as m.ToMsi does not keep the time values of nested structs
+	sessions := make([]any, 0, len(ids))
+	for _, id := range ids {
+		ss := s.sessions[id]
+		sessions = append(sessions, map[string]any{
+			"session": ss.id,
+			"address": ss.address,
+			"groups":  ss.groups,
+			"expires": ss.expires,
+		})
+	}
+	return map[string]any{"sessions": sessions}, nil
+}
+
+func (s *Server) sessionDelete(sess *session, msg *m.Message) (any, error) {
+	var req m.SessionDeleteMsg
+	if err := parse(msg, &req); err != nil {
+		return nil, err
+	}
+	if req.Session == "" && req.Address == "" {
+		req.Session = sess.id
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	deleted := 0
+	for id, ss := range s.sessions {
+		if id == req.Session || (req.Address != "" && ss.address == req.Address) {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+	return response(&m.SessionDeleteResponseMsg{Sessions: deleted})
+}
+
+// randomID returns a random hex identifier, like the session IDs of the clients
+func randomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
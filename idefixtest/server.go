@@ -0,0 +1,334 @@
+// Package idefixtest provides an in-process fake of the Idefix cloud, meant for
+// tests and local development.
+//
+// The [Server] speaks the same topic protocol as the real service over a
+// [ifx.LoopbackBroker]: clients publish their requests on "ifx/<session>/t/<flags>"
+// and receive the responses on "ifx/<session>/r/<flags>". It keeps all its state
+// (addresses, domains, groups, events and schemas) in memory, and routes messages
+// between the logged in addresses, so several clients (e.g. a service and a device)
+// can talk to each other through it.
+package idefixtest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	ifx "github.com/nayarsystems/idefix-go"
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+)
+
+// replyPrefix is used to route the answers of the messages forwarded between addresses.
+// The response field of a forwarded message is rewritten as "<replyPrefix>.<session>.<res>".
+const replyPrefix = "@r"
+
+// Server is an in-memory fake of the Idefix cloud.
+type Server struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	broker *ifx.LoopbackBroker
+	tr     *ifx.LoopbackTransport
+
+	mutex     sync.Mutex
+	sessions  map[string]*session
+	addresses map[string]*address
+	domains   map[string]*domain
+	schemas   map[string]*m.SchemaResponseMsg
+	events    []*storedEvent
+	eventUIDs map[string]struct{}
+	newEvents chan struct{}
+}
+
+type session struct {
+	id       string
+	address  string
+	encoding string
+	groups   []string
+	expires  time.Time // zero for the login sessions
+}
+
+func (s *session) expired() bool {
+	return !s.expires.IsZero() && time.Now().After(s.expires)
+}
+
+type address struct {
+	name   string
+	token  string
+	domain string
+	meta   map[string]any
+}
+
+type domain struct {
+	m.Domain
+	groups map[string]map[string]struct{} // group -> addresses
+}
+
+type storedEvent struct {
+	seq   uint64
+	event *m.Event
+}
+
+// NewServer creates a new [Server] attached to its own [ifx.LoopbackBroker].
+// The server must be closed with [Server.Close] when no longer needed.
+func NewServer() (*Server, error) {
+	return NewServerWithBroker(ifx.NewLoopbackBroker())
+}
+
+// NewServerWithBroker creates a new [Server] attached to the given broker.
+func NewServerWithBroker(broker *ifx.LoopbackBroker) (*Server, error) {
+	s := &Server{
+		broker:    broker,
+		sessions:  make(map[string]*session),
+		addresses: make(map[string]*address),
+		domains:   make(map[string]*domain),
+		schemas:   make(map[string]*m.SchemaResponseMsg),
+		eventUIDs: make(map[string]struct{}),
+		newEvents: make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	s.tr = broker.NewTransport()
+	if err := s.tr.Connect("idefix"); err != nil {
+		return nil, err
+	}
+	if err := s.tr.Subscribe(m.MqttIdefixPrefix+"/+/t/+", 1, s.receive); err != nil {
+		s.tr.Disconnect()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Broker returns the broker the server is attached to.
+func (s *Server) Broker() *ifx.LoopbackBroker {
+	return s.broker
+}
+
+// Close stops the server. Pending long polling requests are aborted.
+func (s *Server) Close() {
+	s.cancel()
+	s.tr.Disconnect()
+}
+
+// NewClient returns a new (not connected) client using a loopback transport attached
+// to the server broker. If no encoding is set in the options, "mg" is used.
+func (s *Server) NewClient(ctx context.Context, opts *ifx.ClientOptions) *ifx.Client {
+	if opts.Encoding == "" {
+		opts.Encoding = "mg"
+	}
+	if opts.Transport == nil {
+		opts.Transport = s.broker.NewTransport()
+	}
+	return ifx.NewClient(ctx, opts)
+}
+
+// Connect is a shortcut to create a client for the given address and connect it.
+func (s *Server) Connect(ctx context.Context, address, token string) (*ifx.Client, error) {
+	c := s.NewClient(ctx, &ifx.ClientOptions{
+		Address: address,
+		Token:   token,
+	})
+	if err := c.Connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CreateDomain creates a domain if it does not exist yet.
+func (s *Server) CreateDomain(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.createDomain(name)
+}
+
+// CreateAddress registers an address with the given token, optionally assigned to a domain
+// (which is created if needed). If the address already exists, its token and domain are updated.
+func (s *Server) CreateAddress(name, token, domain string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	a, ok := s.addresses[name]
+	if !ok {
+		a = &address{name: name}
+		s.addresses[name] = a
+	}
+	a.token = token
+	if domain != "" {
+		s.createDomain(domain)
+		a.domain = domain
+	}
+}
+
+// AddEvents stores events as if they had been created by their addresses.
+// Events with an empty timestamp get the current time. Events with an already known UID are ignored.
+func (s *Server) AddEvents(events ...*m.Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, e := range events {
+		s.storeEvent(e)
+	}
+}
+
+// Events returns a copy of all the stored events, in creation order.
+func (s *Server) Events() []*m.Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	res := make([]*m.Event, 0, len(s.events))
+	for _, se := range s.events {
+		e := *se.event
+		res = append(res, &e)
+	}
+	return res
+}
+
+func (s *Server) receive(topic string, payload []byte) {
+	chunks := strings.Split(topic, "/")
+	if len(chunks) != 4 {
+		return
+	}
+	sessionID, flags := chunks[1], chunks[3]
+
+	msg, err := ifx.DecodeMessage(flags, payload)
+	if err != nil {
+		return
+	}
+
+	target, cmd, found := strings.Cut(msg.To, ".")
+	if !found {
+		return
+	}
+
+	switch target {
+	case m.IdefixCmdPrefix:
+		s.handleCommand(sessionID, flags, cmd, msg)
+	case replyPrefix:
+		s.forwardReply(cmd, msg)
+	default:
+		s.forward(sessionID, target, cmd, msg)
+	}
+}
+
+// handleCommand runs an idefix command and answers the calling session.
+func (s *Server) handleCommand(sessionID, flags, cmd string, msg *m.Message) {
+	s.mutex.Lock()
+	sess := s.sessions[sessionID]
+	if sess != nil && sess.expired() {
+		delete(s.sessions, sessionID)
+		sess = nil
+	}
+	if sess != nil && sess.encoding == "" {
+		// Sessions created with session.create answer with the encoding of their first request
+		sess.encoding = flags
+	}
+	s.mutex.Unlock()
+
+	if cmd == m.CmdLogin {
+		data, err := s.login(sessionID, msg)
+		s.answer(sessionID, flags, msg.Res, data, err)
+		return
+	}
+
+	if sess == nil {
+		s.answer(sessionID, flags, msg.Res, nil, ie.ErrInvalidSession)
+		return
+	}
+
+	handler, ok := commandHandlers[cmd]
+	if !ok {
+		s.answer(sessionID, flags, msg.Res, nil, ie.ErrInvalidCommand.With(cmd))
+		return
+	}
+
+	run := func() {
+		data, err := handler(s, sess, msg)
+		s.answer(sessionID, flags, msg.Res, data, err)
+	}
+	if cmd == m.CmdEventsGet {
+		// Long polling requests must not block the rest of the messages
+		go run()
+		return
+	}
+	run()
+}
+
+// forward delivers a message to every session logged in as the target address.
+// Messages addressed to offline addresses are dropped, so the caller will time out.
+func (s *Server) forward(sessionID, target, topic string, msg *m.Message) {
+	s.mutex.Lock()
+	if s.sessions[sessionID] == nil {
+		s.mutex.Unlock()
+		return
+	}
+	var targets []*session
+	for _, sess := range s.sessions {
+		if sess.address == target {
+			targets = append(targets, sess)
+		}
+	}
+	s.mutex.Unlock()
+
+	fwd := &m.Message{To: topic, Data: msg.Data, Err: msg.Err}
+	if msg.Res != "" {
+		fwd.Res = fmt.Sprintf("%s.%s.%s", replyPrefix, sessionID, msg.Res)
+	}
+	for _, sess := range targets {
+		s.send(sess.id, sess.encoding, fwd)
+	}
+}
+
+// forwardReply delivers the answer of a forwarded message to the original caller.
+func (s *Server) forwardReply(route string, msg *m.Message) {
+	sessionID, res, found := strings.Cut(route, ".")
+	if !found {
+		return
+	}
+	s.mutex.Lock()
+	sess := s.sessions[sessionID]
+	s.mutex.Unlock()
+	if sess == nil {
+		return
+	}
+	s.send(sess.id, sess.encoding, &m.Message{To: res, Data: msg.Data, Err: msg.Err})
+}
+
+func (s *Server) answer(sessionID, flags, res string, data any, err error) {
+	if res == "" {
+		return
+	}
+	encoding := flags
+	s.mutex.Lock()
+	if sess := s.sessions[sessionID]; sess != nil && sess.encoding != "" {
+		encoding = sess.encoding
+	}
+	s.mutex.Unlock()
+
+	msg := &m.Message{To: res}
+	if err != nil {
+		msg.Err = err.Error()
+	} else {
+		msg.Data = data
+	}
+	s.send(sessionID, encoding, msg)
+}
+
+func (s *Server) send(sessionID, encoding string, msg *m.Message) {
+	flags, payload, err := ifx.EncodeMessage(msg, encoding)
+	if err != nil {
+		return
+	}
+	topic := fmt.Sprintf("%s/%s/r/%s", m.MqttIdefixPrefix, sessionID, flags)
+	s.tr.Publish(s.ctx, topic, 1, payload)
+}
+
+func (s *Server) createDomain(name string) *domain {
+	d, ok := s.domains[name]
+	if !ok {
+		d = &domain{
+			Domain: m.Domain{Domain: name, Env: map[string]string{}},
+			groups: make(map[string]map[string]struct{}),
+		}
+		s.domains[name] = d
+	}
+	return d
+}
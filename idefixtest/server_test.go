@@ -0,0 +1,243 @@
+package idefixtest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	ifx "github.com/nayarsystems/idefix-go"
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{"version":"2.0","encoderPipeline":"t:z","fields":[{"name":"VALUE","type":"uint","size":8}]}`
+
+func newTestServer(t *testing.T) *Server {
+	s, err := NewServer()
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func connect(t *testing.T, s *Server, address, token string) *ifx.Client {
+	c, err := s.Connect(context.Background(), address, token)
+	require.NoError(t, err)
+	t.Cleanup(c.Disconnect)
+	return c
+}
+
+func TestLogin(t *testing.T) {
+	s := newTestServer(t)
+	s.CreateAddress("dev", "devToken", "test")
+
+	connect(t, s, "dev", "devToken")
+
+	c := s.NewClient(context.Background(), &ifx.ClientOptions{Address: "dev", Token: "wrong"})
+	err := c.Connect()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), ie.ErrInvalidToken.Error())
+
+	// Unknown addresses are created on login
+	connect(t, s, "new", "newToken")
+}
+
+func TestUnknownCommand(t *testing.T) {
+	s := newTestServer(t)
+	c := connect(t, s, "dev", "devToken")
+
+	_, err := c.Call("idefix", &m.Message{To: "unknown.cmd"}, time.Second)
+	require.Error(t, err)
+}
+
+func TestDomains(t *testing.T) {
+	s := newTestServer(t)
+	s.CreateAddress("dev1", "t1", "")
+	s.CreateAddress("dev2", "t2", "")
+	c := connect(t, s, "admin", "adminToken")
+
+	_, err := c.DomainCreate(&m.DomainCreateMsg{Domain: "acme", Env: map[string]string{"k": "v"}})
+	require.NoError(t, err)
+	_, err = c.DomainCreate(&m.DomainCreateMsg{Domain: "acme"})
+	require.Error(t, err)
+	_, err = c.DomainCreate(&m.DomainCreateMsg{Domain: "plant.acme"})
+	require.NoError(t, err)
+
+	d, err := c.DomainGet(&m.DomainGetMsg{Domain: "acme"})
+	require.NoError(t, err)
+	require.Equal(t, "acme", d.Domain)
+	require.Equal(t, "v", d.Env["k"])
+
+	_, err = c.DomainGet(&m.DomainGetMsg{Domain: "unknown"})
+	require.Error(t, err)
+
+	for _, a := range []string{"dev1", "dev2"} {
+		_, err = c.DomainAssign(&m.DomainAssignMsg{Domain: "plant.acme", Address: a})
+		require.NoError(t, err)
+	}
+
+	list, err := c.DomainListAddresses(&m.DomainListAddressesMsg{Domain: "plant.acme", Skip: 1, Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"dev2": "plant.acme"}, list.Addresses)
+
+	count, err := c.DomainCountAddresses(&m.DomainCountAddressesMsg{Domain: "plant.acme"})
+	require.NoError(t, err)
+	require.Equal(t, 2, count.Addresses)
+
+	ad, err := c.GetAddressDomain("dev1", time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "plant.acme", ad.Domain)
+
+	ad, err = c.AddressDomainGet(&m.AddressDomainGetMsg{Address: "dev2"})
+	require.NoError(t, err)
+	require.Equal(t, "plant.acme", ad.Domain)
+
+	tree, err := c.DomainGetTree(&m.DomainGetTreeMsg{Domain: "acme"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"acme", "plant.acme"}, tree)
+
+	env, err := c.DomainEnvironmentSet(&m.DomainEnvironmentSetMsg{Domain: "acme", Environment: map[string]string{"a": "b"}})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"k": "v", "a": "b"}, env.Environment)
+	env2, err := c.DomainEnvironmentUnset(&m.DomainEnvironmentUnsetMsg{Domain: "acme", Keys: []string{"k"}})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "b"}, env2.Environment)
+}
+
+func TestGroups(t *testing.T) {
+	s := newTestServer(t)
+	s.CreateAddress("dev1", "t1", "acme")
+	s.CreateAddress("dev2", "t2", "plant.acme")
+	c := connect(t, s, "admin", "adminToken")
+
+	_, err := c.GroupAddAddress(&m.GroupAddAddressMsg{Domain: "acme", Group: "meters", Address: "dev1"})
+	require.NoError(t, err)
+	_, err = c.GroupAddAddress(&m.GroupAddAddressMsg{Domain: "plant.acme", Group: "meters", Address: "dev2"})
+	require.NoError(t, err)
+
+	res, err := c.GroupGetAddresses(&m.GroupGetAddressesMsg{Domain: "acme", Group: "meters"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"acme": {"dev1"}, "plant.acme": {"dev2"}}, res.Addresses)
+
+	res, err = c.GroupGetAddresses(&m.GroupGetAddressesMsg{Domain: "plant.acme", Group: "meters", BubbleUp: true})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"acme": {"dev1"}, "plant.acme": {"dev2"}}, res.Addresses)
+
+	groups, err := c.DomainGetGroups(&m.DomainGetGroupsMsg{Domain: "acme"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"acme#meters"}, groups.Groups)
+
+	ag, err := c.AddressGetGroups(&m.AddressGetGroupsMsg{Address: "dev2"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"plant.acme#meters"}, ag.Groups)
+
+	_, err = c.GroupRemoveAddress(&m.GroupRemoveAddressMsg{Domain: "acme", Group: "meters", Address: "dev1"})
+	require.NoError(t, err)
+	res, err = c.GroupGetAddresses(&m.GroupGetAddressesMsg{Domain: "acme", Group: "meters"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"plant.acme": {"dev2"}}, res.Addresses)
+}
+
+func TestEvents(t *testing.T) {
+	s := newTestServer(t)
+	s.CreateAddress("dev", "devToken", "acme")
+	dev := connect(t, s, "dev", "devToken")
+	c := connect(t, s, "admin", "adminToken")
+
+	for i := range 3 {
+		_, err := dev.EventCreate(&m.EventMsg{UID: fmt.Sprintf("uid%d", i), Type: "test", Payload: i})
+		require.NoError(t, err)
+	}
+	// Duplicated UIDs are ignored
+	_, err := dev.EventCreate(&m.EventMsg{UID: "uid0", Type: "test", Payload: 0})
+	require.NoError(t, err)
+	_, err = dev.EventCreate(&m.EventMsg{Type: "test"})
+	require.Error(t, err)
+	require.Len(t, s.Events(), 3)
+
+	res, err := c.EventsGet(&m.EventsGetMsg{Domain: "acme", Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, res.Events, 2)
+	require.Equal(t, "uid0", res.Events[0].UID)
+	require.Equal(t, "dev", res.Events[0].Address)
+	require.Equal(t, "acme", res.Events[0].Domain)
+
+	res, err = c.EventsGet(&m.EventsGetMsg{Domain: "acme", ContinuationID: res.ContinuationID})
+	require.NoError(t, err)
+	require.Len(t, res.Events, 1)
+	require.Equal(t, "uid2", res.Events[0].UID)
+	cid := res.ContinuationID
+
+	// Without new events, the continuation id is kept
+	res, err = c.EventsGet(&m.EventsGetMsg{Domain: "acme", ContinuationID: cid})
+	require.NoError(t, err)
+	require.Empty(t, res.Events)
+	require.Equal(t, cid, res.ContinuationID)
+
+	// Long polling returns as soon as a new event arrives
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		s.AddEvents(&m.Event{EventMsg: m.EventMsg{UID: "uid3", Type: "test"}, Domain: "acme", Address: "dev"})
+	}()
+	start := time.Now()
+	res, err = c.EventsGet(&m.EventsGetMsg{Domain: "acme", ContinuationID: cid, Timeout: time.Second * 5})
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), time.Second*5)
+	require.Len(t, res.Events, 1)
+	require.Equal(t, "uid3", res.Events[0].UID)
+
+	uid, err := c.GetEventByUID("uid1", time.Second)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, uid.Event.Payload)
+
+	_, err = c.GetEventByUID("unknown", time.Second)
+	require.Error(t, err)
+}
+
+func TestSchemas(t *testing.T) {
+	s := newTestServer(t)
+	c := connect(t, s, "admin", "adminToken")
+
+	res, err := c.SchemaCreate(&m.SchemaMsg{Description: "test", Payload: testSchema})
+	require.NoError(t, err)
+	require.NotEmpty(t, res.Hash)
+
+	got, err := c.SchemaGet(&m.SchemaGetMsg{Hash: res.Hash})
+	require.NoError(t, err)
+	require.Equal(t, testSchema, got.Payload)
+
+	got, err = c.SchemaGet(&m.SchemaGetMsg{Hash: res.Hash, Check: true})
+	require.NoError(t, err)
+	require.Empty(t, got.Payload)
+
+	_, err = c.SchemaGet(&m.SchemaGetMsg{Hash: "unknown"})
+	require.Error(t, err)
+
+	_, err = c.SchemaCreate(&m.SchemaMsg{Payload: "{"})
+	require.Error(t, err)
+}
+
+func TestForward(t *testing.T) {
+	s := newTestServer(t)
+	dev := connect(t, s, "dev", "devToken")
+	c := connect(t, s, "svc", "svcToken")
+
+	sub := dev.NewSubscriber(10, "ping")
+	defer sub.Close()
+	go func() {
+		msg, err := sub.WaitOne(time.Second)
+		if err != nil {
+			return
+		}
+		dev.Answer(msg, &m.Message{Data: map[string]any{"pong": msg.Data}})
+	}()
+
+	res, err := c.Call("dev", &m.Message{To: "ping", Data: "hello"}, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "hello", res.Data.(map[string]any)["pong"])
+
+	// Messages sent to offline addresses are dropped
+	_, err = c.Call("offline", &m.Message{To: "ping"}, time.Millisecond*100)
+	require.Error(t, err)
+}
@@ -0,0 +1,117 @@
+package idefixgo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/nayarsystems/mapstructure"
+)
+
+// defaultSyscallTimeout bounds the cloud commands sent without a context
+const defaultSyscallTimeout = time.Second * 10
+
+// Invoke sends a typed request to the topic of a remote address and returns its typed response.
+//
+// Struct and map requests are converted with [m.ToMsi] (honouring [m.Msiable]), the rest are
+// sent as they are. The response data is parsed into Res with [m.ParseMsi] (honouring
+// [m.MsiParser]) when it is a map, or decoded directly for scalars and slices (e.g. bool or
+// []string). If Res is a pointer type, a new value is allocated. An error answered by the
+// remote side is returned as an [ie.IdefixError].
+//
+// The request goes through the client middlewares and retry policy (see [Client.Use] and
+// [WithRetryPolicy]), like any request sent with [Client.CallWithContext].
+func Invoke[Req, Res any](ctx context.Context, c *Client, address, topic string, req Req) (Res, error) {
+	var res Res
+	data, err := msgData(req)
+	if err != nil {
+		return res, ie.ErrMarshal.WithErr(err)
+	}
+
+	ret, err := c.CallWithContext(ctx, address, &m.Message{To: topic, Data: data})
+	if err != nil {
+		return res, err
+	}
+
+	target := any(&res)
+	if t := reflect.TypeFor[Res](); t.Kind() == reflect.Pointer {
+		res = reflect.New(t.Elem()).Interface().(Res)
+		target = res
+	}
+	if err := parseResponse(ret.Data, target); err != nil {
+		var zero Res
+		return zero, err
+	}
+	return res, nil
+}
+
+// syscall invokes a cloud command, bounded by the given context or by a default timeout
+func syscall[Res, Req any](c *Client, cmd string, req Req, ctx ...context.Context) (Res, error) {
+	if len(ctx) == 0 {
+		callCtx, cancel := context.WithTimeout(c.ctx, defaultSyscallTimeout)
+		defer cancel()
+		return Invoke[Req, Res](callCtx, c, m.IdefixCmdPrefix, cmd, req)
+	}
+	return Invoke[Req, Res](ctx[0], c, m.IdefixCmdPrefix, cmd, req)
+}
+
+// msgData converts structs and maps (or pointers to them) to msi
+func msgData(data any) (any, error) {
+	if m.InterfaceIsNil(data) {
+		return nil, nil
+	}
+	v := reflect.ValueOf(data)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct, reflect.Map:
+		res, err := m.ToMsi(data)
+		if err != nil {
+			return nil, fmt.Errorf("can't convert %T to msi: %w", data, err)
+		}
+		return res, nil
+	}
+	return data, nil
+}
+
+// parseResponse fills out (a pointer) from the data of a response
+func parseResponse(data any, out any) error {
+	if v := reflect.ValueOf(out); v.Kind() != reflect.Pointer || v.IsNil() {
+		return ie.ErrInvalidParams.Withf("response must be a non nil pointer, got %T", out)
+	}
+	if _, ok := out.(m.MsiParser); ok || m.IsMsi(data) {
+		if err := m.ParseMsg(data, out); err != nil {
+			return ie.ErrParse.WithErr(err)
+		}
+		return nil
+	}
+	if data == nil {
+		return nil
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result: out,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			m.DecodeAnyTimeStringToTimeHookFunc(),
+			m.DecodeBase64ToSliceHookFunc()),
+	})
+	if err != nil {
+		return ie.ErrInternal.WithErr(err)
+	}
+	if err := decoder.Decode(data); err != nil {
+		return ie.ErrParse.WithErr(err)
+	}
+	return nil
+}
+
+// responseError converts the 'Err' field of a response to an [ie.IdefixError]
+func responseError(e string) error {
+	if parsed, err := ie.Parse(e); err == nil {
+		return *parsed
+	}
+	return ie.ErrUnknown.With(e)
+}
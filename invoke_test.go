@@ -0,0 +1,71 @@
+package idefixgo_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	ifx "github.com/nayarsystems/idefix-go"
+	ie "github.com/nayarsystems/idefix-go/errors"
+	"github.com/nayarsystems/idefix-go/idefixtest"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+// domainName implements MsiParser, only keeping the domain name
+type domainName string
+
+func (d *domainName) ParseMsi(input map[string]any) error {
+	name, ok := input["domain"].(string)
+	if !ok {
+		return fmt.Errorf("missing domain")
+	}
+	*d = domainName(name)
+	return nil
+}
+
+func TestInvoke(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.CreateAddress("dev1", "t1", "")
+
+	c, err := s.Connect(context.Background(), "admin", "adminToken")
+	require.NoError(t, err)
+	defer c.Disconnect()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	created, err := ifx.Invoke[*m.DomainCreateMsg, *m.DomainCreateResponseMsg](ctx, c, "idefix", m.CmdDomainCreate, &m.DomainCreateMsg{Domain: "acme"})
+	require.NoError(t, err)
+	require.NotNil(t, created)
+
+	// Scalar and slice responses
+	assigned, err := ifx.Invoke[m.DomainAssignMsg, bool](ctx, c, "idefix", m.CmdDomainAssign, m.DomainAssignMsg{Domain: "acme", Address: "dev1"})
+	require.NoError(t, err)
+	require.True(t, assigned)
+	assigned, err = c.DomainAssign(&m.DomainAssignMsg{Domain: "acme", Address: "dev1"})
+	require.NoError(t, err)
+	require.True(t, assigned)
+	tree, err := ifx.Invoke[map[string]any, []string](ctx, c, "idefix", m.CmdDomainTree, map[string]any{"domain": "acme"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"acme"}, tree)
+
+	// MsiParser responses
+	name, err := ifx.Invoke[*m.DomainGetMsg, domainName](ctx, c, "idefix", m.CmdDomainGet, &m.DomainGetMsg{Domain: "acme"})
+	require.NoError(t, err)
+	require.EqualValues(t, "acme", name)
+
+	// Remote errors are IdefixErrors
+	_, err = ifx.Invoke[*m.DomainGetMsg, *m.Domain](ctx, c, "idefix", m.CmdDomainGet, &m.DomainGetMsg{Domain: "unknown"})
+	require.ErrorIs(t, err, ie.ErrDomainNotFound)
+	var ierr ie.IdefixError
+	require.ErrorAs(t, err, &ierr)
+	require.Equal(t, ie.ErrDomainNotFound.Code, ierr.Code)
+
+	var ok bool
+	require.NoError(t, c.Syscall(&m.Message{To: m.CmdDomainAssign, Data: &m.DomainAssignMsg{Domain: "acme", Address: "dev1"}}, &ok))
+	require.True(t, ok)
+	require.ErrorIs(t, c.Syscall(&m.Message{To: m.CmdDomainTree, Data: &m.DomainGetTreeMsg{Domain: "acme"}}, ok), ie.ErrInvalidParams)
+}
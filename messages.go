@@ -1,19 +1,19 @@
 package idefixgo
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
-	"io"
+	"reflect"
 	"strings"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/fxamacker/cbor/v2"
 	e "github.com/nayarsystems/idefix-go/errors"
 	m "github.com/nayarsystems/idefix-go/messages"
 	"github.com/nayarsystems/idefix-go/normalize"
 	"github.com/vmihailenco/msgpack/v5"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func (c *Client) sendMessage(tm *m.Message) (err error) {
@@ -21,12 +21,113 @@ func (c *Client) sendMessage(tm *m.Message) (err error) {
 }
 
 func (c *Client) sendMessageWithContext(ctx context.Context, tm *m.Message) (err error) {
-	var flags string
+	flags, data, size, err := encodeMessage(tm, c.opts.Encoding, compressionThreshold(c.opts.CompressionThreshold))
+	if err != nil {
+		return err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(AttrFlags.String(flags))
+
+	pubCtx, pubCancel := context.WithCancel(ctx)
+	defer pubCancel()
+	stop := context.AfterFunc(c.ctx, pubCancel)
+	defer stop()
+
+	err = c.transport.Publish(pubCtx, c.publishTopic(flags), 1, data)
+	if err != nil {
+		if c.ctx.Err() != nil {
+			// client disconnected
+			return e.ErrContextClosed
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if errors.Is(ctxErr, context.DeadlineExceeded) {
+				return e.ErrTimeout
+			}
+			if errors.Is(ctxErr, context.Canceled) {
+				return e.ErrContextClosed
+			}
+			return e.ErrInternal
+		}
+		if c.opts.Reconnect && c.Status() == Disconnected {
+			// The client is waiting for a reconnection
+			return e.ErrTryAgain.With(err.Error())
+		}
+		return e.ErrInternal.With(err.Error())
+	}
+
+	tel := c.telemetry()
+	tel.publishedBytes.Add(ctx, int64(len(data)))
+	tel.messageSize.Record(ctx, int64(len(data)), directionOut)
+	if len(data) < size {
+		tel.compressionSavedBytes.Add(ctx, int64(size-len(data)), metric.WithAttributes(AttrFlags.String(flags)))
+	}
+	return nil
+}
+
+func (c *Client) receiveMessage(topic string, payload []byte) {
+	if !strings.HasPrefix(topic, c.responseTopic()) {
+		c.dropInbound("topic", topic, nil)
+		return
+	}
+
+	tel := c.telemetry()
+	tel.receivedBytes.Add(c.ctx, int64(len(payload)))
+	tel.messageSize.Record(c.ctx, int64(len(payload)), directionIn)
+
+	topicChuncks := strings.Split(topic, "/")
+	if len(topicChuncks) != 4 {
+		c.dropInbound("topic", topic, nil)
+		return
+	}
+
+	tm, err := DecodeMessage(topicChuncks[3], payload)
+	if err != nil {
+		c.dropInbound("decode", topic, err)
+		return
+	}
+
+	if strings.HasPrefix(tm.To, c.opts.Address+".") {
+		c.dropInbound("self", tm.To, nil)
+		return
+	}
+
+	tm.To = strings.TrimPrefix(tm.To, c.opts.Address+".")
+
+	if tm.To == "" {
+		c.dropInbound("empty_to", topic, nil)
+		return
+	}
+
+	if n := c.ps.Publish(tm.To, tm); n == 0 {
+		tel.unreceived.Add(c.ctx, 1)
+		c.Logger().Debug("inbound message without receivers", "topic", tm.To)
+	}
+}
+
+// cborEncMode and cborDecMode are the CBOR codec settings. Times are encoded as RFC 3339
+// strings (as JSON does), and maps are decoded with string keys, like the JSON and
+// msgpack ones, so that the normalized types can be decoded.
+var (
+	cborEncMode, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	cborDecMode, _ = cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}(nil))}.DecMode()
+)
+
+// EncodeMessage marshals a message using the first codec found in the encoding flags
+// ('j' for JSON, 'm' for msgpack, 'c' for CBOR) and compresses the result if 'z' (zstd) or 'g' (gzip)
+// is present, whatever its size (see [DefaultCompressionThreshold]). It returns the flags
+// describing the resulting payload, which are used as the last level of the topic the
+// message is published to.
+func EncodeMessage(tm *m.Message, encoding string) (flags string, data []byte, err error) {
+	flags, data, _, err = encodeMessage(tm, encoding, DefaultCompressionThreshold)
+	return
+}
+
+// encodeMessage is [EncodeMessage] with a custom compression threshold, also returning
+// the size of the payload before compression
+func encodeMessage(tm *m.Message, encoding string, threshold int) (flags string, data []byte, size int, err error) {
 	var marshaled bool
 	var marshalErr error
-	var data []byte
 
-	if strings.Contains(c.opts.Encoding, "j") && !marshaled {
+	if strings.Contains(encoding, "j") && !marshaled {
 		marshaled = true
 		flags += "j"
 		if v, ok := tm.Data.(map[string]interface{}); ok {
@@ -38,7 +139,7 @@ func (c *Client) sendMessageWithContext(ctx context.Context, tm *m.Message) (err
 		}
 	}
 
-	if strings.Contains(c.opts.Encoding, "m") && !marshaled {
+	if strings.Contains(encoding, "m") && !marshaled {
 		marshaled = true
 		flags += "m"
 		if v, ok := tm.Data.(map[string]interface{}); ok {
@@ -50,82 +151,43 @@ func (c *Client) sendMessageWithContext(ctx context.Context, tm *m.Message) (err
 		}
 	}
 
-	if marshalErr != nil {
-		return e.ErrMarshal
-	}
-
-	if !marshaled {
-		return e.ErrMarshal.With("unsupported encoding")
-	}
-
-	var compressed bool
-
-	if strings.Contains(c.opts.Encoding, "g") && !compressed { // TODO: Compression threshold?
-		buf := new(bytes.Buffer)
-		wr := gzip.NewWriter(buf)
-		n, err := wr.Write(data)
-		wr.Close()
-		if err == nil && n == len(data) {
-			comp, err := io.ReadAll(buf)
-			if err == nil && len(comp) < len(data) {
-				compressed = true
-				flags += "g"
-				data = comp
-			}
+	if strings.Contains(encoding, "c") && !marshaled {
+		marshaled = true
+		flags += "c"
+		if v, ok := tm.Data.(map[string]interface{}); ok {
+			opts := normalize.EncodeTypesOpts{}
+			marshalErr = normalize.EncodeTypes(v, &opts)
 		}
-	}
-
-	msg := c.client.Publish(c.publishTopic(flags), 1, false, data)
-	select {
-	case <-c.ctx.Done():
-		// mqtt client disconnected
-		return e.ErrContextClosed
-	case <-msg.Done():
-	case <-ctx.Done():
-		if ctxErr := ctx.Err(); ctxErr != nil {
-			if errors.Is(ctxErr, context.DeadlineExceeded) {
-				return e.ErrTimeout
-			}
-			if errors.Is(ctxErr, context.Canceled) {
-				return e.ErrContextClosed
-			}
+		if marshalErr == nil {
+			data, marshalErr = cborEncMode.Marshal(tm)
 		}
-		return e.ErrInternal
-	}
-	if msg.Error() != nil {
-		return e.ErrInternal.With(msg.Error().Error())
 	}
-	return nil
-}
 
-func (c *Client) receiveMessage(client mqtt.Client, msg mqtt.Message) {
-	if !strings.HasPrefix(msg.Topic(), c.responseTopic()) {
-		return
+	if marshalErr != nil {
+		return "", nil, 0, e.ErrMarshal
 	}
 
-	topicChuncks := strings.Split(msg.Topic(), "/")
-	if len(topicChuncks) != 4 {
-		return
+	if !marshaled {
+		return "", nil, 0, e.ErrMarshal.With("unsupported encoding")
 	}
+	size = len(data)
+
+	compFlag, data := compress(data, encoding, threshold)
+	flags += compFlag
 
-	flags := topicChuncks[3]
-	payload := msg.Payload()
+	return flags, data, size, nil
+}
 
+// DecodeMessage is the counterpart of [EncodeMessage]: it decompresses and unmarshals
+// a payload according to the given flags, and decodes the normalized types of its data.
+func DecodeMessage(flags string, payload []byte) (*m.Message, error) {
 	var tm m.Message
 	var unmarshalErr error
 	var unmarshaled bool
 
-	if strings.Contains(flags, "g") {
-		r := bytes.NewReader(payload)
-		gzr, err := gzip.NewReader(r)
-		if err == nil {
-			payload, err = io.ReadAll(gzr)
-			gzr.Close()
-		}
-		if err != nil {
-			// fmt.Printf("can't decompress gzip: %v\n", err)
-			return
-		}
+	payload, err := decompress(flags, payload)
+	if err != nil {
+		return nil, err
 	}
 
 	if strings.Contains(flags, "j") && !unmarshaled {
@@ -138,35 +200,24 @@ func (c *Client) receiveMessage(client mqtt.Client, msg mqtt.Message) {
 		unmarshaled = true
 	}
 
-	if unmarshalErr != nil {
-		// fmt.Printf("unmarshal error decoding message: %v\n", unmarshalErr)
-		return
-	}
-
-	if !unmarshaled {
-		// fmt.Println("unmarshal error: codec not found ")
-		return
+	if strings.Contains(flags, "c") && !unmarshaled {
+		unmarshalErr = cborDecMode.Unmarshal(payload, &tm)
+		unmarshaled = true
 	}
 
-	if strings.HasPrefix(tm.To, c.opts.Address+".") {
-		return
+	if unmarshalErr != nil {
+		return nil, e.ErrMarshal.Withf("unmarshal error decoding message: %v", unmarshalErr)
 	}
 
-	tm.To = strings.TrimPrefix(tm.To, c.opts.Address+".")
-
-	if tm.To == "" {
-		return
+	if !unmarshaled {
+		return nil, e.ErrMarshal.With("codec not found")
 	}
 
 	if msiData, ok := tm.Data.(map[string]interface{}); ok {
-		err := normalize.DecodeTypes(msiData)
-		if err != nil {
-			// fmt.Println("error decoding mqtt message", err, msiData)
-			return
+		if err := normalize.DecodeTypes(msiData); err != nil {
+			return nil, e.ErrMarshal.Withf("error decoding message types: %v", err)
 		}
 	}
 
-	if n := c.ps.Publish(tm.To, &tm); n == 0 {
-		// fmt.Printf("mqtt message published but there is no receivers: %#v\n", tm)
-	}
+	return &tm, nil
 }
@@ -547,6 +547,30 @@ type SessionDeleteResponseMsg struct {
 	Sessions int `json:"sessionsDeleted,omitempty" msgpack:"sessionsDeleted,omitempty" mapstructure:"sessionsDeleted"`
 }
 
+type SessionListMsg struct {
+	// Address to list sessions from
+	Address string `json:"address" msgpack:"address" mapstructure:"address"`
+}
+
+type SessionInfo struct {
+	// Session ID
+	Session string `json:"session" msgpack:"session" mapstructure:"session"`
+
+	// Address the session belongs to
+	Address string `json:"address" msgpack:"address" mapstructure:"address"`
+
+	// Groups assigned to the session
+	Groups []string `json:"groups" msgpack:"groups" mapstructure:"groups"`
+
+	// Expiration time of the session (zero if it does not expire)
+	Expires time.Time `json:"expires" msgpack:"expires" mapstructure:"expires"`
+}
+
+type SessionListResponseMsg struct {
+	// Sessions of the address
+	Sessions []SessionInfo `json:"sessions" msgpack:"sessions" mapstructure:"sessions"`
+}
+
 /*****************/
 /*  Environment  */
 /*****************/
@@ -112,6 +112,9 @@ const (
 	// TopicTransportSessionDelete is used to delete a session (logout)
 	TopicTransportSessionDelete = TopicTransportIdefixPrefix + CmdSessionDelete
 
+	// TopicTransportSessionList is used to list the sessions of an address
+	TopicTransportSessionList = TopicTransportIdefixPrefix + CmdSessionList
+
 	// TopicTransportAddressAliasGet is used to get the aliases assigned to an address
 	TopicTransportAddressAliasGet = TopicTransportIdefixPrefix + CmdAddressAliasGet
 
@@ -250,4 +253,7 @@ const (
 
 	// CmdSessionDelete is the command to delete a session
 	CmdSessionDelete = "session.delete"
+
+	// CmdSessionList is the command to list the sessions of an address
+	CmdSessionList = "session.list"
 )
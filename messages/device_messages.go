@@ -99,6 +99,8 @@ type StreamCreateMsg struct {
 	PayloadOnly    bool          `json:"ponly" msgpack:"ponly" mapstructure:"ponly"`
 	Timeout        time.Duration `json:"tout" msgpack:"tout" mapstructure:"tout"`
 	AllowSubtopics bool          `json:"ast,omitempty" msgpack:"ast,omitempty" mapstructure:"ast,omitempty"`
+	Sequenced      bool          `json:"seq,omitempty" msgpack:"seq,omitempty" mapstructure:"seq,omitempty"`
+	QoS            byte          `json:"qos,omitempty" msgpack:"qos,omitempty" mapstructure:"qos,omitempty"`
 }
 
 func (m *StreamCreateMsg) ToMsi() (data msi, err error) {
@@ -138,6 +140,8 @@ type StreamMsg struct {
 	SourceTopic string `json:"s,omitempty" msgpack:"s,omitempty" mapstructure:"s,omitempty"`
 	Sticky      bool   `json:"sticky,omitempty" msgpack:"sticky,omitempty" mapstructure:"sticky,omitempty"`
 	Payload     any    `json:"p" msgpack:"p" mapstructure:"p"`
+	Seq         uint64 `json:"seq,omitempty" msgpack:"seq,omitempty" mapstructure:"seq,omitempty"`
+	Time        int64  `json:"ts,omitempty" msgpack:"ts,omitempty" mapstructure:"ts,omitempty"`
 }
 
 /***************/
@@ -2,8 +2,8 @@ package messages
 
 // Fix: json marshal and msgpack marshal do not share the same field names
 type Message struct {
-	To   string      `json:"t" msgpack:"to"`
-	Data interface{} `json:"d" msgpack:"dt"` // Cannot omitempty because the zero value is a valid value
-	Res  string      `json:"r,omitempty" msgpack:"re,omitempty"`
-	Err  string      `json:"e,omitempty" msgpack:"er,omitempty"`
+	To   string      `json:"t" msgpack:"to" cbor:"to"`
+	Data interface{} `json:"d" msgpack:"dt" cbor:"dt"` // Cannot omitempty because the zero value is a valid value
+	Res  string      `json:"r,omitempty" msgpack:"re,omitempty" cbor:"re,omitempty"`
+	Err  string      `json:"e,omitempty" msgpack:"er,omitempty" cbor:"er,omitempty"`
 }
@@ -0,0 +1,90 @@
+package idefixgo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestReceiveMessageLogging(t *testing.T) {
+	b := NewLoopbackBroker()
+	r := newLoopbackResponder(t, b)
+
+	var out syncBuffer
+	c := newLoopbackClient(b, false)
+	c.opts.Logger = slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	responseTopic := fmt.Sprintf("%s/%s/r/m", m.MqttIdefixPrefix, c.sessionID)
+	r.tr.Publish(context.Background(), responseTopic, 1, []byte("invalid"))
+	require.Eventually(t, func() bool {
+		return strings.Contains(out.String(), "inbound message dropped")
+	}, time.Second, time.Millisecond*10)
+
+	line := out.String()
+	require.Contains(t, line, "level=WARN")
+	require.Contains(t, line, "client=test")
+	require.Contains(t, line, "reason=decode")
+	require.Contains(t, line, "topic="+responseTopic)
+	require.Contains(t, line, "error=")
+}
+
+func TestEncodeMessage(t *testing.T) {
+	now := time.UnixMilli(time.Now().UnixMilli())
+	for _, encoding := range []string{"j", "m", "c"} {
+		msg := &m.Message{To: "dev.data", Res: "res", Err: "err", Data: map[string]any{
+			"bytes":  []byte{0, 1, 2},
+			"time":   now,
+			"dur":    time.Second,
+			"nested": map[string]any{"s": "value", "n": 1},
+		}}
+		flags, data, err := EncodeMessage(msg, encoding)
+		require.NoError(t, err)
+		require.Equal(t, encoding, flags)
+
+		res, err := DecodeMessage(flags, data)
+		require.NoError(t, err, encoding)
+		require.Equal(t, "dev.data", res.To)
+		require.Equal(t, "res", res.Res)
+		require.Equal(t, "err", res.Err)
+		resData := res.Data.(map[string]any)
+		require.Equal(t, []byte{0, 1, 2}, resData["bytes"], encoding)
+		require.True(t, now.Equal(resData["time"].(time.Time)), encoding)
+		require.Equal(t, time.Second, resData["dur"], encoding)
+		nested := resData["nested"].(map[string]any)
+		require.Equal(t, "value", nested["s"], encoding)
+		require.EqualValues(t, 1, nested["n"], encoding)
+	}
+
+	_, _, err := EncodeMessage(&m.Message{}, "x")
+	require.Error(t, err)
+	_, err = DecodeMessage("x", []byte{})
+	require.Error(t, err)
+}
@@ -5,15 +5,20 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/jaracil/ei"
 	ie "github.com/nayarsystems/idefix-go/errors"
 	m "github.com/nayarsystems/idefix-go/messages"
 	"github.com/nayarsystems/idefix-go/minips"
 )
 
 // Publish sends a message to a specified remote address.
+//
+// If [ClientOptions.Outbox] is set, the messages published while the client is disconnected
+// (or while the outbox is being replayed) are queued instead, and sent in order once connected.
 func (c *Client) Publish(remoteAddress string, msg *m.Message) error {
 	msg.To = fmt.Sprintf("%s.%s", remoteAddress, msg.To)
+	if c.opts.Outbox != nil {
+		return c.publishOrQueue(msg)
+	}
 	return c.sendMessage(msg)
 }
 
@@ -34,61 +39,17 @@ func (c *Client) Answer(origmsg *m.Message, msg *m.Message) error {
 }
 
 // Call sends a message to a specified remote address and expects a response. If timeout given is exceed it returns an error.
+// The request goes through the middlewares registered with [Client.Use].
 func (c *Client) Call(remoteAddress string, msg *m.Message, timeout time.Duration) (*m.Message, error) {
-	var err error
-	msg.To = fmt.Sprintf("%s.%s", remoteAddress, msg.To)
-	msg.Res, err = randSessionID()
-	if err != nil {
-		return nil, err
-	}
-
-	sub := c.ps.NewSubscriber(1, msg.Res)
-	defer sub.Close()
-
-	if err := c.sendMessage(msg); err != nil {
-		return nil, err
-	}
-
-	waitCtx, waitCancel := c.contextWithTimeout(timeout)
-	defer waitCancel()
-
-	msg, err = sub.WaitOneWithContext(waitCtx)
-	if err != nil {
-		return nil, ie.ErrTimeout
-	}
-	if msg.Err != "" {
-		return msg, fmt.Errorf("%s", msg.Err)
-	}
-	return msg, nil
+	ctx, cancel := c.contextWithTimeout(timeout)
+	defer cancel()
+	return c.call(ctx, remoteAddress, msg)
 }
 
-// CallWithContext sends a message to a specified remote address and expects a response until the context gets cancelled
+// CallWithContext sends a message to a specified remote address and expects a response until the context gets cancelled.
+// The request goes through the middlewares registered with [Client.Use].
 func (c *Client) CallWithContext(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
-	var err error
-	msg.To = fmt.Sprintf("%s.%s", remoteAddress, msg.To)
-	msg.Res, err = randSessionID()
-	if err != nil {
-		return nil, err
-	}
-
-	sub := c.ps.NewSubscriber(1, msg.Res)
-	defer sub.Close()
-
-	if err := c.sendMessageWithContext(ctx, msg); err != nil {
-		return nil, err
-	}
-
-	waitCtx, waitCancel := c.contextWithCancel(ctx)
-	defer waitCancel()
-
-	msg, err = sub.WaitOneWithContext(waitCtx)
-	if err != nil {
-		return nil, ie.ErrTimeout
-	}
-	if msg.Err != "" {
-		return msg, fmt.Errorf("%s", msg.Err)
-	}
-	return msg, nil
+	return c.call(ctx, remoteAddress, msg)
 }
 
 // Call2 uses [Client.Call] to send a message to a specified remote address and expects a response.
@@ -106,14 +67,10 @@ func (c *Client) Call2(remoteAddress string, msg *m.Message, resp any, timeout t
 		return err
 	}
 	if ret.Err != "" {
-		return fmt.Errorf("%s", msg.Err)
+		return responseError(ret.Err)
 	}
 	if resp != nil {
-		respMsi, err := m.GetMsi(ret.Data)
-		if err != nil {
-			return err
-		}
-		return m.ParseMsi(respMsi, resp)
+		return parseResponse(ret.Data, resp)
 	}
 	return nil
 }
@@ -153,242 +110,149 @@ func (c *Client) WaitOneWithContext(ctx context.Context, topic string) (*m.Messa
 	return sub.WaitOneWithContext(ctx)
 }
 
+// Syscall sends a command to the Idefix cloud and parses its response into response,
+// which must be a pointer (see [Invoke] for a typed alternative). An optional context bounds
+// the request (10 seconds by default). Read-only commands are retried according to
+// [ClientOptions.RetryPolicy]; use [WithRetryPolicy] to override it.
 func (c *Client) Syscall(message *m.Message, response any, ctx ...context.Context) (err error) {
 	var callCtx context.Context
 	if len(ctx) == 0 {
 		var cancel context.CancelFunc
-		callCtx, cancel = context.WithDeadline(c.ctx, time.Now().Add(time.Second*10))
+		callCtx, cancel = context.WithTimeout(c.ctx, defaultSyscallTimeout)
 		defer cancel()
 	} else {
 		callCtx = ctx[0]
 	}
 
-	message.Data, err = m.ToMsi(message.Data)
+	message.Data, err = msgData(message.Data)
 	if err != nil {
-		return err
+		return ie.ErrMarshal.WithErr(err)
 	}
 
-	ret, err := c.CallWithContext(callCtx, "idefix", message)
+	ret, err := c.CallWithContext(callCtx, m.IdefixCmdPrefix, message)
 	if err != nil {
 		return err
 	}
 
-	if ret.Err != "" {
-		return fmt.Errorf("%s", ret.Err)
-	}
-
 	if response != nil {
-		if rrr, err := ei.N(ret.Data).Bool(); err == nil {
-			response = rrr
-			return nil
-		}
-
-		respMsi, err := m.GetMsi(ret.Data)
-		if err != nil {
-			return err
-		}
-		return m.ParseMsi(respMsi, response)
+		return parseResponse(ret.Data, response)
 	}
-
 	return nil
 }
 
 func (c *Client) EventCreate(query *m.EventMsg, ctx ...context.Context) (response *m.EventResponseMsg, err error) {
-	message := &m.Message{To: m.CmdEventsCreate, Data: query}
-	response = &m.EventResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.EventResponseMsg](c, m.CmdEventsCreate, query, ctx...)
 }
 
 func (c *Client) EventsGet(query *m.EventsGetMsg, ctx ...context.Context) (response *m.EventsGetResponseMsg, err error) {
-	message := &m.Message{To: m.CmdEventsGet, Data: query}
-	response = &m.EventsGetResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.EventsGetResponseMsg](c, m.CmdEventsGet, query, ctx...)
 }
 
 func (c *Client) AddressTokenReset(query *m.AddressTokenResetMsg, ctx ...context.Context) (response bool, err error) {
-	message := &m.Message{To: m.CmdAddressTokenReset, Data: query}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[bool](c, m.CmdAddressTokenReset, query, ctx...)
 }
 
 func (c *Client) AddressDisable(query *m.AddressDisableMsg, ctx ...context.Context) (response bool, err error) {
-	message := &m.Message{To: m.CmdAddressDisable, Data: query}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[bool](c, m.CmdAddressDisable, query, ctx...)
 }
 
 func (c *Client) AddressAccessRulesGet(query *m.AddressAccessRulesGetMsg, ctx ...context.Context) (response *m.AddressAccessRulesGetResponseMsg, err error) {
-	message := &m.Message{To: m.CmdAddressRulesGet, Data: query}
-	response = &m.AddressAccessRulesGetResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.AddressAccessRulesGetResponseMsg](c, m.CmdAddressRulesGet, query, ctx...)
 }
 
 func (c *Client) AddressAccessRulesUpdate(query *m.AddressAccessRulesUpdateMsg, ctx ...context.Context) (response *m.AddressAccessRulesUpdateResponseMsg, err error) {
-	message := &m.Message{To: m.CmdDomainUpdateAccessRules, Data: query}
-	response = &m.AddressAccessRulesUpdateResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.AddressAccessRulesUpdateResponseMsg](c, m.CmdDomainUpdateAccessRules, query, ctx...)
 }
 
 func (c *Client) AddressDomainGet(query *m.AddressDomainGetMsg, ctx ...context.Context) (response *m.Domain, err error) {
-	message := &m.Message{To: m.CmdDomainGet, Data: query}
-	response = &m.Domain{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.Domain](c, m.CmdDomainGet, query, ctx...)
 }
 
 func (c *Client) AddressConfigGet(query *m.AddressConfigGetMsg, ctx ...context.Context) (response *m.AddressConfigGetResponseMsg, err error) {
-	message := &m.Message{To: m.CmdAddressConfigGet, Data: query}
-	response = &m.AddressConfigGetResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.AddressConfigGetResponseMsg](c, m.CmdAddressConfigGet, query, ctx...)
 }
 func (c *Client) AddressStatesGet(query *m.AddressStatesGetMsg, ctx ...context.Context) (response *m.AddressStatesGetResMsg, err error) {
-	message := &m.Message{To: m.CmdAddressStatesGet, Data: query}
-	response = &m.AddressStatesGetResMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.AddressStatesGetResMsg](c, m.CmdAddressStatesGet, query, ctx...)
 }
 
 func (c *Client) AddressConfigUpdate(query *m.AddressConfigUpdateMsg, ctx ...context.Context) (response *m.AddressConfigUpdateResponseMsg, err error) {
-	message := &m.Message{To: m.CmdAddressConfigUpdate, Data: query}
-	response = &m.AddressConfigUpdateResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.AddressConfigUpdateResponseMsg](c, m.CmdAddressConfigUpdate, query, ctx...)
 }
 
 func (c *Client) AddressAliasGet(query *m.AddressAliasGetMsg, ctx ...context.Context) (response *m.AddressAliasGetResponseMsg, err error) {
-	message := &m.Message{To: m.CmdAddressAliasGet, Data: query}
-	response = &m.AddressAliasGetResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.AddressAliasGetResponseMsg](c, m.CmdAddressAliasGet, query, ctx...)
 }
 
 func (c *Client) AddressAliasAdd(query *m.AddressAliasAddMsg, ctx ...context.Context) (response *m.AddressAliasAddResponseMsg, err error) {
-	message := &m.Message{To: m.CmdAddressAliasAdd, Data: query}
-	response = &m.AddressAliasAddResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.AddressAliasAddResponseMsg](c, m.CmdAddressAliasAdd, query, ctx...)
 }
 
 func (c *Client) AddressAliasRemove(query *m.AddressAliasRemoveMsg, ctx ...context.Context) (response *m.AddressAliasRemoveResponseMsg, err error) {
-	message := &m.Message{To: m.CmdAddressAliasRemove, Data: query}
-	response = &m.AddressAliasRemoveResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.AddressAliasRemoveResponseMsg](c, m.CmdAddressAliasRemove, query, ctx...)
 }
 
 func (c *Client) SchemaCreate(query *m.SchemaMsg, ctx ...context.Context) (response *m.SchemaResponseMsg, err error) {
-	message := &m.Message{To: m.CmdSchemasCreate, Data: query}
-	response = &m.SchemaResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.SchemaResponseMsg](c, m.CmdSchemasCreate, query, ctx...)
 }
 
 func (c *Client) SchemaGet(query *m.SchemaGetMsg, ctx ...context.Context) (response *m.SchemaGetResponseMsg, err error) {
-	message := &m.Message{To: m.CmdSchemasGet, Data: query}
-	response = &m.SchemaGetResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.SchemaGetResponseMsg](c, m.CmdSchemasGet, query, ctx...)
 }
 
 func (c *Client) DomainGet(query *m.DomainGetMsg, ctx ...context.Context) (response *m.Domain, err error) {
-	message := &m.Message{To: m.CmdDomainGet, Data: query}
-	response = &m.Domain{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.Domain](c, m.CmdDomainGet, query, ctx...)
 }
 
 func (c *Client) DomainDelete(query *m.DomainDeleteMsg, ctx ...context.Context) (response bool, err error) {
-	message := &m.Message{To: m.CmdDomainDelete, Data: query}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[bool](c, m.CmdDomainDelete, query, ctx...)
 }
 
 func (c *Client) DomainCreate(query *m.DomainCreateMsg, ctx ...context.Context) (response *m.DomainCreateResponseMsg, err error) {
-	message := &m.Message{To: m.CmdDomainCreate, Data: query}
-	response = &m.DomainCreateResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.DomainCreateResponseMsg](c, m.CmdDomainCreate, query, ctx...)
 }
 
 func (c *Client) DomainUpdate(query *m.DomainUpdateMsg, ctx ...context.Context) (response *m.DomainUpdateResponseMsg, err error) {
-	message := &m.Message{To: m.CmdDomainUpdate, Data: query}
-	response = &m.DomainUpdateResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.DomainUpdateResponseMsg](c, m.CmdDomainUpdate, query, ctx...)
 }
 
 func (c *Client) DomainUpdateAccessRules(query *m.DomainUpdateAccessRulesMsg, ctx ...context.Context) (response *m.DomainUpdateAccessRulesResponseMsg, err error) {
-	message := &m.Message{To: m.CmdDomainUpdateAccessRules, Data: query}
-	response = &m.DomainUpdateAccessRulesResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.DomainUpdateAccessRulesResponseMsg](c, m.CmdDomainUpdateAccessRules, query, ctx...)
 }
 
 func (c *Client) DomainAssign(query *m.DomainAssignMsg, ctx ...context.Context) (response bool, err error) {
-	message := &m.Message{To: m.CmdDomainAssign, Data: query}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[bool](c, m.CmdDomainAssign, query, ctx...)
 }
 
 func (c *Client) DomainGetTree(query *m.DomainGetTreeMsg, ctx ...context.Context) (response []string, err error) {
-	message := &m.Message{To: m.CmdDomainTree, Data: query}
-	response = make([]string, 0)
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[[]string](c, m.CmdDomainTree, query, ctx...)
 }
 
 func (c *Client) DomainCountAddresses(query *m.DomainCountAddressesMsg, ctx ...context.Context) (response *m.DomainCountAddressesResponseMsg, err error) {
-	message := &m.Message{To: m.CmdDomainCountAddresses, Data: query}
-	response = &m.DomainCountAddressesResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.DomainCountAddressesResponseMsg](c, m.CmdDomainCountAddresses, query, ctx...)
 }
 
 func (c *Client) DomainListAddresses(query *m.DomainListAddressesMsg, ctx ...context.Context) (response *m.DomainListAddressesResponseMsg, err error) {
-	message := &m.Message{To: m.CmdDomainListAddresses, Data: query}
-	response = &m.DomainListAddressesResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.DomainListAddressesResponseMsg](c, m.CmdDomainListAddresses, query, ctx...)
 }
 
 func (c *Client) GroupAddAddress(query *m.GroupAddAddressMsg, ctx ...context.Context) (response *m.GroupAddAddressResponseMsg, err error) {
-	message := &m.Message{To: m.CmdGroupAddAddress, Data: query}
-	response = &m.GroupAddAddressResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.GroupAddAddressResponseMsg](c, m.CmdGroupAddAddress, query, ctx...)
 }
 
 func (c *Client) GroupRemoveAddress(query *m.GroupRemoveAddressMsg, ctx ...context.Context) (response *m.GroupRemoveAddressResponseMsg, err error) {
-	message := &m.Message{To: m.CmdGroupRemoveAddress, Data: query}
-	response = &m.GroupRemoveAddressResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.GroupRemoveAddressResponseMsg](c, m.CmdGroupRemoveAddress, query, ctx...)
 }
 
 func (c *Client) GroupGetAddresses(query *m.GroupGetAddressesMsg, ctx ...context.Context) (response *m.GroupGetAddressesResponseMsg, err error) {
-	message := &m.Message{To: m.CmdGroupGetAddresses, Data: query}
-	response = &m.GroupGetAddressesResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.GroupGetAddressesResponseMsg](c, m.CmdGroupGetAddresses, query, ctx...)
 }
 
 func (c *Client) DomainGetGroups(query *m.DomainGetGroupsMsg, ctx ...context.Context) (response *m.DomainGetGroupsResponseMsg, err error) {
-	message := &m.Message{To: m.CmdDomainListGroups, Data: query}
-	response = &m.DomainGetGroupsResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.DomainGetGroupsResponseMsg](c, m.CmdDomainListGroups, query, ctx...)
 }
 
 func (c *Client) AddressGetGroups(query *m.AddressGetGroupsMsg, ctx ...context.Context) (response *m.AddressGetGroupsResponseMsg, err error) {
-	message := &m.Message{To: m.CmdAddressGetGroups, Data: query}
-	response = &m.AddressGetGroupsResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.AddressGetGroupsResponseMsg](c, m.CmdAddressGetGroups, query, ctx...)
 }
 
 // func (c *Client) GroupRemove(query *m.GroupRemoveMsg, ctx ...context.Context) (response *m.GroupRemoveResponseMsg, err error) {
@@ -398,74 +262,60 @@ func (c *Client) AddressGetGroups(query *m.AddressGetGroupsMsg, ctx ...context.C
 // 	return
 // }
 
+func (c *Client) SessionCreate(query *m.SessionCreateMsg, ctx ...context.Context) (response *m.SessionCreateResponseMsg, err error) {
+	return syscall[*m.SessionCreateResponseMsg](c, m.CmdSessionCreate, query, ctx...)
+}
+
+func (c *Client) SessionList(query *m.SessionListMsg, ctx ...context.Context) (response *m.SessionListResponseMsg, err error) {
+	return syscall[*m.SessionListResponseMsg](c, m.CmdSessionList, query, ctx...)
+}
+
 func (c *Client) SessionDelete(query *m.SessionDeleteMsg, ctx ...context.Context) (response *m.SessionDeleteResponseMsg, err error) {
-	message := &m.Message{To: m.CmdSessionDelete, Data: query}
-	response = &m.SessionDeleteResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.SessionDeleteResponseMsg](c, m.CmdSessionDelete, query, ctx...)
 }
 
 func (c *Client) AddressEnvironmentGet(query *m.AddressEnvironmentGetMsg, ctx ...context.Context) (response *m.AddressEnvironmentGetResponseMsg, err error) {
-	message := &m.Message{To: m.CmdAddressEnvironmentGet, Data: query}
-	response = &m.AddressEnvironmentGetResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.AddressEnvironmentGetResponseMsg](c, m.CmdAddressEnvironmentGet, query, ctx...)
 }
 
 func (c *Client) AddressEnvironmentSet(query *m.AddressEnvironmentSetMsg, ctx ...context.Context) (response *m.AddressEnvironmentSetResponseMsg, err error) {
-	message := &m.Message{To: m.CmdAddressEnvironmentSet, Data: query}
-	response = &m.AddressEnvironmentSetResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.AddressEnvironmentSetResponseMsg](c, m.CmdAddressEnvironmentSet, query, ctx...)
 }
 
 func (c *Client) AddressEnvironmentUnset(query *m.AddressEnvironmentUnsetMsg, ctx ...context.Context) (response *m.AddressEnvironmentUnsetResponseMsg, err error) {
-	message := &m.Message{To: m.CmdAddressEnvironmentUnset, Data: query}
-	response = &m.AddressEnvironmentUnsetResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.AddressEnvironmentUnsetResponseMsg](c, m.CmdAddressEnvironmentUnset, query, ctx...)
 }
 
 func (c *Client) DomainEnvironmentGet(query *m.DomainEnvironmentGetMsg, ctx ...context.Context) (response *m.DomainEnvironmentGetResponseMsg, err error) {
-	message := &m.Message{To: m.CmdDomainEnvironmentGet, Data: query}
-	response = &m.DomainEnvironmentGetResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.DomainEnvironmentGetResponseMsg](c, m.CmdDomainEnvironmentGet, query, ctx...)
 }
 
 func (c *Client) DomainEnvironmentSet(query *m.DomainEnvironmentSetMsg, ctx ...context.Context) (response *m.DomainEnvironmentSetResponseMsg, err error) {
-	message := &m.Message{To: m.CmdDomainEnvironmentSet, Data: query}
-	response = &m.DomainEnvironmentSetResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.DomainEnvironmentSetResponseMsg](c, m.CmdDomainEnvironmentSet, query, ctx...)
 }
 
 func (c *Client) DomainEnvironmentUnset(query *m.DomainEnvironmentUnsetMsg, ctx ...context.Context) (response *m.DomainEnvironmentUnsetResponseMsg, err error) {
-	message := &m.Message{To: m.CmdDomainEnvironmentUnset, Data: query}
-	response = &m.DomainEnvironmentUnsetResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.DomainEnvironmentUnsetResponseMsg](c, m.CmdDomainEnvironmentUnset, query, ctx...)
 }
 
 func (c *Client) Environment(query *m.EnvironmentGetMsg, ctx ...context.Context) (response *m.EnvironmentGetResponseMsg, err error) {
-	message := &m.Message{To: m.CmdEnvironmentGet, Data: query}
-	response = &m.EnvironmentGetResponseMsg{}
-	err = c.Syscall(message, response, ctx...)
-	return
+	return syscall[*m.EnvironmentGetResponseMsg](c, m.CmdEnvironmentGet, query, ctx...)
 }
 
 // This method combines the client's main context with the provided context.
 // If either context is cancelled, the combined context will be cancelled as well.
 // This allows for more flexible cancellation handling in operations that depend on both contexts.
 func (c *Client) contextWithCancel(ctx context.Context) (context.Context, context.CancelFunc) {
-	combined, cancel := context.WithCancel(c.ctx)
+	// The goroutine must not read c.ctx, as Connect replaces it when the client is reused
+	clientCtx := c.ctx
+	combined, cancel := context.WithCancel(clientCtx)
 	go func() {
 		select {
 		case <-ctx.Done():
 			// If the provided context is cancelled, we also want to cancel the
 			// combined context
 			cancel()
-		case <-c.ctx.Done():
+		case <-clientCtx.Done():
 			// If the client's main context is cancelled, combined context will
 			// be cancelled automatically, so we just return
 		}
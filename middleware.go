@@ -0,0 +1,82 @@
+package idefixgo
+
+import (
+	"context"
+	"fmt"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+)
+
+// CallFunc sends a request to a remote address and waits for its response.
+//
+// The 'To' field of msg holds the topic relative to remoteAddress (e.g. "login" for
+// remoteAddress "idefix"). When the remote side answers with an error, both the
+// response message and the error (as an [ie.IdefixError]) are returned.
+type CallFunc func(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error)
+
+// Middleware wraps a [CallFunc], allowing to inspect or modify every outgoing
+// request and its response (logging, metrics, retries, request tagging...).
+type Middleware func(next CallFunc) CallFunc
+
+// Use appends middlewares to the client request chain. Every request issued with
+// [Client.Call], [Client.CallWithContext], [Client.Call2] or [Client.Syscall]
+// (including the login performed on connection) goes through them.
+//
+// Middlewares are applied in the order given: the first one registered is the
+// outermost, so it sees the request first and the response last.
+func (c *Client) Use(mw ...Middleware) {
+	c.middlewareMutex.Lock()
+	defer c.middlewareMutex.Unlock()
+	c.middlewares = append(c.middlewares, mw...)
+}
+
+// call runs a request through the middleware chain, retrying it according
+// to its [RetryPolicy]. Every attempt goes through the whole chain and the
+// rate limits, and the whole request is traced in a single span.
+func (c *Client) call(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+	c.middlewareMutex.RLock()
+	next := c.rateLimited(c.roundTrip)
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		next = c.middlewares[i](next)
+	}
+	c.middlewareMutex.RUnlock()
+
+	return c.traceCall(ctx, remoteAddress, msg, func(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+		if policy := c.retryPolicy(ctx, remoteAddress, msg.To); policy != nil && policy.MaxAttempts > 1 {
+			return c.callWithRetries(ctx, policy, next, remoteAddress, msg)
+		}
+		return next(ctx, remoteAddress, msg)
+	})
+}
+
+// roundTrip is the innermost [CallFunc]. It sends a copy of msg, so the same
+// message can be sent again (e.g. by a retry middleware).
+func (c *Client) roundTrip(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+	var err error
+	req := *msg
+	req.To = fmt.Sprintf("%s.%s", remoteAddress, msg.To)
+	req.Res, err = randSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	sub := c.ps.NewSubscriber(1, req.Res)
+	defer sub.Close()
+
+	if err := c.sendMessageWithContext(ctx, &req); err != nil {
+		return nil, err
+	}
+
+	waitCtx, waitCancel := c.contextWithCancel(ctx)
+	defer waitCancel()
+
+	res, err := sub.WaitOneWithContext(waitCtx)
+	if err != nil {
+		return nil, ie.ErrTimeout
+	}
+	if res.Err != "" {
+		return res, responseError(res.Err)
+	}
+	return res, nil
+}
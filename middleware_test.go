@@ -0,0 +1,58 @@
+package idefixgo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	b := NewLoopbackBroker()
+	newLoopbackResponder(t, b)
+
+	c := newLoopbackClient(b, false)
+	var trace []string
+	tracer := func(name string) Middleware {
+		return func(next CallFunc) CallFunc {
+			return func(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+				trace = append(trace, name+">"+remoteAddress+"."+msg.To)
+				res, err := next(ctx, remoteAddress, msg)
+				trace = append(trace, name+"<")
+				return res, err
+			}
+		}
+	}
+	c.Use(tracer("a"), tracer("b"))
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+	require.Equal(t, []string{"a>idefix.login", "b>idefix.login", "b<", "a<"}, trace)
+
+	// Request tagging and retries over the same message
+	c.Use(func(next CallFunc) CallFunc {
+		return func(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+			if data, ok := msg.Data.(map[string]any); ok {
+				data["tag"] = "test"
+			}
+			res, err := next(ctx, remoteAddress, msg)
+			if err != nil {
+				return res, err
+			}
+			return next(ctx, remoteAddress, msg)
+		}
+	})
+
+	trace = nil
+	res := map[string]any{}
+	require.NoError(t, c.Call2("idefix", &m.Message{To: "echo", Data: map[string]any{}}, &res, time.Second))
+	require.Equal(t, "test", res["tag"])
+	require.Equal(t, []string{"a>idefix.echo", "b>idefix.echo", "b<", "a<"}, trace)
+
+	trace = nil
+	msg, err := c.CallWithContext(context.Background(), "idefix", &m.Message{To: "unknown"})
+	require.Error(t, err)
+	require.NotNil(t, msg)
+	require.Equal(t, []string{"a>idefix.unknown", "b>idefix.unknown", "b<", "a<"}, trace)
+}
@@ -222,7 +222,7 @@ func fileWriteInChunks(ic *Client, address, path string, data []byte, chunkSize
 		hash, err := FileSHA256Hex(ic, address, chunkPath, time.Second*30)
 		if err == nil {
 			if hash == chunkHash {
-				fmt.Println("chunk already exists, skipping")
+				ic.Logger().Debug("chunk already exists, skipping", "address", address, "path", chunkPath)
 				continue
 			}
 		}
@@ -0,0 +1,125 @@
+package idefixgo
+
+import (
+	"log/slog"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+)
+
+// outboxReplayBatch is the number of queued messages read at once while replaying the outbox
+const outboxReplayBatch = 100
+
+// OutboxMessage is an encoded message waiting in an [Outbox] to be published
+type OutboxMessage struct {
+	ID    uint64 // Queue position, increasing with each pushed message
+	Flags string // Encoding flags of the message (see [ClientOptions.Encoding])
+	Data  []byte // Encoded message
+}
+
+// Outbox is a persistent FIFO queue holding the messages published while the client is
+// disconnected (see [ClientOptions.Outbox]). Implementations apply their own retention
+// policy (e.g. a TTL or a maximum size), and must be safe for concurrent use.
+type Outbox interface {
+	// Push appends a message to the queue
+	Push(flags string, data []byte) error
+	// Peek returns up to limit of the oldest queued messages, without removing them
+	Peek(limit int) ([]OutboxMessage, error)
+	// Remove deletes a message from the queue
+	Remove(id uint64) error
+	// Len returns the number of queued messages
+	Len() (int, error)
+}
+
+// outboxLogger is implemented by the outboxes logging their diagnostics, so that the client
+// hands them its logger (see [ClientOptions.Logger])
+type outboxLogger interface {
+	SetLogger(l *slog.Logger)
+}
+
+// OutboxDepth returns the number of messages waiting in the outbox to be published.
+// It is always zero if [ClientOptions.Outbox] is not set.
+func (c *Client) OutboxDepth() (int, error) {
+	if c.opts.Outbox == nil {
+		return 0, nil
+	}
+	return c.opts.Outbox.Len()
+}
+
+// publishOrQueue sends a message straight away if the client is connected and the outbox is
+// empty, queueing it otherwise (also when the connection is lost while sending it)
+func (c *Client) publishOrQueue(msg *m.Message) error {
+	// The lock is not held while sending, so that a slow broker does not serialize the publications
+	c.outboxMutex.Lock()
+	direct := !c.outboxQueued && c.Status() == Connected
+	c.outboxMutex.Unlock()
+	if direct {
+		err := c.sendMessage(msg)
+		if err == nil || (!ie.ErrTryAgain.Is(err) && !ie.ErrContextClosed.Is(err)) {
+			return err
+		}
+	}
+
+	flags, data, _, err := encodeMessage(msg, c.opts.Encoding, compressionThreshold(c.opts.CompressionThreshold))
+	if err != nil {
+		return err
+	}
+	c.outboxMutex.Lock()
+	defer c.outboxMutex.Unlock()
+	if err := c.opts.Outbox.Push(flags, data); err != nil {
+		return err
+	}
+	c.outboxQueued = true
+	return nil
+}
+
+// replayOutbox publishes the queued messages in order, until the outbox is empty or the
+// connection is lost again (the replay then resumes on the next reconnection)
+func (c *Client) replayOutbox() {
+	c.outboxReplay.Lock()
+	defer c.outboxReplay.Unlock()
+	ctx := c.ctx
+
+	sent := 0
+	for ctx.Err() == nil {
+		msgs, err := c.opts.Outbox.Peek(outboxReplayBatch)
+		if err != nil {
+			c.Logger().Warn("can't read outbox", "error", err)
+			return
+		}
+		if len(msgs) == 0 {
+			// New messages are queued while the replay is in progress,
+			// so they have to be checked for before publishing directly again
+			c.outboxMutex.Lock()
+			n, err := c.opts.Outbox.Len()
+			if err == nil && n == 0 {
+				c.outboxQueued = false
+			}
+			c.outboxMutex.Unlock()
+			if err != nil {
+				c.Logger().Warn("can't read outbox", "error", err)
+				return
+			}
+			if n == 0 {
+				if sent > 0 {
+					c.Logger().Info("outbox replayed", "count", sent)
+				}
+				return
+			}
+			continue
+		}
+
+		for _, msg := range msgs {
+			if err := c.transport.Publish(ctx, c.publishTopic(msg.Flags), 1, msg.Data); err != nil {
+				c.Logger().Debug("outbox replay interrupted", "error", err)
+				return
+			}
+			c.telemetry().publishedBytes.Add(ctx, int64(len(msg.Data)))
+			if err := c.opts.Outbox.Remove(msg.ID); err != nil {
+				c.Logger().Warn("can't remove message from outbox", "error", err)
+				return
+			}
+			sent++
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Package outbox provides persistent implementations of [idefixgo.Outbox], queueing the
+// messages published by a client while it is disconnected.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	ifx "github.com/nayarsystems/idefix-go"
+)
+
+var _ ifx.Outbox = (*SqliteOutbox)(nil)
+
+// Options defines the retention policy of an outbox
+type Options struct {
+	TTL     time.Duration // Messages queued for longer than TTL are discarded. Zero keeps them forever.
+	MaxSize int           // Maximum number of queued messages. When exceeded the oldest ones are discarded. Zero means no limit.
+	Timeout time.Duration // Timeout of the database operations. Defaults to 60 seconds.
+	Logger  *slog.Logger  // Logger of the outbox diagnostics. Defaults to the logger of the client using it, or slog.Default().
+}
+
+// SqliteOutbox is an [ifx.Outbox] stored in a SQLite database
+type SqliteOutbox struct {
+	db      *sql.DB
+	opts    Options
+	timeout time.Duration
+	log     atomic.Pointer[slog.Logger]
+}
+
+// NewSqliteOutbox opens (or creates) the outbox stored in the SQLite database at path
+func NewSqliteOutbox(path string, opts Options) (*SqliteOutbox, error) {
+	if path == "" {
+		return nil, fmt.Errorf("need path parameter to open the outbox")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
+	}
+
+	o := &SqliteOutbox{opts: opts, timeout: opts.Timeout}
+	if o.timeout <= 0 {
+		o.timeout = time.Second * 60
+	}
+	if opts.Logger != nil {
+		o.log.Store(opts.Logger)
+	} else {
+		o.log.Store(slog.Default())
+	}
+
+	var err error
+	o.db, err = sql.Open("sqlite3", fmt.Sprintf("file:%s", path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	o.db.SetMaxOpenConns(1)
+	o.db.SetMaxIdleConns(1)
+
+	ctx, cancel := o.context()
+	defer cancel()
+
+	pragmas := []string{
+		fmt.Sprintf("PRAGMA busy_timeout = %d", o.timeout.Milliseconds()),
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = FULL",
+	}
+	for _, pragma := range pragmas {
+		if _, err := o.db.ExecContext(ctx, pragma); err != nil {
+			o.logger().Warn("failed to execute pragma", "pragma", pragma, "error", err)
+		}
+	}
+
+	schema := `
+	   CREATE TABLE IF NOT EXISTS outbox (
+		   id INTEGER PRIMARY KEY AUTOINCREMENT,
+		   flags TEXT NOT NULL,
+		   data BLOB NOT NULL,
+		   created INTEGER NOT NULL
+	   )`
+	if _, err := o.db.ExecContext(ctx, schema); err != nil {
+		o.db.Close()
+		return nil, fmt.Errorf("failed to create outbox table: %w", err)
+	}
+	if _, err := o.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS idx_outbox_created ON outbox(created)`); err != nil {
+		o.logger().Warn("failed to create created index", "error", err)
+	}
+	return o, nil
+}
+
+// SetLogger sets the logger of the outbox diagnostics, unless Options.Logger is set.
+// The client calls it with its own logger when connecting.
+func (o *SqliteOutbox) SetLogger(l *slog.Logger) {
+	if o.opts.Logger == nil && l != nil {
+		o.log.Store(l)
+	}
+}
+
+func (o *SqliteOutbox) logger() *slog.Logger {
+	return o.log.Load()
+}
+
+func (o *SqliteOutbox) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), o.timeout)
+}
+
+// purge removes the expired messages
+func (o *SqliteOutbox) purge(ctx context.Context) error {
+	if o.opts.TTL <= 0 {
+		return nil
+	}
+	res, err := o.db.ExecContext(ctx, `DELETE FROM outbox WHERE created < ?`, time.Now().Add(-o.opts.TTL).UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to purge expired messages: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		o.logger().Debug("expired outbox messages discarded", "count", n)
+	}
+	return nil
+}
+
+// Push appends a message, discarding the oldest ones if the outbox exceeds MaxSize
+func (o *SqliteOutbox) Push(flags string, data []byte) error {
+	ctx, cancel := o.context()
+	defer cancel()
+
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO outbox (flags, data, created) VALUES (?, ?, ?)`, flags, data, time.Now().UnixNano()); err != nil {
+		return fmt.Errorf("failed to push message: %w", err)
+	}
+	if o.opts.MaxSize > 0 {
+		res, err := tx.ExecContext(ctx, `DELETE FROM outbox WHERE id NOT IN (SELECT id FROM outbox ORDER BY id DESC LIMIT ?)`, o.opts.MaxSize)
+		if err != nil {
+			return fmt.Errorf("failed to trim outbox: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			o.logger().Warn("outbox full, oldest messages discarded", "count", n)
+		}
+	}
+	return tx.Commit()
+}
+
+// Peek returns up to limit of the oldest messages not expired
+func (o *SqliteOutbox) Peek(limit int) ([]ifx.OutboxMessage, error) {
+	ctx, cancel := o.context()
+	defer cancel()
+	if err := o.purge(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := o.db.QueryContext(ctx, `SELECT id, flags, data FROM outbox ORDER BY id LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	var msgs []ifx.OutboxMessage
+	for rows.Next() {
+		var msg ifx.OutboxMessage
+		if err := rows.Scan(&msg.ID, &msg.Flags, &msg.Data); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	return msgs, nil
+}
+
+// Remove deletes a message
+func (o *SqliteOutbox) Remove(id uint64) error {
+	ctx, cancel := o.context()
+	defer cancel()
+	if _, err := o.db.ExecContext(ctx, `DELETE FROM outbox WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to remove message: %w", err)
+	}
+	return nil
+}
+
+// Len returns the number of messages not expired
+func (o *SqliteOutbox) Len() (int, error) {
+	ctx, cancel := o.context()
+	defer cancel()
+	if err := o.purge(ctx); err != nil {
+		return 0, err
+	}
+	var n int
+	if err := o.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM outbox`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count messages: %w", err)
+	}
+	return n, nil
+}
+
+// Close closes the database
+func (o *SqliteOutbox) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if _, err := o.db.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		o.logger().Warn("failed to checkpoint WAL on close", "error", err)
+	}
+	return o.db.Close()
+}
@@ -0,0 +1,83 @@
+package outbox
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSqliteOutbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	o, err := NewSqliteOutbox(path, Options{})
+	require.NoError(t, err)
+
+	for _, data := range []string{"a", "b", "c"} {
+		require.NoError(t, o.Push("m", []byte(data)))
+	}
+	n, err := o.Len()
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	msgs, err := o.Peek(2)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	require.Equal(t, "m", msgs[0].Flags)
+	require.Equal(t, []byte("a"), msgs[0].Data)
+	require.Equal(t, []byte("b"), msgs[1].Data)
+	require.NoError(t, o.Remove(msgs[0].ID))
+
+	// Messages persist across reopens
+	require.NoError(t, o.Close())
+	o, err = NewSqliteOutbox(path, Options{})
+	require.NoError(t, err)
+	defer o.Close()
+	msgs, err = o.Peek(10)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	require.Equal(t, []byte("b"), msgs[0].Data)
+	require.Equal(t, []byte("c"), msgs[1].Data)
+}
+
+func TestSqliteOutboxRetention(t *testing.T) {
+	o, err := NewSqliteOutbox(filepath.Join(t.TempDir(), "outbox.db"), Options{MaxSize: 2, TTL: time.Millisecond * 200})
+	require.NoError(t, err)
+	defer o.Close()
+
+	// The oldest messages are discarded when full
+	for _, data := range []string{"a", "b", "c"} {
+		require.NoError(t, o.Push("m", []byte(data)))
+	}
+	msgs, err := o.Peek(10)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	require.Equal(t, []byte("b"), msgs[0].Data)
+	require.Equal(t, []byte("c"), msgs[1].Data)
+
+	// Expired messages are discarded
+	time.Sleep(time.Millisecond * 250)
+	require.NoError(t, o.Push("m", []byte("d")))
+	n, err := o.Len()
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	msgs, err = o.Peek(10)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, []byte("d"), msgs[0].Data)
+}
+
+func TestSqliteOutboxLogger(t *testing.T) {
+	o, err := NewSqliteOutbox(filepath.Join(t.TempDir(), "outbox.db"), Options{MaxSize: 1})
+	require.NoError(t, err)
+	defer o.Close()
+
+	// The client hands its logger to the outbox
+	var buf bytes.Buffer
+	o.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	require.NoError(t, o.Push("m", []byte("a")))
+	require.NoError(t, o.Push("m", []byte("b")))
+	require.Contains(t, buf.String(), "outbox full")
+}
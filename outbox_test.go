@@ -0,0 +1,101 @@
+package idefixgo_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ifx "github.com/nayarsystems/idefix-go"
+	"github.com/nayarsystems/idefix-go/idefixtest"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/nayarsystems/idefix-go/outbox"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyTransport is a loopback transport whose broker can be made unreachable
+type flakyTransport struct {
+	*ifx.LoopbackTransport
+	down atomic.Bool
+}
+
+func (t *flakyTransport) Connect(clientID string) error {
+	if t.down.Load() {
+		return errors.New("broker unreachable")
+	}
+	return t.LoopbackTransport.Connect(clientID)
+}
+
+func TestOutbox(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.CreateAddress("dev1", "t1", "")
+	s.CreateAddress("dev2", "t2", "")
+
+	receiver, err := s.Connect(context.Background(), "dev2", "t2")
+	require.NoError(t, err)
+	defer receiver.Disconnect()
+	var mutex sync.Mutex
+	var received []any
+	_, err = receiver.Handle("data", func(ctx context.Context, msg *m.Message) (any, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = append(received, msg.Data)
+		return nil, nil
+	}, ifx.HandlerOptions{Concurrency: 1})
+	require.NoError(t, err)
+	receivedData := func() []any {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]any(nil), received...)
+	}
+
+	ob, err := outbox.NewSqliteOutbox(filepath.Join(t.TempDir(), "outbox.db"), outbox.Options{})
+	require.NoError(t, err)
+	defer ob.Close()
+	tr := &flakyTransport{LoopbackTransport: s.Broker().NewTransport()}
+	c := s.NewClient(context.Background(), &ifx.ClientOptions{
+		Address:              "dev1",
+		Token:                "t1",
+		Transport:            tr,
+		Reconnect:            true,
+		ReconnectMinInterval: time.Millisecond * 10,
+		ReconnectMaxInterval: time.Millisecond * 50,
+		Outbox:               ob,
+	})
+
+	// Messages published before connecting are queued
+	require.NoError(t, c.Publish("dev2", &m.Message{To: "data", Data: "a"}))
+	depth, err := c.OutboxDepth()
+	require.NoError(t, err)
+	require.Equal(t, 1, depth)
+
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+	require.Eventually(t, func() bool { return len(receivedData()) == 1 }, time.Second*2, time.Millisecond*10)
+	require.NoError(t, c.Publish("dev2", &m.Message{To: "data", Data: "b"}))
+	require.Eventually(t, func() bool { return len(receivedData()) == 2 }, time.Second*2, time.Millisecond*10)
+
+	// Messages published during an outage are replayed in order
+	tr.down.Store(true)
+	tr.Drop(errors.New("outage"))
+	require.Eventually(t, func() bool { return c.Status() == ifx.Disconnected }, time.Second, time.Millisecond*10)
+	for _, data := range []string{"c", "d", "e"} {
+		require.NoError(t, c.Publish("dev2", &m.Message{To: "data", Data: data}))
+	}
+	depth, err = c.OutboxDepth()
+	require.NoError(t, err)
+	require.Equal(t, 3, depth)
+	require.Equal(t, ifx.ClientStatus{Connection: ifx.Disconnected, OutboxDepth: 3}, c.StatusInfo())
+
+	tr.down.Store(false)
+	require.Eventually(t, func() bool { return len(receivedData()) == 5 }, time.Second*2, time.Millisecond*10)
+	require.Equal(t, []any{"a", "b", "c", "d", "e"}, receivedData())
+	depth, err = c.OutboxDepth()
+	require.NoError(t, err)
+	require.Equal(t, 0, depth)
+}
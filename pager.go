@@ -0,0 +1,157 @@
+package idefixgo
+
+import (
+	"context"
+	"iter"
+	"maps"
+	"slices"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+)
+
+const (
+	// DefaultPageSize is the number of addresses requested per page when the query has no limit
+	DefaultPageSize = 100
+	// DefaultPollTimeout is the long-polling duration used to follow new events when the query has no timeout
+	DefaultPollTimeout = time.Second * 30
+
+	// pageCallMargin is added to the long-polling duration of a page request to get its deadline
+	pageCallMargin = time.Second * 10
+)
+
+// DomainAddress is an address along with the domain it belongs to
+type DomainAddress struct {
+	Address string
+	Domain  string
+}
+
+// IterEvents returns an iterator over the events matching the query, transparently following
+// the continuation ID of each page. The query Limit sets the page size, and query.ContinuationID
+// is updated after each page, so the query can be used later on to resume the iteration.
+//
+// Without follow, the iteration ends once all the stored events are returned. With follow, the
+// iterator keeps long-polling for new events (during query.Timeout, or [DefaultPollTimeout] if
+// unset) until the context is done or the loop is broken.
+//
+// The iteration also ends after a page of events which does not advance the continuation ID, as
+// the next page could not be requested; with follow, this is reported as an error.
+//
+// An error stops the iteration, and is yielded along with a nil event.
+func (c *Client) IterEvents(ctx context.Context, query *m.EventsGetMsg, follow bool) iter.Seq2[*m.Event, error] {
+	return func(yield func(*m.Event, error) bool) {
+		req := *query
+		req.Timeout = 0
+		if follow {
+			// The long-polling returns straight away while there are stored events
+			req.Timeout = query.Timeout
+			if req.Timeout <= 0 {
+				req.Timeout = DefaultPollTimeout
+			}
+		}
+
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+			callCtx, cancel := context.WithTimeout(ctx, req.Timeout+pageCallMargin)
+			res, err := c.EventsGet(&req, callCtx)
+			cancel()
+			if err != nil {
+				if ctx.Err() != nil {
+					err = ctx.Err()
+				}
+				yield(nil, err)
+				return
+			}
+			advanced := res.ContinuationID != "" && res.ContinuationID != req.ContinuationID
+			if advanced {
+				req.ContinuationID = res.ContinuationID
+				query.ContinuationID = res.ContinuationID
+			}
+
+			for _, e := range res.Events {
+				if !yield(e, nil) {
+					return
+				}
+			}
+
+			if len(res.Events) == 0 && !follow {
+				return
+			}
+			if len(res.Events) > 0 && !advanced {
+				// Requesting the same page again would return the same events
+				if follow {
+					yield(nil, ie.ErrInternal.With("events page without a new continuation ID"))
+				}
+				return
+			}
+		}
+	}
+}
+
+// IterDomainAddresses returns an iterator over the addresses of a domain, requesting them in pages
+// of query.Limit addresses (or [DefaultPageSize] if unset). query.Skip is updated after each page,
+// so the query can be used later on to resume the iteration. The addresses of each page are yielded
+// in alphabetical order.
+//
+// An error stops the iteration, and is yielded along with an empty [DomainAddress].
+func (c *Client) IterDomainAddresses(ctx context.Context, query *m.DomainListAddressesMsg) iter.Seq2[DomainAddress, error] {
+	return func(yield func(DomainAddress, error) bool) {
+		req := *query
+		if req.Limit == 0 {
+			req.Limit = DefaultPageSize
+		}
+
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(DomainAddress{}, err)
+				return
+			}
+			callCtx, cancel := context.WithTimeout(ctx, defaultSyscallTimeout)
+			res, err := c.DomainListAddresses(&req, callCtx)
+			cancel()
+			if err != nil {
+				yield(DomainAddress{}, err)
+				return
+			}
+			req.Skip += uint(len(res.Addresses))
+			query.Skip = req.Skip
+
+			for _, a := range slices.Sorted(maps.Keys(res.Addresses)) {
+				if !yield(DomainAddress{Address: a, Domain: res.Addresses[a]}, nil) {
+					return
+				}
+			}
+
+			if uint(len(res.Addresses)) < req.Limit {
+				return
+			}
+		}
+	}
+}
+
+// IterGroupAddresses returns an iterator over the addresses of a group, sorted by domain and address.
+// The group is requested at once, as group.get has no pagination.
+//
+// An error stops the iteration, and is yielded along with an empty [DomainAddress].
+func (c *Client) IterGroupAddresses(ctx context.Context, query *m.GroupGetAddressesMsg) iter.Seq2[DomainAddress, error] {
+	return func(yield func(DomainAddress, error) bool) {
+		callCtx, cancel := context.WithTimeout(ctx, defaultSyscallTimeout)
+		res, err := c.GroupGetAddresses(query, callCtx)
+		cancel()
+		if err != nil {
+			yield(DomainAddress{}, err)
+			return
+		}
+		for _, d := range slices.Sorted(maps.Keys(res.Addresses)) {
+			for _, a := range slices.Sorted(slices.Values(res.Addresses[d])) {
+				if !yield(DomainAddress{Address: a, Domain: d}, nil) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package idefixgo_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	ifx "github.com/nayarsystems/idefix-go"
+	ie "github.com/nayarsystems/idefix-go/errors"
+	"github.com/nayarsystems/idefix-go/idefixtest"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterDomainAddresses(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	var expected []ifx.DomainAddress
+	for i := range 5 {
+		name := fmt.Sprintf("dev%d", i)
+		s.CreateAddress(name, "t", "acme")
+		expected = append(expected, ifx.DomainAddress{Address: name, Domain: "acme"})
+	}
+
+	c, err := s.Connect(context.Background(), "admin", "adminToken")
+	require.NoError(t, err)
+	defer c.Disconnect()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	query := &m.DomainListAddressesMsg{Domain: "acme", Limit: 2}
+	var res []ifx.DomainAddress
+	for a, err := range c.IterDomainAddresses(ctx, query) {
+		require.NoError(t, err)
+		res = append(res, a)
+	}
+	require.Equal(t, expected, res)
+	require.EqualValues(t, 5, query.Skip)
+
+	// Breaking the loop stops requesting pages
+	query = &m.DomainListAddressesMsg{Domain: "acme", Limit: 2}
+	for range c.IterDomainAddresses(ctx, query) {
+		break
+	}
+	require.EqualValues(t, 2, query.Skip)
+
+	// Errors are yielded
+	var errs []error
+	for _, err := range c.IterDomainAddresses(ctx, &m.DomainListAddressesMsg{Domain: "unknown"}) {
+		errs = append(errs, err)
+	}
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], ie.ErrDomainNotFound)
+}
+
+func TestIterGroupAddresses(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.CreateDomain("acme")
+	s.CreateAddress("dev2", "t", "acme")
+	s.CreateAddress("dev1", "t", "plant.acme")
+
+	c, err := s.Connect(context.Background(), "admin", "adminToken")
+	require.NoError(t, err)
+	defer c.Disconnect()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, err = c.GroupAddAddress(&m.GroupAddAddressMsg{Domain: "acme", Group: "g", Address: "dev2"})
+	require.NoError(t, err)
+	_, err = c.GroupAddAddress(&m.GroupAddAddressMsg{Domain: "plant.acme", Group: "g", Address: "dev1"})
+	require.NoError(t, err)
+
+	var res []ifx.DomainAddress
+	for a, err := range c.IterGroupAddresses(ctx, &m.GroupGetAddressesMsg{Domain: "acme", Group: "g"}) {
+		require.NoError(t, err)
+		res = append(res, a)
+	}
+	require.Equal(t, []ifx.DomainAddress{{Address: "dev2", Domain: "acme"}, {Address: "dev1", Domain: "plant.acme"}}, res)
+}
+
+func TestIterEvents(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.CreateAddress("dev1", "t", "acme")
+	for i := range 5 {
+		s.AddEvents(&m.Event{EventMsg: m.EventMsg{UID: fmt.Sprintf("e%d", i)}, Domain: "acme", Address: "dev1"})
+	}
+
+	c, err := s.Connect(context.Background(), "admin", "adminToken")
+	require.NoError(t, err)
+	defer c.Disconnect()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	query := &m.EventsGetMsg{Domain: "acme", Limit: 2}
+	var uids []string
+	for e, err := range c.IterEvents(ctx, query, false) {
+		require.NoError(t, err)
+		uids = append(uids, e.UID)
+	}
+	require.Equal(t, []string{"e0", "e1", "e2", "e3", "e4"}, uids)
+	require.NotEmpty(t, query.ContinuationID)
+
+	// Resuming from the updated query only returns new events
+	for range c.IterEvents(ctx, query, false) {
+		require.Fail(t, "unexpected event")
+	}
+
+	// Following waits for new events
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		s.AddEvents(&m.Event{EventMsg: m.EventMsg{UID: "e5"}, Domain: "acme", Address: "dev1"})
+	}()
+	query.Timeout = time.Second
+	for e, err := range c.IterEvents(ctx, query, true) {
+		require.NoError(t, err)
+		require.Equal(t, "e5", e.UID)
+		break
+	}
+
+	// Cancelling the context stops following
+	followCtx, followCancel := context.WithTimeout(ctx, time.Millisecond*200)
+	defer followCancel()
+	var errs []error
+	for e, err := range c.IterEvents(followCtx, query, true) {
+		require.Nil(t, e)
+		errs = append(errs, err)
+	}
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], context.DeadlineExceeded)
+}
+
+func TestIterEventsStuckCursor(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.CreateAddress("dev1", "t", "acme")
+	for i := range 3 {
+		s.AddEvents(&m.Event{EventMsg: m.EventMsg{UID: fmt.Sprintf("e%d", i)}, Domain: "acme", Address: "dev1"})
+	}
+
+	c, err := s.Connect(context.Background(), "admin", "adminToken")
+	require.NoError(t, err)
+	defer c.Disconnect()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// The responses carry no continuation ID
+	c.Use(func(next ifx.CallFunc) ifx.CallFunc {
+		return func(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+			res, err := next(ctx, remoteAddress, msg)
+			if err == nil {
+				if data, ok := res.Data.(map[string]any); ok {
+					delete(data, "cid")
+				}
+			}
+			return res, err
+		}
+	})
+
+	// The same page is not requested again
+	var uids []string
+	for e, err := range c.IterEvents(ctx, &m.EventsGetMsg{Domain: "acme", Limit: 2}, false) {
+		require.NoError(t, err)
+		uids = append(uids, e.UID)
+	}
+	require.Equal(t, []string{"e0", "e1"}, uids)
+
+	var errs []error
+	for _, err := range c.IterEvents(ctx, &m.EventsGetMsg{Domain: "acme", Limit: 2}, true) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], ie.ErrInternal)
+}
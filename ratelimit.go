@@ -0,0 +1,209 @@
+package idefixgo
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+)
+
+// RateLimit bounds the requests sent with [Client.Call], [Client.CallWithContext] and the
+// typed cloud methods (see [ClientOptions.RateLimit] and [ClientOptions.AddressRateLimit]).
+//
+// Every attempt of a request (see [RetryPolicy]) takes a token from a bucket refilled at Rate
+// tokens per second, and holds one of the MaxInFlight slots until its response arrives.
+type RateLimit struct {
+	Rate        float64 `json:"rate,omitempty"`        // Sustained requests per second. Zero means no rate limit.
+	Burst       int     `json:"burst,omitempty"`       // Requests allowed at once above Rate (bucket size). Defaults to Rate, with a minimum of 1.
+	MaxInFlight int     `json:"maxInFlight,omitempty"` // Maximum number of requests waiting for their response. Zero means no limit.
+	NoWait      bool    `json:"noWait,omitempty"`      // Fail with ErrTryAgain (not retried by the RetryPolicy) when the limit is hit, instead of waiting (bounded by the request context).
+}
+
+// limiterSweepInterval is how often the idle address limiters are dropped
+const limiterSweepInterval = time.Minute
+
+// limitRejected is the error of a request rejected by a NoWait limit. It is an [ie.ErrTryAgain]
+// for the caller, but the [RetryPolicy] does not retry it.
+type limitRejected struct {
+	ie.IdefixError
+}
+
+// limiter is a token bucket along with a counter of in-flight requests
+type limiter struct {
+	limit    RateLimit
+	mutex    sync.Mutex
+	tokens   float64
+	last     time.Time
+	inFlight int
+	released chan struct{} // closed (and replaced) each time an in-flight request finishes
+}
+
+func newLimiter(limit RateLimit) *limiter {
+	if limit.Burst <= 0 {
+		limit.Burst = max(1, int(math.Ceil(limit.Rate)))
+	}
+	return &limiter{
+		limit:    limit,
+		tokens:   float64(limit.Burst),
+		last:     time.Now(),
+		released: make(chan struct{}),
+	}
+}
+
+// acquire waits for a token and an in-flight slot. The returned function releases the slot.
+func (l *limiter) acquire(ctx context.Context) (release func(), err error) {
+	for {
+		l.mutex.Lock()
+		if l.limit.Rate > 0 {
+			now := time.Now()
+			l.tokens = min(float64(l.limit.Burst), l.tokens+now.Sub(l.last).Seconds()*l.limit.Rate)
+			l.last = now
+		}
+		slotFree := l.limit.MaxInFlight <= 0 || l.inFlight < l.limit.MaxInFlight
+		tokenFree := l.limit.Rate <= 0 || l.tokens >= 1
+		if slotFree && tokenFree {
+			if l.limit.Rate > 0 {
+				l.tokens--
+			}
+			l.inFlight++
+			l.mutex.Unlock()
+			return sync.OnceFunc(l.release), nil
+		}
+
+		if l.limit.NoWait {
+			l.mutex.Unlock()
+			if !slotFree {
+				return nil, limitRejected{ie.ErrTryAgain.With("too many requests in flight")}
+			}
+			return nil, limitRejected{ie.ErrTryAgain.With("rate limit exceeded")}
+		}
+
+		// Wait for a slot to be released or, if there is a free one, for the next token
+		released := l.released
+		var t *time.Timer
+		var wait <-chan time.Time
+		if slotFree {
+			t = time.NewTimer(time.Duration((1 - l.tokens) / l.limit.Rate * float64(time.Second)))
+			wait = t.C
+		}
+		l.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			err = ie.ErrTimeout.With("waiting for the rate limit")
+		case <-released:
+		case <-wait:
+		}
+		if t != nil {
+			t.Stop()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (l *limiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.inFlight--
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// refund gives back the token of a request that was not sent
+func (l *limiter) refund() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.limit.Rate > 0 {
+		l.tokens = min(float64(l.limit.Burst), l.tokens+1)
+	}
+}
+
+// idle reports whether the limiter is in its initial state (no requests in flight and a full
+// bucket), so that dropping it and creating it again later makes no difference
+func (l *limiter) idle() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.limit.Rate > 0 {
+		now := time.Now()
+		l.tokens = min(float64(l.limit.Burst), l.tokens+now.Sub(l.last).Seconds()*l.limit.Rate)
+		l.last = now
+	}
+	return l.inFlight == 0 && (l.limit.Rate <= 0 || l.tokens >= float64(l.limit.Burst))
+}
+
+// limiters returns the limiters applying to a remote address: its own one first, then the global one
+func (c *Client) limiters(remoteAddress string) []*limiter {
+	if c.opts.RateLimit == nil && c.opts.AddressRateLimit == nil && len(c.opts.AddressRateLimits) == 0 {
+		return nil
+	}
+
+	c.limitersMutex.Lock()
+	defer c.limitersMutex.Unlock()
+	var res []*limiter
+	limit, ok := c.opts.AddressRateLimits[remoteAddress]
+	if !ok && c.opts.AddressRateLimit != nil {
+		limit, ok = *c.opts.AddressRateLimit, true
+	}
+	if ok {
+		l := c.addressLimiters[remoteAddress]
+		if l == nil {
+			c.sweepLimiters()
+			l = newLimiter(limit)
+			if c.addressLimiters == nil {
+				c.addressLimiters = make(map[string]*limiter)
+			}
+			c.addressLimiters[remoteAddress] = l
+		}
+		res = append(res, l)
+	}
+	if c.opts.RateLimit != nil {
+		if c.globalLimiter == nil {
+			c.globalLimiter = newLimiter(*c.opts.RateLimit)
+		}
+		res = append(res, c.globalLimiter)
+	}
+	return res
+}
+
+// sweepLimiters drops the idle address limiters (at most once per limiterSweepInterval), so
+// that a client talking to many addresses does not keep a limiter for each of them.
+// It must be called with limitersMutex held.
+func (c *Client) sweepLimiters() {
+	if time.Since(c.limitersSwept) < limiterSweepInterval {
+		return
+	}
+	c.limitersSwept = time.Now()
+	for address, l := range c.addressLimiters {
+		if l.idle() {
+			delete(c.addressLimiters, address)
+		}
+	}
+}
+
+// rateLimited wraps a [CallFunc], holding the request until the rate limits of its
+// remote address allow it (or failing with [ie.ErrTryAgain] if they don't wait)
+func (c *Client) rateLimited(next CallFunc) CallFunc {
+	return func(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+		// The address limiter is acquired first, so that waiting for it does not hold a global slot
+		var acquired []*limiter
+		for _, l := range c.limiters(remoteAddress) {
+			release, err := l.acquire(ctx)
+			if err != nil {
+				// The request is not sent, so the tokens already taken are given back
+				for _, l := range acquired {
+					l.refund()
+				}
+				c.Logger().Debug("request throttled", "address", remoteAddress, "topic", msg.To, "error", err)
+				return nil, err
+			}
+			defer release()
+			acquired = append(acquired, l)
+		}
+		return next(ctx, remoteAddress, msg)
+	}
+}
@@ -0,0 +1,150 @@
+package idefixgo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	// Token bucket
+	l := newLimiter(RateLimit{Rate: 20, Burst: 2, NoWait: true})
+	for range 2 {
+		release, err := l.acquire(ctx)
+		require.NoError(t, err)
+		release()
+	}
+	_, err := l.acquire(ctx)
+	require.ErrorIs(t, err, ie.ErrTryAgain)
+	time.Sleep(time.Millisecond * 60)
+	_, err = l.acquire(ctx)
+	require.NoError(t, err)
+
+	// In-flight slots
+	l = newLimiter(RateLimit{MaxInFlight: 1})
+	release, err := l.acquire(ctx)
+	require.NoError(t, err)
+	shortCtx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancel()
+	_, err = l.acquire(shortCtx)
+	require.ErrorIs(t, err, ie.ErrTimeout)
+
+	acquired := make(chan struct{})
+	go func() {
+		release, err := l.acquire(ctx)
+		require.NoError(t, err)
+		release()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("slot acquired while in use")
+	case <-time.After(time.Millisecond * 50):
+	}
+	release()
+	release() // Releasing twice has no effect
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("slot not acquired after release")
+	}
+	require.Equal(t, 0, l.inFlight)
+}
+
+func TestRateLimit(t *testing.T) {
+	b := NewLoopbackBroker()
+	newLoopbackResponder(t, b)
+
+	c := newLoopbackClient(b, false)
+	c.opts.AddressRateLimits = map[string]RateLimit{"idefix": {Rate: 20, Burst: 1}}
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	// The login took the only token, so every call waits for a new one
+	start := time.Now()
+	for range 3 {
+		_, err := c.Call("idefix", &m.Message{To: "echo", Data: map[string]any{}}, time.Second)
+		require.NoError(t, err)
+	}
+	require.GreaterOrEqual(t, time.Since(start), time.Millisecond*140)
+
+	// Other addresses are not limited, but the global limit applies to all of them
+	c.opts.RateLimit = &RateLimit{Rate: 1, NoWait: true}
+	_, err := c.Call("idefix", &m.Message{To: "echo", Data: map[string]any{}}, time.Second)
+	require.NoError(t, err)
+	_, err = c.Call("dev", &m.Message{To: "echo"}, time.Second)
+	require.ErrorIs(t, err, ie.ErrTryAgain)
+}
+
+func TestRateLimitRefund(t *testing.T) {
+	b := NewLoopbackBroker()
+	newLoopbackResponder(t, b)
+
+	c := newLoopbackClient(b, false)
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	// A request rejected by the global limit gives back its address token
+	c.opts.AddressRateLimit = &RateLimit{Rate: 0.1, Burst: 1}
+	c.opts.RateLimit = &RateLimit{MaxInFlight: 1, NoWait: true}
+	release, err := c.limiters("dev")[1].acquire(context.Background())
+	require.NoError(t, err)
+	_, err = c.Call("dev", &m.Message{To: "echo"}, time.Second)
+	require.ErrorIs(t, err, ie.ErrTryAgain)
+	release()
+	require.EqualValues(t, 1, c.addressLimiters["dev"].tokens)
+}
+
+func TestRateLimitNoRetry(t *testing.T) {
+	b := NewLoopbackBroker()
+	newLoopbackResponder(t, b)
+
+	c := newLoopbackClient(b, false)
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	var attempts int
+	c.Use(func(next CallFunc) CallFunc {
+		return func(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+			attempts++
+			return next(ctx, remoteAddress, msg)
+		}
+	})
+
+	// The requests rejected by a NoWait limit are not retried
+	c.opts.RateLimit = &RateLimit{Rate: 0.1, Burst: 1, NoWait: true}
+	ctx := WithRetryPolicy(context.Background(), &RetryPolicy{MaxAttempts: 5, MinBackoff: time.Millisecond})
+	_, err := c.CallWithContext(ctx, "idefix", &m.Message{To: "echo", Data: map[string]any{}})
+	require.NoError(t, err)
+	_, err = c.CallWithContext(ctx, "idefix", &m.Message{To: "echo", Data: map[string]any{}})
+	require.ErrorIs(t, err, ie.ErrTryAgain)
+	require.Equal(t, 2, attempts)
+}
+
+func TestRateLimitSweep(t *testing.T) {
+	c := NewClient(context.Background(), &ClientOptions{AddressRateLimit: &RateLimit{Rate: 1000, Burst: 1}})
+
+	busy, err := c.limiters("busy")[0].acquire(context.Background())
+	require.NoError(t, err)
+	defer busy()
+	for i := range 100 {
+		release, err := c.limiters(fmt.Sprintf("dev%d", i))[0].acquire(context.Background())
+		require.NoError(t, err)
+		release()
+	}
+	require.Len(t, c.addressLimiters, 101)
+
+	// The idle limiters are dropped once the sweep interval elapses
+	time.Sleep(time.Millisecond * 10)
+	c.limitersSwept = time.Now().Add(-limiterSweepInterval)
+	c.limiters("new")
+	require.Len(t, c.addressLimiters, 2)
+	require.Contains(t, c.addressLimiters, "busy")
+}
@@ -0,0 +1,161 @@
+package idefixgo
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReconnectMinInterval = time.Second
+	defaultReconnectMaxInterval = time.Minute
+)
+
+// backoff computes exponentially growing delays with jitter, bounded by a
+// minimum and a maximum interval.
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	attempt uint
+}
+
+func newBackoff(min, max time.Duration) *backoff {
+	if min <= 0 {
+		min = defaultReconnectMinInterval
+	}
+	if max <= 0 {
+		max = defaultReconnectMaxInterval
+	}
+	if max < min {
+		max = min
+	}
+	return &backoff{min: min, max: max}
+}
+
+// next returns the delay to wait before the next attempt. The delay is chosen
+// randomly between half and the full value of the exponential step, so that
+// several clients losing the connection at the same time do not retry in sync.
+func (b *backoff) next() time.Duration {
+	d := b.min
+	for i := uint(0); i < b.attempt && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	b.attempt++
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// reset starts over from the minimum interval
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+// reconnectHooks holds the functions to be executed each time the client
+// recovers from a connection loss.
+type reconnectHooks struct {
+	m     sync.Mutex
+	next  uint64
+	hooks map[uint64]func()
+}
+
+func (h *reconnectHooks) add(fn func()) (remove func()) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if h.hooks == nil {
+		h.hooks = make(map[uint64]func())
+	}
+	id := h.next
+	h.next++
+	h.hooks[id] = fn
+	return func() {
+		h.m.Lock()
+		defer h.m.Unlock()
+		delete(h.hooks, id)
+	}
+}
+
+func (h *reconnectHooks) run() {
+	h.m.Lock()
+	fns := make([]func(), 0, len(h.hooks))
+	for _, fn := range h.hooks {
+		fns = append(fns, fn)
+	}
+	h.m.Unlock()
+
+	for _, fn := range fns {
+		go fn()
+	}
+}
+
+// onReconnect registers a function that will be called (in its own goroutine)
+// every time the client restores its session after a connection loss.
+// The returned function unregisters it.
+func (c *Client) onReconnect(fn func()) (remove func()) {
+	return c.reconnectHooks.add(fn)
+}
+
+// startReconnect runs the reconnection loop in background, bound to the current
+// client context. Nothing is started once the client has been disconnected.
+func (c *Client) startReconnect() {
+	c.reconnectMutex.Lock()
+	defer c.reconnectMutex.Unlock()
+	ctx := c.ctx
+	if ctx.Err() != nil {
+		return
+	}
+	c.reconnecting.Add(1)
+	go func() {
+		defer c.reconnecting.Done()
+		c.reconnect(ctx)
+	}()
+}
+
+// stopReconnect cancels the client context and waits for the running
+// reconnection loops to end, so that they do not outlive the connection.
+func (c *Client) stopReconnect() {
+	c.reconnectMutex.Lock()
+	c.cancelFunc()
+	c.reconnectMutex.Unlock()
+	c.reconnecting.Wait()
+}
+
+// reconnect retries the connection until it succeeds or ctx (the client context
+// at the time of the connection loss) is cancelled. Once connected, the response
+// topic subscription and the login are restored, the outbox is replayed and the
+// registered reconnect hooks are executed.
+func (c *Client) reconnect(ctx context.Context) {
+	b := newBackoff(c.opts.ReconnectMinInterval, c.opts.ReconnectMaxInterval)
+	for {
+		t := time.NewTimer(b.next())
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		if err := c.dial(); err != nil {
+			if c.transport.IsConnected() {
+				c.transport.Disconnect()
+			}
+			continue
+		}
+
+		if ctx.Err() != nil {
+			// Disconnected by the user while reconnecting
+			c.transport.Disconnect()
+			return
+		}
+
+		c.setState(Connected)
+		if c.opts.Outbox != nil {
+			go c.replayOutbox()
+		}
+		c.reconnectHooks.run()
+		return
+	}
+}
@@ -0,0 +1,138 @@
+package idefixgo
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoffDefaults(t *testing.T) {
+	b := newBackoff(0, 0)
+	require.Equal(t, defaultReconnectMinInterval, b.min)
+	require.Equal(t, defaultReconnectMaxInterval, b.max)
+
+	// The maximum is never below the minimum
+	b = newBackoff(time.Second, time.Millisecond)
+	require.Equal(t, time.Second, b.max)
+}
+
+func TestBackoffGrowth(t *testing.T) {
+	lo, hi := time.Millisecond*100, time.Second
+	b := newBackoff(lo, hi)
+
+	step := lo
+	for range 10 {
+		d := b.next()
+		require.GreaterOrEqual(t, d, step/2)
+		require.LessOrEqual(t, d, step)
+		step = min(step*2, hi)
+	}
+
+	// Once capped, the delays stay within the upper half of the maximum
+	for range 100 {
+		d := b.next()
+		require.GreaterOrEqual(t, d, hi/2)
+		require.LessOrEqual(t, d, hi)
+	}
+
+	b.reset()
+	d := b.next()
+	require.GreaterOrEqual(t, d, lo/2)
+	require.LessOrEqual(t, d, lo)
+}
+
+func TestBackoffJitter(t *testing.T) {
+	// Clients losing the connection at the same time must not retry in sync
+	delays := map[time.Duration]struct{}{}
+	for range 20 {
+		delays[newBackoff(time.Second, time.Minute).next()] = struct{}{}
+	}
+	require.Greater(t, len(delays), 1)
+}
+
+func TestReconnectHooks(t *testing.T) {
+	b := NewLoopbackBroker()
+	newLoopbackResponder(t, b)
+
+	c := newLoopbackClient(b, true)
+	var called, removed atomic.Int32
+	c.onReconnect(func() { called.Add(1) })
+	remove := c.onReconnect(func() { removed.Add(1) })
+	remove()
+
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	// The hooks are not run on the first connection
+	time.Sleep(time.Millisecond * 50)
+	require.Zero(t, called.Load())
+
+	c.opts.Transport.(*LoopbackTransport).Drop(fmt.Errorf("test"))
+	require.Eventually(t, func() bool { return called.Load() == 1 }, time.Second, time.Millisecond*10)
+	require.Equal(t, Connected, c.Status())
+
+	c.opts.Transport.(*LoopbackTransport).Drop(fmt.Errorf("test"))
+	require.Eventually(t, func() bool { return called.Load() == 2 }, time.Second, time.Millisecond*10)
+	require.Zero(t, removed.Load())
+}
+
+func TestLoginRejected(t *testing.T) {
+	b := NewLoopbackBroker()
+	r := newLoopbackResponder(t, b)
+	r.rejectLogin.Store(true)
+
+	c := newLoopbackClient(b, false)
+	err := c.Connect()
+	require.Error(t, err)
+	require.ErrorIs(t, err, ie.ErrInvalidToken)
+	require.EqualValues(t, 1, r.logins.Load())
+}
+
+func TestReconnectLoginRejected(t *testing.T) {
+	b := NewLoopbackBroker()
+	r := newLoopbackResponder(t, b)
+
+	c := newLoopbackClient(b, true)
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	// A rejected login does not restore the session, the client keeps retrying
+	r.rejectLogin.Store(true)
+	c.opts.Transport.(*LoopbackTransport).Drop(fmt.Errorf("test"))
+	require.Eventually(t, func() bool { return r.logins.Load() >= 3 }, time.Second, time.Millisecond*10)
+	require.Equal(t, Disconnected, c.Status())
+
+	r.rejectLogin.Store(false)
+	require.Eventually(t, func() bool { return c.Status() == Connected }, time.Second, time.Millisecond*10)
+}
+
+func TestDisconnectStopsReconnect(t *testing.T) {
+	b := NewLoopbackBroker()
+	r := newLoopbackResponder(t, b)
+
+	c := newLoopbackClient(b, true)
+	c.opts.ReconnectMinInterval = time.Millisecond * 10
+	c.opts.ReconnectMaxInterval = time.Millisecond * 10
+	require.NoError(t, c.Connect())
+
+	r.rejectLogin.Store(true)
+	c.opts.Transport.(*LoopbackTransport).Drop(fmt.Errorf("test"))
+	require.Eventually(t, func() bool { return r.logins.Load() >= 3 }, time.Second, time.Millisecond*10)
+
+	// No reconnection attempt outlives the Disconnect
+	c.Disconnect()
+	logins := r.logins.Load()
+	time.Sleep(time.Millisecond * 100)
+	require.Equal(t, logins, r.logins.Load())
+	require.Equal(t, Disconnected, c.Status())
+
+	r.rejectLogin.Store(false)
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+	time.Sleep(time.Millisecond * 100)
+	require.Equal(t, logins+1, r.logins.Load())
+}
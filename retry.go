@@ -0,0 +1,147 @@
+package idefixgo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+)
+
+const (
+	defaultRetryMinBackoff = time.Millisecond * 100
+	defaultRetryMaxBackoff = time.Second * 5
+	// A third of the default syscall timeout, so that a request which gets no response
+	// is retried within it
+	defaultRetryAttemptTimeout = defaultSyscallTimeout / 3
+)
+
+// RetryPolicy defines how the requests sent with [Client.Call], [Client.CallWithContext]
+// and the typed cloud methods (see [Client.Syscall]) are retried when they fail.
+//
+// An attempt is only retried while the request context is alive. Unless AttemptTimeout
+// is set, a request which times out waiting for its response consumes the whole context,
+// so only the errors reported before the deadline (e.g. [ie.ErrTryAgain] while the client
+// is reconnecting, or an [ie.ErrTimeout] answered by the cloud) lead to a new attempt.
+type RetryPolicy struct {
+	MaxAttempts    int           `json:"maxAttempts,omitempty"`    // Maximum number of attempts, including the first one. Values below 2 disable retries.
+	MinBackoff     time.Duration `json:"minBackoff,omitempty"`     // Initial delay between attempts. Defaults to 100 milliseconds.
+	MaxBackoff     time.Duration `json:"maxBackoff,omitempty"`     // Maximum delay between attempts. Defaults to 5 seconds.
+	AttemptTimeout time.Duration `json:"attemptTimeout,omitempty"` // Optional timeout of each attempt. The request context deadline still applies.
+	RetryOn        []int         `json:"retryOn,omitempty"`        // IdefixError codes considered retryable. Defaults to ErrTimeout and ErrTryAgain.
+}
+
+// DefaultRetryPolicy is applied to the idempotent cloud commands when neither the client
+// options nor the request context provide a policy. Its attempts time out on their own,
+// so that a request which gets no response is retried.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	MinBackoff:     defaultRetryMinBackoff,
+	MaxBackoff:     defaultRetryMaxBackoff,
+	AttemptTimeout: defaultRetryAttemptTimeout,
+}
+
+// idempotentCmds are the read-only cloud commands, retried by default
+var idempotentCmds = map[string]bool{
+	m.CmdDomainGet:             true,
+	m.CmdDomainListAddresses:   true,
+	m.CmdDomainListGroups:      true,
+	m.CmdDomainCountAddresses:  true,
+	m.CmdDomainTree:            true,
+	m.CmdDomainEnvironmentGet:  true,
+	m.CmdGroupGetAddresses:     true,
+	m.CmdEnvGet:                true,
+	m.CmdEnvironmentGet:        true,
+	m.CmdAddressStatesGet:      true,
+	m.CmdAddressGetGroups:      true,
+	m.CmdAddressRulesGet:       true,
+	m.CmdAddressConfigGet:      true,
+	m.CmdAddressEnvironmentGet: true,
+	m.CmdAddressDomainGet:      true,
+	m.CmdAddressAliasGet:       true,
+	m.CmdEventsGet:             true,
+	m.CmdSchemasGet:            true,
+	m.CmdSessionList:           true,
+}
+
+type retryPolicyKey struct{}
+
+// WithRetryPolicy returns a copy of ctx carrying a retry policy for the requests using it.
+// This is how mutating requests opt in to retries, e.g.:
+//
+//	c.AddressConfigUpdate(query, idefixgo.WithRetryPolicy(ctx, &idefixgo.DefaultRetryPolicy))
+//
+// A nil policy disables the retries of the requests, even the idempotent ones.
+func WithRetryPolicy(ctx context.Context, policy *RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// Retryable reports whether err is one of the retryable error codes of the policy.
+func (p *RetryPolicy) Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if len(p.RetryOn) == 0 {
+		return ie.ErrTimeout.Is(err) || ie.ErrTryAgain.Is(err)
+	}
+	for _, code := range p.RetryOn {
+		if (ie.IdefixError{Code: code}).Is(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryPolicy returns the policy applying to a request (nil if it must not be retried).
+// A policy set in the context takes precedence. Otherwise only the idempotent cloud
+// commands are retried, using the client policy or [DefaultRetryPolicy].
+func (c *Client) retryPolicy(ctx context.Context, remoteAddress string, topic string) *RetryPolicy {
+	if policy, ok := ctx.Value(retryPolicyKey{}).(*RetryPolicy); ok {
+		return policy
+	}
+	if remoteAddress != m.IdefixCmdPrefix || !idempotentCmds[topic] {
+		return nil
+	}
+	if c.opts.RetryPolicy != nil {
+		return c.opts.RetryPolicy
+	}
+	return &DefaultRetryPolicy
+}
+
+// callWithRetries sends a request through next, retrying it according to policy
+func (c *Client) callWithRetries(ctx context.Context, policy *RetryPolicy, next CallFunc, remoteAddress string, msg *m.Message) (*m.Message, error) {
+	minBackoff, maxBackoff := policy.MinBackoff, policy.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultRetryMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	b := newBackoff(minBackoff, maxBackoff)
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if policy.AttemptTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, policy.AttemptTimeout)
+		}
+		res, err := next(attemptCtx, remoteAddress, msg)
+		cancel()
+
+		// The requests rejected by a NoWait rate limit must fail straight away
+		if err == nil || attempt >= policy.MaxAttempts || !policy.Retryable(err) || errors.As(err, &limitRejected{}) {
+			return res, err
+		}
+
+		t := time.NewTimer(b.next())
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return res, err
+		case <-c.ctx.Done():
+			t.Stop()
+			return res, err
+		case <-t.C:
+		}
+	}
+}
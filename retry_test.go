@@ -0,0 +1,109 @@
+package idefixgo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryable(t *testing.T) {
+	p := &RetryPolicy{}
+	require.True(t, p.Retryable(ie.ErrTimeout))
+	require.True(t, p.Retryable(ie.ErrTryAgain.With("reconnecting")))
+	require.True(t, p.Retryable(fmt.Errorf("%s", ie.ErrTimeout.Error())))
+	require.False(t, p.Retryable(ie.ErrNotFound))
+	require.False(t, p.Retryable(fmt.Errorf("not an idefix error")))
+	require.False(t, p.Retryable(nil))
+
+	p = &RetryPolicy{RetryOn: []int{ie.ErrNotFound.Code}}
+	require.True(t, p.Retryable(ie.ErrNotFound))
+	require.False(t, p.Retryable(ie.ErrTimeout))
+}
+
+func TestRetryPolicy(t *testing.T) {
+	b := NewLoopbackBroker()
+	newLoopbackResponder(t, b)
+
+	c := newLoopbackClient(b, false)
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	// Fails the first 2 attempts of each request
+	attempts := 0
+	c.Use(func(next CallFunc) CallFunc {
+		return func(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+			attempts++
+			if attempts <= 2 {
+				return nil, ie.ErrTryAgain
+			}
+			return &m.Message{Data: map[string]any{}}, nil
+		}
+	})
+	call := func(ctx context.Context, topic string) error {
+		attempts = 0
+		_, err := c.CallWithContext(ctx, "idefix", &m.Message{To: topic})
+		return err
+	}
+	c.opts.RetryPolicy = &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	// Read-only commands are retried by default
+	require.NoError(t, call(context.Background(), m.CmdDomainGet))
+	require.Equal(t, 3, attempts)
+
+	// Mutating commands are not retried unless requested
+	require.ErrorIs(t, call(context.Background(), m.CmdDomainUpdate), ie.ErrTryAgain)
+	require.Equal(t, 1, attempts)
+	require.NoError(t, call(WithRetryPolicy(context.Background(), c.opts.RetryPolicy), m.CmdDomainUpdate))
+	require.Equal(t, 3, attempts)
+
+	// Retries can be disabled per request
+	require.Error(t, call(WithRetryPolicy(context.Background(), nil), m.CmdDomainGet))
+	require.Equal(t, 1, attempts)
+
+	c.opts.RetryPolicy.MaxAttempts = 2
+	require.Error(t, call(context.Background(), m.CmdDomainGet))
+	require.Equal(t, 2, attempts)
+
+	// A closed context stops the retries
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.opts.RetryPolicy.MaxAttempts = 3
+	c.opts.RetryPolicy.MinBackoff = time.Hour
+	c.opts.RetryPolicy.MaxBackoff = time.Hour
+	require.Error(t, call(ctx, m.CmdDomainGet))
+	require.Equal(t, 1, attempts)
+}
+
+func TestDefaultRetryPolicyTimeout(t *testing.T) {
+	b := NewLoopbackBroker()
+	newLoopbackResponder(t, b)
+
+	c := newLoopbackClient(b, false)
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	// Each attempt has its own deadline, so a local timeout is retried
+	var deadlines []time.Time
+	c.Use(func(next CallFunc) CallFunc {
+		return func(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+			deadline, ok := ctx.Deadline()
+			require.True(t, ok)
+			deadlines = append(deadlines, deadline)
+			if len(deadlines) == 1 {
+				return nil, ie.ErrTimeout
+			}
+			return &m.Message{Data: map[string]any{}}, nil
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err := c.CallWithContext(ctx, "idefix", &m.Message{To: m.CmdDomainGet})
+	require.NoError(t, err)
+	require.Len(t, deadlines, 2)
+	require.WithinDuration(t, time.Now().Add(DefaultRetryPolicy.AttemptTimeout), deadlines[0], time.Second)
+}
@@ -0,0 +1,47 @@
+package idefixgo
+
+import (
+	"context"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+)
+
+// DelegateSession creates a session for the client address (and its groups) lasting the given
+// duration, and reconnects the client with it, skipping the login (see [ClientOptions.SkipLogin]).
+//
+// If the client options were read with [ReadConfig], the session is persisted with [UpdateConfig],
+// so the clients created later from the same config file reuse it instead of logging in. Once the
+// session expires, the requests fail with [ie.ErrInvalidSession]: clear SessionID and SkipLogin
+// to log in again.
+//
+// The reconnection is a full [Client.Disconnect] followed by a [Client.Connect], as the session
+// ID is part of the MQTT topics of the client. Everything bound to the previous connection is
+// torn down: the streams are lost, and the handlers, watches and pending calls end. So it must
+// be called right after connecting, before registering anything on the client.
+func (c *Client) DelegateSession(duration time.Duration, ctx ...context.Context) (session string, err error) {
+	res, err := c.SessionCreate(&m.SessionCreateMsg{
+		Address:  c.opts.Address,
+		Groups:   c.opts.Groups,
+		Duration: duration,
+	}, ctx...)
+	if err != nil {
+		return "", err
+	}
+	if res.Session == "" {
+		return "", ie.ErrInternal.With("empty session ID")
+	}
+
+	c.opts.SessionID = res.Session
+	c.opts.SkipLogin = true
+	if c.opts.vp != nil {
+		if err := UpdateConfig(c.opts); err != nil {
+			return res.Session, ie.ErrInternal.Withf("can't persist session: %v", err)
+		}
+	}
+
+	c.Disconnect()
+	c.SetSessionID(res.Session)
+	return res.Session, c.Connect()
+}
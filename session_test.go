@@ -0,0 +1,76 @@
+package idefixgo_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ifx "github.com/nayarsystems/idefix-go"
+	ie "github.com/nayarsystems/idefix-go/errors"
+	"github.com/nayarsystems/idefix-go/idefixtest"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelegateSession(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.CreateAddress("dev1", "t1", "acme")
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".idefix"), 0755))
+	data, err := json.Marshal(map[string]any{"address": "dev1", "token": "t1"})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".idefix", "sessiontest.json"), data, 0644))
+
+	opts, err := ifx.ReadConfig("sessiontest")
+	require.NoError(t, err)
+	c := s.NewClient(context.Background(), opts)
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	session, err := c.DelegateSession(time.Hour)
+	require.NoError(t, err)
+	require.NotEmpty(t, session)
+	require.Equal(t, ifx.Connected, c.Status())
+	domain, err := c.AddressDomainGet(&m.AddressDomainGetMsg{Address: "dev1"})
+	require.NoError(t, err)
+	require.Equal(t, "acme", domain.Domain)
+
+	sessions, err := c.SessionList(&m.SessionListMsg{})
+	require.NoError(t, err)
+	var found bool
+	for _, info := range sessions.Sessions {
+		if info.Session == session {
+			found = true
+			require.Equal(t, "dev1", info.Address)
+			require.WithinDuration(t, time.Now().Add(time.Hour), info.Expires, time.Minute)
+		}
+	}
+	require.True(t, found)
+
+	// The session is persisted, so new clients resume it without logging in
+	opts, err = ifx.ReadConfig("sessiontest")
+	require.NoError(t, err)
+	require.Equal(t, session, opts.SessionID)
+	require.True(t, opts.SkipLogin)
+	opts.Token = "wrong"
+	resumed := s.NewClient(context.Background(), opts)
+	require.NoError(t, resumed.Connect())
+	defer resumed.Disconnect()
+	_, err = resumed.AddressDomainGet(&m.AddressDomainGetMsg{Address: "dev1"})
+	require.NoError(t, err)
+
+	// Deleted sessions are no longer valid
+	deleted, err := resumed.SessionDelete(&m.SessionDeleteMsg{Session: session})
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted.Sessions)
+	_, err = resumed.AddressDomainGet(&m.AddressDomainGetMsg{Address: "dev1"})
+	require.ErrorIs(t, err, ie.ErrInvalidSession)
+}
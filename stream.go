@@ -0,0 +1,58 @@
+package idefixgo
+
+import (
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+)
+
+// StreamOptions defines optional settings of a [SubscriberStream] or a [PublisherStream].
+type StreamOptions struct {
+	Overflow   OverflowPolicy      // Behaviour of a subscriber stream when its buffer is full. Defaults to OverflowBlock.
+	SpillDir   string              // Directory of the spill file used by OverflowSpill. Defaults to os.TempDir().
+	SpillMax   int64               // Maximum size (in bytes) of the messages waiting in the spill file. The rest are dropped. Zero means no limit.
+	Sequenced  bool                // Wrap the messages in an envelope with a sequence number and a timestamp. Not supported by payload-only streams.
+	QoS        byte                // MQTT QoS of the stream public topic (0 to 2). Defaults to 0.
+	OnSequence func(SequenceEvent) // Optional callback of a sequenced subscriber stream, called on gaps, reordered and duplicated messages. It must not block.
+}
+
+func (o *StreamOptions) validate(payloadOnly bool) error {
+	if o.Sequenced && payloadOnly {
+		return ie.ErrInvalidParams.With("payload-only streams can't be sequenced")
+	}
+	if o.SpillMax < 0 {
+		return ie.ErrInvalidParams.With("negative spill size")
+	}
+	if o.QoS > 2 {
+		return ie.ErrInvalidParams.Withf("invalid QoS %d", o.QoS)
+	}
+	return nil
+}
+
+// StreamStats holds the counters of a [SubscriberStream].
+type StreamStats struct {
+	Received uint64 // Messages received from the device (duplicates included)
+	Dropped  uint64 // Messages discarded by the overflow policy
+	Buffered int    // Messages waiting in the channel buffer
+	Capacity int    // Capacity of the channel buffer
+	Spilled  int    // Messages waiting in the spill file
+
+	// Sequenced streams only
+	Gaps       uint64        // Times that some messages were skipped
+	Lost       uint64        // Skipped messages that never arrived
+	Reordered  uint64        // Skipped messages that arrived late
+	Duplicates uint64        // Messages received twice
+	Latency    time.Duration // Time between the publication and the reception of the last message (subject to clock drift)
+}
+
+// FillLevel returns the ratio (0 to 1) of the channel buffer in use. An unbuffered
+// channel is reported as full while there are spilled messages.
+func (st StreamStats) FillLevel() float64 {
+	if st.Capacity == 0 {
+		if st.Spilled > 0 {
+			return 1
+		}
+		return 0
+	}
+	return float64(st.Buffered) / float64(st.Capacity)
+}
@@ -0,0 +1,302 @@
+package idefixgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jaracil/ei"
+	ie "github.com/nayarsystems/idefix-go/errors"
+)
+
+const (
+	// DefaultStreamConnBuffer is the number of frames buffered by a [StreamConn] by default
+	DefaultStreamConnBuffer = 100
+	// DefaultStreamConnTimeout is the timeout of the streams of a [StreamConn] by default
+	DefaultStreamConnTimeout = time.Second * 30
+)
+
+var _ net.Conn = (*StreamConn)(nil)
+
+// StreamConnOptions defines the remote topic pair of a [StreamConn].
+type StreamConnOptions struct {
+	ReadTopic  string        // Device topic the frames are read from (e.g. "tun.evt.frame")
+	WriteTopic string        // Device topic the frames are written to (e.g. "tun.cmd.frame")
+	Buffer     uint          // Number of received frames buffered. Defaults to DefaultStreamConnBuffer.
+	Timeout    time.Duration // Timeout of the remote streams (see NewSubscriberStream). Defaults to DefaultStreamConnTimeout.
+	QoS        byte          // MQTT QoS of the remote streams
+	CloseFrame bool          // Send a {"close": true} message on Close, and read such a message as the end of the data
+}
+
+// StreamAddr is the [net.Addr] of an end of a [StreamConn]
+type StreamAddr struct {
+	Address string // Idefix address
+	Topic   string // Device topic
+}
+
+// Network returns "idefix"
+func (a StreamAddr) Network() string {
+	return "idefix"
+}
+
+func (a StreamAddr) String() string {
+	return fmt.Sprintf("%s/%s", a.Address, a.Topic)
+}
+
+// StreamConn is a [net.Conn] over a pair of payload-only remote streams: the data written
+// is published on the WriteTopic of the device, and the data read comes from its ReadTopic.
+//
+// Each Write is sent as one frame, a {"frame": <bytes>} message. A Read returns the data of
+// a single frame, so packet oriented protocols (e.g. a TUN interface) keep their boundaries
+// as long as the read buffer is large enough; otherwise the rest of the frame is returned by
+// the next Reads. With the CloseFrame option, closing the connection sends a {"close": true}
+// message, and the reception of such a message makes Read return [io.EOF]; the device must
+// be expecting it.
+//
+// The deadlines make Read and Write fail with [os.ErrDeadlineExceeded]. Write does not wait
+// for the frame to reach the device, only for the broker to accept it.
+type StreamConn struct {
+	sub   *SubscriberStream
+	pub   *PublisherStream
+	local StreamAddr
+	peer  StreamAddr
+
+	closeFrame bool
+
+	readMutex     sync.Mutex
+	pending       []byte
+	eof           bool
+	readDeadline  *connDeadline
+	writeDeadline *connDeadline
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeErr  error
+}
+
+// NewStreamConn opens the remote stream pair of a [StreamConn] on a device.
+func (c *Client) NewStreamConn(address string, opts StreamConnOptions) (*StreamConn, error) {
+	if opts.ReadTopic == "" || opts.WriteTopic == "" {
+		return nil, ie.ErrInvalidParams.With("read and write topics are required")
+	}
+	if opts.Buffer == 0 {
+		opts.Buffer = DefaultStreamConnBuffer
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultStreamConnTimeout
+	}
+	streamOpts := StreamOptions{QoS: opts.QoS}
+
+	sub, err := c.NewSubscriberStream(address, opts.ReadTopic, opts.Buffer, true, opts.Timeout, streamOpts)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := c.NewPublisherStream(address, opts.WriteTopic, opts.Buffer, true, opts.Timeout, streamOpts)
+	if err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	return &StreamConn{
+		sub:           sub,
+		pub:           pub,
+		local:         StreamAddr{Address: c.Address(), Topic: opts.ReadTopic},
+		peer:          StreamAddr{Address: address, Topic: opts.WriteTopic},
+		closeFrame:    opts.CloseFrame,
+		readDeadline:  newConnDeadline(),
+		writeDeadline: newConnDeadline(),
+		closed:        make(chan struct{}),
+	}, nil
+}
+
+// Read reads the data of the received frames.
+func (sc *StreamConn) Read(b []byte) (int, error) {
+	sc.readMutex.Lock()
+	defer sc.readMutex.Unlock()
+
+	for len(sc.pending) == 0 {
+		if sc.eof {
+			return 0, io.EOF
+		}
+		select {
+		case <-sc.closed:
+			return 0, net.ErrClosed
+		default:
+		}
+
+		select {
+		case msg := <-sc.sub.Channel():
+			if closed, _ := ei.N(msg.Data).M("close").Bool(); closed && sc.closeFrame {
+				sc.eof = true
+				continue
+			}
+			frame, err := ei.N(msg.Data).M("frame").Bytes()
+			if err != nil {
+				sc.sub.c.Logger().Debug("stream connection message without frame", "address", sc.peer.Address, "topic", msg.To)
+				continue
+			}
+			sc.pending = frame
+		case <-sc.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		case <-sc.closed:
+			return 0, net.ErrClosed
+		case <-sc.sub.Context().Done():
+			return 0, sc.lost(sc.sub.Context())
+		case <-sc.pub.Context().Done():
+			return 0, sc.lost(sc.pub.Context())
+		}
+	}
+
+	n := copy(b, sc.pending)
+	sc.pending = sc.pending[n:]
+	return n, nil
+}
+
+// Write sends b as a frame.
+func (sc *StreamConn) Write(b []byte) (int, error) {
+	select {
+	case <-sc.closed:
+		return 0, net.ErrClosed
+	case <-sc.writeDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	case <-sc.pub.Context().Done():
+		return 0, sc.lost(sc.pub.Context())
+	default:
+	}
+
+	// The publication is given up when the deadline is exceeded or the connection closed
+	expired := sc.writeDeadline.wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-expired:
+		case <-sc.closed:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	if err := sc.pub.PublishWithContext(ctx, map[string]any{"frame": b}, ""); err != nil {
+		select {
+		case <-sc.closed:
+			return 0, net.ErrClosed
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		case <-sc.pub.Context().Done():
+			return 0, sc.lost(sc.pub.Context())
+		default:
+		}
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// lost returns the error of a stream closed under the connection
+func (sc *StreamConn) lost(ctx context.Context) error {
+	select {
+	case <-sc.closed:
+		return net.ErrClosed
+	default:
+	}
+	return fmt.Errorf("stream lost: %w", context.Cause(ctx))
+}
+
+// Close closes the remote streams, telling the device first with the CloseFrame option.
+// The pending Reads and Writes are unblocked, and fail with [net.ErrClosed].
+func (sc *StreamConn) Close() error {
+	sc.closeOnce.Do(func() {
+		var errs []error
+		if sc.closeFrame && sc.pub.Context().Err() == nil {
+			if err := sc.pub.Publish(map[string]any{"close": true}, ""); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		close(sc.closed)
+		if err := sc.sub.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := sc.pub.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		sc.closeErr = errors.Join(errs...)
+	})
+	return sc.closeErr
+}
+
+// LocalAddr returns the client address along with the topic the frames are read from.
+func (sc *StreamConn) LocalAddr() net.Addr {
+	return sc.local
+}
+
+// RemoteAddr returns the device address along with the topic the frames are written to.
+func (sc *StreamConn) RemoteAddr() net.Addr {
+	return sc.peer
+}
+
+// SetDeadline sets both the read and the write deadlines. A zero value disables them.
+func (sc *StreamConn) SetDeadline(t time.Time) error {
+	sc.readDeadline.set(t)
+	sc.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline of the pending and future Reads. A zero value disables it.
+func (sc *StreamConn) SetReadDeadline(t time.Time) error {
+	sc.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of the pending and future Writes. A zero value disables it.
+func (sc *StreamConn) SetWriteDeadline(t time.Time) error {
+	sc.writeDeadline.set(t)
+	return nil
+}
+
+// connDeadline is a deadline that can be waited for, and changed while waiting
+type connDeadline struct {
+	mutex   sync.Mutex
+	timer   *time.Timer
+	expired chan struct{} // closed when the deadline is exceeded
+}
+
+func newConnDeadline() *connDeadline {
+	return &connDeadline{expired: make(chan struct{})}
+}
+
+func (d *connDeadline) set(t time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.expired // Wait for the timer function to finish
+	}
+	d.timer = nil
+
+	// A new channel is needed if the previous deadline was exceeded
+	select {
+	case <-d.expired:
+		d.expired = make(chan struct{})
+	default:
+	}
+
+	if t.IsZero() {
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		expired := d.expired
+		d.timer = time.AfterFunc(dur, func() { close(expired) })
+		return
+	}
+	close(d.expired)
+}
+
+func (d *connDeadline) wait() chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.expired
+}
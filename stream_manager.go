@@ -0,0 +1,332 @@
+package idefixgo
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+)
+
+// streamRefreshConcurrency is the number of stream refreshes sent at the same time
+const streamRefreshConcurrency = 8
+
+// StreamEventKind identifies a change in the lifecycle of a stream (see [StreamManager.OnEvent]).
+type StreamEventKind int
+
+const (
+	// StreamOpened reports a new stream
+	StreamOpened StreamEventKind = iota
+	// StreamRefreshed reports a successful keepalive of a stream
+	StreamRefreshed
+	// StreamLost reports a stream given up after an error. Its context is cancelled.
+	StreamLost
+	// StreamRestored reports a stream created again on the device, after a reconnection of the
+	// client or a reboot of the device
+	StreamRestored
+)
+
+func (k StreamEventKind) String() string {
+	switch k {
+	case StreamOpened:
+		return "opened"
+	case StreamRefreshed:
+		return "refreshed"
+	case StreamLost:
+		return "lost"
+	case StreamRestored:
+		return "restored"
+	}
+	return "unknown"
+}
+
+// StreamEvent is a lifecycle event of a [SubscriberStream] or a [PublisherStream].
+type StreamEvent struct {
+	Kind      StreamEventKind
+	Address   string   // Remote address of the stream
+	Topics    []string // Device topics of the stream
+	Publisher bool     // The stream is a PublisherStream
+	Err       error    // Cause of a StreamLost event
+}
+
+// managedStream is a remote stream kept alive by the [StreamManager]
+type managedStream interface {
+	// describe returns the remote address and the device topics of the stream
+	describe() (address string, topics []string, publisher bool)
+	// refreshInterval returns how often the remote registrations must be refreshed
+	refreshInterval() time.Duration
+	// refresh renews the remote registrations, creating again the ones unknown to the device
+	refresh() (restored bool, err error)
+	// restore creates the remote registrations again
+	restore() error
+	// lose gives up the stream
+	lose(err error)
+	// context returns the context of the stream
+	context() context.Context
+}
+
+type managedEntry struct {
+	s    managedStream
+	next time.Time
+}
+
+// StreamManager keeps alive the remote streams of a client. There is one per client (see
+// [Client.StreamManager]), and the streams register themselves in it when created.
+//
+// A single goroutine schedules the keepalives of all the streams, instead of a goroutine per
+// stream. The keepalives are not batched: the device protocol renews a single stream per
+// request, so each stream sends its own refresh request. When the client reconnects after a connection
+// loss (see [ClientOptions.Reconnect]), or when a device answers that it does not know a
+// stream (e.g. because it rebooted), the remote registration is created again.
+//
+// The streams survive the connection losses the client recovers from. They are lost (their
+// context is cancelled and a StreamLost event is emitted) on [Client.Disconnect], when the
+// connection is lost without Reconnect, or when a keepalive fails with a permanent error.
+type StreamManager struct {
+	c        *Client
+	mutex    sync.Mutex
+	streams  map[managedStream]*managedEntry
+	running  bool
+	wake     chan struct{}
+	handlers map[uint64]func(StreamEvent)
+	nextID   uint64
+}
+
+// StreamManager returns the manager of the streams of the client.
+func (c *Client) StreamManager() *StreamManager {
+	c.streamsOnce.Do(func() {
+		c.streams = &StreamManager{
+			c:        c,
+			streams:  make(map[managedStream]*managedEntry),
+			wake:     make(chan struct{}, 1),
+			handlers: make(map[uint64]func(StreamEvent)),
+		}
+		c.onReconnect(c.streams.restoreAll)
+	})
+	return c.streams
+}
+
+// OnEvent registers a function called on each lifecycle event of the streams of the client.
+// It is called from the manager goroutines, so it must not block. The returned function
+// unregisters it.
+func (sm *StreamManager) OnEvent(fn func(StreamEvent)) (remove func()) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	id := sm.nextID
+	sm.nextID++
+	sm.handlers[id] = fn
+	return func() {
+		sm.mutex.Lock()
+		defer sm.mutex.Unlock()
+		delete(sm.handlers, id)
+	}
+}
+
+// Len returns the number of open streams.
+func (sm *StreamManager) Len() int {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return len(sm.streams)
+}
+
+func (sm *StreamManager) emit(kind StreamEventKind, s managedStream, err error) {
+	ev := StreamEvent{Kind: kind, Err: err}
+	ev.Address, ev.Topics, ev.Publisher = s.describe()
+
+	sm.mutex.Lock()
+	handlers := make([]func(StreamEvent), 0, len(sm.handlers))
+	for _, fn := range sm.handlers {
+		handlers = append(handlers, fn)
+	}
+	sm.mutex.Unlock()
+
+	for _, fn := range handlers {
+		fn(ev)
+	}
+}
+
+// add starts keeping alive a stream
+func (sm *StreamManager) add(s managedStream) {
+	sm.mutex.Lock()
+	sm.streams[s] = &managedEntry{s: s, next: time.Now().Add(s.refreshInterval())}
+	if !sm.running {
+		sm.running = true
+		go sm.run()
+	}
+	sm.mutex.Unlock()
+
+	select {
+	case sm.wake <- struct{}{}:
+	default:
+	}
+	sm.emit(StreamOpened, s, nil)
+}
+
+// remove stops keeping alive a stream, returning false if it was not managed
+func (sm *StreamManager) remove(s managedStream) bool {
+	sm.mutex.Lock()
+	_, ok := sm.streams[s]
+	delete(sm.streams, s)
+	sm.mutex.Unlock()
+
+	select {
+	case sm.wake <- struct{}{}:
+	default:
+	}
+	return ok
+}
+
+// run refreshes the streams as they are due, until there are no streams left
+func (sm *StreamManager) run() {
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-sm.wake:
+			t.Stop()
+		}
+
+		sm.mutex.Lock()
+		if len(sm.streams) == 0 {
+			sm.running = false
+			sm.mutex.Unlock()
+			return
+		}
+		now := time.Now()
+		var due, closed []managedStream
+		next := now.Add(time.Hour)
+		for s, e := range sm.streams {
+			interval := s.refreshInterval()
+			if s.context().Err() != nil {
+				closed = append(closed, s)
+				continue
+			}
+			if interval <= 0 {
+				continue
+			}
+			// The streams due soon are refreshed along with the ones due now
+			if e.next.Sub(now) <= interval/4 {
+				due = append(due, s)
+				e.next = now.Add(interval)
+			}
+			if e.next.Before(next) {
+				next = e.next
+			}
+		}
+		sm.mutex.Unlock()
+
+		for _, s := range closed {
+			sm.lost(s, context.Cause(s.context()))
+		}
+		sm.forEach(due, sm.refresh)
+		t.Reset(time.Until(next))
+	}
+}
+
+// forEach runs fn for each stream, a few of them at a time, and waits for them to finish
+func (sm *StreamManager) forEach(streams []managedStream, fn func(managedStream)) {
+	workers := make(chan struct{}, streamRefreshConcurrency)
+	var wg sync.WaitGroup
+	for _, s := range streams {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+			fn(s)
+		}()
+	}
+	wg.Wait()
+}
+
+func (sm *StreamManager) refresh(s managedStream) {
+	restored, err := s.refresh()
+	switch {
+	case err == nil && restored:
+		sm.emit(StreamRestored, s, nil)
+	case err == nil:
+		sm.emit(StreamRefreshed, s, nil)
+	default:
+		sm.fail(s, "stream keepalive failed", err)
+	}
+}
+
+// restoreAll creates again the remote registrations of all the streams after a reconnection
+func (sm *StreamManager) restoreAll() {
+	sm.restore(sm.list())
+}
+
+func (sm *StreamManager) list() []managedStream {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	streams := make([]managedStream, 0, len(sm.streams))
+	for s := range sm.streams {
+		streams = append(streams, s)
+	}
+	return streams
+}
+
+func (sm *StreamManager) restore(streams []managedStream) {
+	sm.forEach(streams, func(s managedStream) {
+		if s.context().Err() != nil {
+			return
+		}
+		if err := s.restore(); err != nil {
+			sm.fail(s, "can't restore stream", err)
+			return
+		}
+		sm.emit(StreamRestored, s, nil)
+	})
+}
+
+// loseAll gives up all the streams, as the client will not restore them
+func (sm *StreamManager) loseAll(err error) {
+	sm.forEach(sm.list(), func(s managedStream) {
+		sm.lost(s, err)
+	})
+}
+
+// lost gives up a stream, deleting its remote registration
+func (sm *StreamManager) lost(s managedStream, err error) {
+	if sm.remove(s) {
+		s.lose(err)
+		sm.emit(StreamLost, s, err)
+	}
+}
+
+// fail gives up a stream, unless the error is transient
+func (sm *StreamManager) fail(s managedStream, msg string, err error) {
+	address, topics, _ := s.describe()
+	if sm.transient(err) {
+		if sm.c.Status() != Connected {
+			// Restored on the next connection
+			sm.c.Logger().Debug(msg, "address", address, "topic", topics, "error", err)
+			return
+		}
+		sm.c.Logger().Warn(msg, "address", address, "topic", topics, "error", err)
+		return
+	}
+	sm.c.Logger().Error("stream lost", "address", address, "topic", topics, "error", err)
+	sm.lost(s, err)
+}
+
+// transient reports whether a stream survives an error of its keepalive. The requests
+// fail with ErrContextClosed while a client with Reconnect enabled is disconnected, in
+// which case the stream is restored once it reconnects.
+func (sm *StreamManager) transient(err error) bool {
+	if ie.ErrTimeout.Is(err) || ie.ErrTryAgain.Is(err) {
+		return true
+	}
+	return ie.ErrContextClosed.Is(err) && sm.c.opts.Reconnect
+}
+
+// isInvalidStreamID reports whether an error means that the device does not know a stream,
+// because it expired or the device rebooted. This relies on the devices answering the
+// keepalives of the stream ids they do not know with an "invalid id" error (as the fake
+// device of idefixtest/device does); other errors, even ErrNotFound ones, are not taken
+// as such.
+func isInvalidStreamID(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "invalid id")
+}
@@ -0,0 +1,123 @@
+package idefixgo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jaracil/ei"
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamManagerReconnect(t *testing.T) {
+	b := NewLoopbackBroker()
+	r := newLoopbackResponder(t, b)
+	c := newLoopbackClient(b, true)
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	events := make(chan StreamEvent, 100)
+	defer c.StreamManager().OnEvent(func(ev StreamEvent) { events <- ev })()
+
+	s, err := c.NewSubscriberStream("dev", "sensor", 10, false, time.Minute)
+	require.NoError(t, err)
+	defer s.Close()
+	ev := <-events
+	require.Equal(t, StreamOpened, ev.Kind)
+	require.Equal(t, "dev", ev.Address)
+	require.Equal(t, []string{"sensor"}, ev.Topics)
+
+	// The stream outlives the connection losses
+	c.opts.Transport.(*LoopbackTransport).Drop(fmt.Errorf("test"))
+	require.NoError(t, s.Context().Err())
+	select {
+	case ev := <-events:
+		require.Equal(t, StreamRestored, ev.Kind)
+	case <-time.After(time.Second):
+		t.Fatal("stream not restored")
+	}
+
+	r.publishStream("hello")
+	select {
+	case msg := <-s.Channel():
+		require.Equal(t, "hello", msg.Data)
+	case <-time.After(time.Second):
+		t.Fatal("stream message not received")
+	}
+}
+
+func TestStreamManagerDisconnect(t *testing.T) {
+	b := NewLoopbackBroker()
+	newLoopbackResponder(t, b)
+	c := newLoopbackClient(b, true)
+	require.NoError(t, c.Connect())
+
+	events := make(chan StreamEvent, 100)
+	defer c.StreamManager().OnEvent(func(ev StreamEvent) { events <- ev })()
+
+	s, err := c.NewSubscriberStream("dev", "sensor", 10, false, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, StreamOpened, (<-events).Kind)
+
+	// The streams end with the client
+	c.Disconnect()
+	select {
+	case <-s.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream not ended")
+	}
+	require.ErrorIs(t, context.Cause(s.Context()), ie.ErrContextClosed)
+	select {
+	case ev := <-events:
+		require.Equal(t, StreamLost, ev.Kind)
+		require.ErrorIs(t, ev.Err, ie.ErrContextClosed)
+	case <-time.After(time.Second):
+		t.Fatal("stream not lost")
+	}
+	require.Zero(t, c.StreamManager().Len())
+}
+
+func TestStreamManagerLost(t *testing.T) {
+	b := NewLoopbackBroker()
+	newLoopbackResponder(t, b)
+	c := newLoopbackClient(b, false)
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	events := make(chan StreamEvent, 100)
+	defer c.StreamManager().OnEvent(func(ev StreamEvent) { events <- ev })()
+
+	// The keepalives are refused
+	c.Use(func(next CallFunc) CallFunc {
+		return func(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+			if msg.To == m.TopicRemoteSubscribe && ei.N(msg.Data).M("id").StringZ() != "" {
+				return nil, ie.ErrPermissionDenied
+			}
+			return next(ctx, remoteAddress, msg)
+		}
+	})
+
+	s, err := c.NewSubscriberStream("dev", "sensor", 10, false, time.Millisecond*100)
+	require.NoError(t, err)
+	require.Equal(t, StreamOpened, (<-events).Kind)
+	select {
+	case ev := <-events:
+		require.Equal(t, StreamLost, ev.Kind)
+		require.ErrorIs(t, ev.Err, ie.ErrPermissionDenied)
+	case <-time.After(time.Second):
+		t.Fatal("stream not lost")
+	}
+	<-s.Context().Done()
+	require.ErrorIs(t, context.Cause(s.Context()), ie.ErrPermissionDenied)
+	require.Zero(t, c.StreamManager().Len())
+}
+
+func TestIsInvalidStreamID(t *testing.T) {
+	require.True(t, isInvalidStreamID(ie.ErrNotFound.With("invalid id")))
+	require.True(t, isInvalidStreamID(fmt.Errorf("%s", ie.ErrInvalidParams.With("Invalid ID").Error())))
+	require.False(t, isInvalidStreamID(ie.ErrNotFound.With("topic not found")))
+	require.False(t, isInvalidStreamID(nil))
+}
@@ -0,0 +1,199 @@
+package idefixgo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// OverflowPolicy defines what a [SubscriberStream] does with the messages received
+// while its buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for the consumer to make room. Meanwhile no other message
+	// (including the responses of the requests) is received by the client.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest buffered message to make room for the new one
+	OverflowDropOldest
+	// OverflowDropNewest discards the new message
+	OverflowDropNewest
+	// OverflowSpill queues the messages in a file until the consumer makes room
+	OverflowSpill
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowSpill:
+		return "spill"
+	}
+	return "unknown"
+}
+
+// spillCompactSize is the size of the consumed part of a spill file above which it is compacted
+const spillCompactSize = 1 << 20
+
+// errSpillFull is the error of the messages that don't fit in the spill file
+var errSpillFull = errors.New("spill file full")
+
+// spillQueue is a FIFO of messages stored in a file, which is truncated each time it gets empty.
+// While it is not empty, the consumed messages are removed from the file once they take more
+// space than the pending ones.
+type spillQueue struct {
+	mutex     sync.Mutex
+	dir       string
+	maxBytes  int64 // Maximum size of the pending messages (zero means no limit)
+	compactAt int64 // Size of the consumed part of the file triggering a compaction
+	f         *os.File
+	readOff   int64
+	writeOff  int64
+	count     int
+	closed    bool
+	wake      chan struct{}
+}
+
+func newSpillQueue(dir string, maxBytes int64) *spillQueue {
+	return &spillQueue{dir: dir, maxBytes: maxBytes, compactAt: spillCompactSize, wake: make(chan struct{}, 1)}
+}
+
+func (q *spillQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.count
+}
+
+func (q *spillQueue) push(msg *m.Message) error {
+	data, err := msgpack.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return os.ErrClosed
+	}
+	if q.f == nil {
+		if q.f, err = os.CreateTemp(q.dir, "idefix-stream-*.spill"); err != nil {
+			return err
+		}
+	}
+	record := binary.AppendUvarint(nil, uint64(len(data)))
+	record = append(record, data...)
+	if q.maxBytes > 0 && q.writeOff-q.readOff+int64(len(record)) > q.maxBytes {
+		return errSpillFull
+	}
+	if _, err := q.f.WriteAt(record, q.writeOff); err != nil {
+		return err
+	}
+	q.writeOff += int64(len(record))
+	q.count++
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// peek reads the oldest message along with the offset of the next one
+func (q *spillQueue) peek() (*m.Message, int64, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	header := make([]byte, binary.MaxVarintLen64)
+	n, err := q.f.ReadAt(header, q.readOff)
+	if n == 0 {
+		return nil, 0, err
+	}
+	size, hlen := binary.Uvarint(header[:n])
+	data := make([]byte, size)
+	if _, err := q.f.ReadAt(data, q.readOff+int64(hlen)); err != nil {
+		return nil, 0, err
+	}
+	msg := &m.Message{}
+	if err := msgpack.Unmarshal(data, msg); err != nil {
+		return nil, 0, err
+	}
+	return msg, q.readOff + int64(hlen) + int64(size), nil
+}
+
+// pop discards the oldest message
+func (q *spillQueue) pop(next int64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.readOff = next
+	q.count--
+	if q.count == 0 {
+		q.readOff, q.writeOff = 0, 0
+		q.f.Truncate(0)
+		return
+	}
+	if q.readOff >= q.compactAt && q.readOff >= q.writeOff-q.readOff {
+		if err := q.compact(); err != nil {
+			// The file keeps growing until it gets empty
+			q.compactAt *= 2
+		}
+	}
+}
+
+// compact moves the pending messages to the beginning of the file. The consumed part is at
+// least as large as the pending one, so they don't overlap.
+func (q *spillQueue) compact() error {
+	pending := q.writeOff - q.readOff
+	src := io.NewSectionReader(q.f, q.readOff, pending)
+	if _, err := io.Copy(io.NewOffsetWriter(q.f, 0), src); err != nil {
+		return err
+	}
+	if err := q.f.Truncate(pending); err != nil {
+		return err
+	}
+	q.readOff, q.writeOff = 0, pending
+	return nil
+}
+
+// pump moves the spilled messages to the channel as the consumer makes room
+func (q *spillQueue) pump(ctx context.Context, ch chan<- *m.Message, onError func(error)) {
+	defer q.close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		}
+		for q.len() > 0 {
+			msg, next, err := q.peek()
+			if err != nil {
+				onError(err)
+				return
+			}
+			select {
+			case ch <- msg:
+				q.pop(next)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (q *spillQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.f != nil {
+		q.f.Close()
+		os.Remove(q.f.Name())
+		q.f = nil
+	}
+	q.count = 0
+	q.closed = true
+}
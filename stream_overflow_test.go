@@ -0,0 +1,142 @@
+package idefixgo
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func newOverflowStream(t *testing.T, opts StreamOptions) (*loopbackResponder, *SubscriberStream) {
+	b := NewLoopbackBroker()
+	r := newLoopbackResponder(t, b)
+	c := newLoopbackClient(b, false)
+	require.NoError(t, c.Connect())
+	t.Cleanup(c.Disconnect)
+
+	s, err := c.NewSubscriberStream("dev", "sensor", 2, false, time.Minute, opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return r, s
+}
+
+func publishOverflow(t *testing.T, r *loopbackResponder, s *SubscriberStream, n int) {
+	for i := range n {
+		r.publishStream(fmt.Sprint(i))
+	}
+	require.Eventually(t, func() bool { return s.Stats().Received == uint64(n) }, time.Second, time.Millisecond*10)
+}
+
+func readOverflow(t *testing.T, s *SubscriberStream, n int) []any {
+	var res []any
+	for range n {
+		select {
+		case msg := <-s.Channel():
+			res = append(res, msg.Data)
+		case <-time.After(time.Second):
+			t.Fatal("stream message not received")
+		}
+	}
+	return res
+}
+
+func TestStreamOverflowDropNewest(t *testing.T) {
+	r, s := newOverflowStream(t, StreamOptions{Overflow: OverflowDropNewest})
+	publishOverflow(t, r, s, 5)
+
+	st := s.Stats()
+	require.EqualValues(t, 3, st.Dropped)
+	require.Equal(t, 2, st.Buffered)
+	require.Equal(t, 1.0, st.FillLevel())
+	require.Equal(t, []any{"0", "1"}, readOverflow(t, s, 2))
+	require.Equal(t, 0.0, s.Stats().FillLevel())
+}
+
+func TestStreamOverflowDropOldest(t *testing.T) {
+	r, s := newOverflowStream(t, StreamOptions{Overflow: OverflowDropOldest})
+	publishOverflow(t, r, s, 5)
+
+	require.EqualValues(t, 3, s.Stats().Dropped)
+	require.Equal(t, []any{"3", "4"}, readOverflow(t, s, 2))
+}
+
+func TestStreamOverflowSpill(t *testing.T) {
+	dir := t.TempDir()
+	r, s := newOverflowStream(t, StreamOptions{Overflow: OverflowSpill, SpillDir: dir})
+	publishOverflow(t, r, s, 5)
+
+	st := s.Stats()
+	require.Zero(t, st.Dropped)
+	require.Equal(t, 3, st.Spilled)
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	require.Equal(t, []any{"0", "1", "2", "3", "4"}, readOverflow(t, s, 5))
+	require.Zero(t, s.Stats().Spilled)
+
+	// Messages are delivered straight away again once the spill file is drained
+	r.publishStream("hello")
+	require.Equal(t, []any{"hello"}, readOverflow(t, s, 1))
+
+	s.Close()
+	require.Eventually(t, func() bool {
+		files, err := os.ReadDir(dir)
+		return err == nil && len(files) == 0
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestStreamOverflowBlock(t *testing.T) {
+	r, s := newOverflowStream(t, StreamOptions{})
+	publishOverflow(t, r, s, 2)
+	r.publishStream("hello")
+
+	// The third message waits for the consumer instead of being dropped
+	require.Len(t, readOverflow(t, s, 3), 3)
+	require.Zero(t, s.Stats().Dropped)
+}
+
+func TestSpillQueueCompaction(t *testing.T) {
+	q := newSpillQueue(t.TempDir(), 0)
+	defer q.close()
+	q.compactAt = 64
+
+	// The file does not grow while the queue is kept non empty
+	var size int64
+	for i := range 100 {
+		require.NoError(t, q.push(&m.Message{To: "sensor", Data: fmt.Sprint(i)}))
+		if i < 2 {
+			continue
+		}
+		msg, next, err := q.peek()
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprint(i-2), msg.Data)
+		q.pop(next)
+		require.Equal(t, 2, q.len())
+		fi, err := q.f.Stat()
+		require.NoError(t, err)
+		size = max(size, fi.Size())
+	}
+	require.Less(t, size, int64(200))
+
+	for _, expected := range []string{"98", "99"} {
+		msg, next, err := q.peek()
+		require.NoError(t, err)
+		require.Equal(t, expected, msg.Data)
+		q.pop(next)
+	}
+}
+
+func TestStreamOverflowSpillMax(t *testing.T) {
+	r, s := newOverflowStream(t, StreamOptions{Overflow: OverflowSpill, SpillDir: t.TempDir(), SpillMax: 100})
+	publishOverflow(t, r, s, 20)
+
+	// The messages that don't fit in the spill file are dropped
+	st := s.Stats()
+	require.Positive(t, st.Dropped)
+	require.Positive(t, st.Spilled)
+	require.EqualValues(t, 20, st.Dropped+uint64(st.Spilled)+uint64(st.Buffered))
+}
@@ -3,9 +3,10 @@ package idefixgo
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	ie "github.com/nayarsystems/idefix-go/errors"
 	"github.com/nayarsystems/idefix-go/messages"
 	m "github.com/nayarsystems/idefix-go/messages"
 	"github.com/vmihailenco/msgpack/v5"
@@ -21,9 +22,12 @@ type PublisherStream struct {
 	c           *Client
 	topic       string
 	address     string
+	mutex       sync.Mutex
 	pubId       string
 	payloadOnly bool
 	publicTopic string
+	opts        StreamOptions
+	seq         atomic.Uint64
 }
 
 // NewPublisherStream creates a new PublisherStream instance for publishing messages
@@ -33,8 +37,12 @@ type PublisherStream struct {
 //
 // This function connects to the specified address, sets up the necessary context,
 // and sends a request to start publishing on the specified topic. It also manages
-// the lifetime of the PublisherStream through context cancellation
-func (c *Client) NewPublisherStream(address string, topic string, capacity uint, payloadOnly bool, timeout time.Duration) (*PublisherStream, error) {
+// the lifetime of the PublisherStream through context cancellation. The stream is kept
+// alive by the [StreamManager] of the client, and lasts until it is closed or lost. It
+// survives the connection losses the client recovers from (see [ClientOptions.Reconnect]).
+//
+// Only the Sequenced and QoS fields of the optional [StreamOptions] apply to publisher streams.
+func (c *Client) NewPublisherStream(address string, topic string, capacity uint, payloadOnly bool, timeout time.Duration, opts ...StreamOptions) (*PublisherStream, error) {
 	s := &PublisherStream{
 		address:     address,
 		timeout:     timeout,
@@ -42,74 +50,136 @@ func (c *Client) NewPublisherStream(address string, topic string, capacity uint,
 		c:           c,
 		payloadOnly: payloadOnly,
 	}
+	if len(opts) > 0 {
+		s.opts = opts[0]
+	}
+	if err := s.opts.validate(payloadOnly); err != nil {
+		return nil, err
+	}
+
+	s.ctx, s.cancel = context.WithCancelCause(c.pctx)
+
+	if err := s.register(); err != nil {
+		s.cancel(err)
+		return nil, err
+	}
 
-	s.ctx, s.cancel = context.WithCancelCause(c.ctx)
+	c.StreamManager().add(s)
+	return s, nil
+}
 
+// register asks the remote device to start a publisher for the stream topic.
+func (s *PublisherStream) register() error {
 	res := m.StreamCreateSubResMsg{}
-	err := s.c.Call2(address, &m.Message{To: m.TopicRemoteStartPublisher, Data: m.StreamCreateMsg{
-		TargetTopic: topic,
-		Timeout:     timeout,
+	err := s.c.Call2(s.address, &m.Message{To: m.TopicRemoteStartPublisher, Data: m.StreamCreateMsg{
+		TargetTopic: s.topic,
+		Timeout:     s.timeout,
 		PayloadOnly: s.payloadOnly,
+		Sequenced:   s.opts.Sequenced,
+		QoS:         s.opts.QoS,
 	}}, &res, time.Second*5)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seq.Store(0)
 	s.pubId = res.Id
-	go s.keepalive()
-
 	s.publicTopic = fmt.Sprintf("%s/%s", m.MqttPublicPrefix, res.PublicTopic)
+	return nil
+}
 
-	return s, nil
+// restore re-issues the remote publisher after the client recovers from
+// a connection loss.
+func (s *PublisherStream) restore() error {
+	return s.register()
+}
+
+func (s *PublisherStream) id() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.pubId
 }
 
 // Publish sends a message to a specific subtopic of the PublisherStream's main topic.
 //
 // The method determines whether to publish only the payload or to wrap it in a
 // StreamMsg structure based on the payloadOnly flag. If payloadOnly is true,
-// the message is sent directly; otherwise, it is encapsulated within a StreamMsg.
+// the message is sent directly; otherwise, it is encapsulated within a StreamMsg,
+// which carries a sequence number and a timestamp if the stream is sequenced.
 func (s *PublisherStream) Publish(msg any, subtopic string) error {
+	return s.PublishWithContext(context.Background(), msg, subtopic)
+}
+
+// PublishWithContext is like [PublisherStream.Publish], but gives up when ctx is done.
+func (s *PublisherStream) PublishWithContext(ctx context.Context, msg any, subtopic string) error {
 	targetTopic := fmt.Sprintf("%s.%s", s.topic, subtopic)
 
+	s.mutex.Lock()
+	publicTopic := s.publicTopic
 	var payload any
 	if s.payloadOnly {
 		payload = msg
 	} else {
-		payload = messages.StreamMsg{
+		streamMsg := messages.StreamMsg{
 			SourceTopic: targetTopic,
 			Payload:     msg,
 		}
+		if s.opts.Sequenced {
+			streamMsg.Seq = s.seq.Add(1)
+			streamMsg.Time = time.Now().UnixMilli()
+		}
+		payload = streamMsg
 	}
+	s.mutex.Unlock()
+
 	mqttPayload, err := msgpack.Marshal(payload)
 	if err != nil {
 		return err
 	}
-	token := s.c.client.Publish(s.publicTopic, 0, false, mqttPayload)
-	token.Wait()
-	return token.Error()
 
+	pubCtx, pubCancel := context.WithCancel(ctx)
+	defer pubCancel()
+	stop := context.AfterFunc(s.ctx, pubCancel)
+	defer stop()
+	return s.c.transport.Publish(pubCtx, publicTopic, s.opts.QoS, mqttPayload)
+}
+
+func (s *PublisherStream) describe() (string, []string, bool) {
+	return s.address, []string{s.topic}, true
 }
 
-func (s *PublisherStream) keepalive() {
-	t := time.NewTicker(s.timeout / 4)
-	defer s.Close()
-
-	for {
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-t.C:
-			err := s.c.Call2(s.address, &m.Message{To: m.TopicRemoteStartPublisher, Data: m.StreamCreateMsg{
-				Id:          s.pubId,
-				Timeout:     s.timeout,
-				PayloadOnly: s.payloadOnly,
-			}}, nil, time.Second*5)
-			if err != nil && !ie.ErrTimeout.Is(err) {
-				s.cancel(err)
-				return
-			}
+func (s *PublisherStream) refreshInterval() time.Duration {
+	return s.timeout / 4
+}
+
+func (s *PublisherStream) context() context.Context {
+	return s.ctx
+}
+
+func (s *PublisherStream) lose(err error) {
+	s.cancel(err)
+	s.Close()
+}
+
+// refresh renews the remote publisher, starting it again if the device does not
+// know it (e.g. because it rebooted)
+func (s *PublisherStream) refresh() (restored bool, err error) {
+	err = s.c.Call2(s.address, &m.Message{To: m.TopicRemoteStartPublisher, Data: m.StreamCreateMsg{
+		Id:          s.id(),
+		Timeout:     s.timeout,
+		PayloadOnly: s.payloadOnly,
+		Sequenced:   s.opts.Sequenced,
+		QoS:         s.opts.QoS,
+	}}, nil, time.Second*5)
+	if isInvalidStreamID(err) {
+		s.c.Logger().Info("stream unknown to the device, publishing again", "address", s.address, "stream_id", s.id(), "topic", s.topic)
+		if err = s.register(); err == nil {
+			return true, nil
 		}
 	}
+	return false, err
 }
 
 // Context returns the context associated with the PublisherStream.
@@ -128,8 +198,9 @@ func (s *PublisherStream) Context() context.Context {
 // response, timing out after five seconds if no response is received.
 func (s *PublisherStream) Close() error {
 	defer s.cancel(fmt.Errorf("closed by user"))
+	s.c.StreamManager().remove(s)
 	_, err := s.c.Call(s.address, &m.Message{To: m.TopicRemoteStopPublisher, Data: m.StreamDeleteMsg{
-		Id: s.pubId,
+		Id: s.id(),
 	}}, time.Second*5)
 	if err != nil {
 		return err
@@ -0,0 +1,133 @@
+package idefixgo
+
+import (
+	"sync"
+	"time"
+)
+
+// seqWindow is the number of sequence numbers behind the last received one that are
+// remembered to tell a late message from a duplicate
+const seqWindow = 1024
+
+// SequenceEventKind identifies the anomaly reported by a [SequenceEvent].
+type SequenceEventKind int
+
+const (
+	// SequenceGap reports that some messages were skipped
+	SequenceGap SequenceEventKind = iota
+	// SequenceReorder reports a skipped message that arrived late. It is delivered out of order.
+	SequenceReorder
+	// SequenceDuplicate reports a message received twice. It is not delivered again.
+	SequenceDuplicate
+)
+
+func (k SequenceEventKind) String() string {
+	switch k {
+	case SequenceGap:
+		return "gap"
+	case SequenceReorder:
+		return "reorder"
+	case SequenceDuplicate:
+		return "duplicate"
+	}
+	return "unknown"
+}
+
+// SequenceEvent describes an anomaly detected in the sequence numbers of a sequenced stream
+// (see [StreamOptions.Sequenced]).
+type SequenceEvent struct {
+	Kind     SequenceEventKind
+	Seq      uint64    // Sequence number of the received message
+	Expected uint64    // Sequence number that was expected
+	Missing  uint64    // Number of skipped messages (SequenceGap only)
+	Time     time.Time // Publication time of the received message
+}
+
+// seqTracker checks the sequence numbers of the messages of a stream, each remote
+// subscription of the stream having its own sequence
+type seqTracker struct {
+	mutex      sync.Mutex
+	seqs       map[string]*seqState
+	gaps       uint64
+	lost       uint64
+	reordered  uint64
+	duplicates uint64
+	latency    time.Duration
+}
+
+// seqState is the state of a sequence
+type seqState struct {
+	next    uint64              // Expected sequence number (zero until the first message)
+	missing map[uint64]struct{} // Skipped sequence numbers within the window
+}
+
+func newSeqTracker() *seqTracker {
+	return &seqTracker{seqs: make(map[string]*seqState)}
+}
+
+// reset restarts a sequence, as the publisher numbers the messages of a new stream from 1.
+// The counters are kept.
+func (t *seqTracker) reset(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.seqs, key)
+}
+
+// track registers a received message of a sequence, returning the anomaly it reveals
+// (if any) and whether it has to be delivered
+func (t *seqTracker) track(key string, seq uint64, ts time.Time) (*SequenceEvent, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !ts.IsZero() {
+		t.latency = time.Since(ts)
+	}
+	st := t.seqs[key]
+	if st == nil {
+		st = &seqState{missing: make(map[uint64]struct{})}
+		t.seqs[key] = st
+	}
+
+	switch {
+	case st.next == 0 || seq == st.next:
+		st.next = seq + 1
+		return nil, true
+
+	case seq > st.next:
+		ev := &SequenceEvent{Kind: SequenceGap, Seq: seq, Expected: st.next, Missing: seq - st.next, Time: ts}
+		t.gaps++
+		t.lost += ev.Missing
+		for n := max(st.next, seq-min(seq, seqWindow)); n < seq; n++ {
+			st.missing[n] = struct{}{}
+		}
+		st.next = seq + 1
+		for n := range st.missing {
+			if n+seqWindow < st.next {
+				delete(st.missing, n)
+			}
+		}
+		return ev, true
+	}
+
+	ev := &SequenceEvent{Seq: seq, Expected: st.next, Time: ts}
+	if _, ok := st.missing[seq]; ok {
+		delete(st.missing, seq)
+		t.lost--
+		t.reordered++
+		ev.Kind = SequenceReorder
+		return ev, true
+	}
+	t.duplicates++
+	ev.Kind = SequenceDuplicate
+	return ev, false
+}
+
+// stats fills the sequence counters of the stream stats
+func (t *seqTracker) stats(st *StreamStats) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	st.Gaps = t.gaps
+	st.Lost = t.lost
+	st.Reordered = t.reordered
+	st.Duplicates = t.duplicates
+	st.Latency = t.latency
+}
@@ -0,0 +1,99 @@
+package idefixgo
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeqTracker(t *testing.T) {
+	tr := newSeqTracker()
+	track := func(seq uint64) (SequenceEventKind, bool, bool) {
+		ev, ok := tr.track("sensor", seq, time.Time{})
+		if ev == nil {
+			return 0, false, ok
+		}
+		return ev.Kind, true, ok
+	}
+
+	// The first message sets the start of the sequence
+	_, ev, ok := track(5)
+	require.False(t, ev)
+	require.True(t, ok)
+
+	kind, ev, ok := track(8)
+	require.True(t, ev)
+	require.True(t, ok)
+	require.Equal(t, SequenceGap, kind)
+
+	kind, _, ok = track(6)
+	require.True(t, ok)
+	require.Equal(t, SequenceReorder, kind)
+
+	kind, _, ok = track(6)
+	require.False(t, ok)
+	require.Equal(t, SequenceDuplicate, kind)
+
+	_, ev, _ = track(9)
+	require.False(t, ev)
+
+	var st StreamStats
+	tr.stats(&st)
+	require.EqualValues(t, 1, st.Gaps)
+	require.EqualValues(t, 1, st.Lost)
+	require.EqualValues(t, 1, st.Reordered)
+	require.EqualValues(t, 1, st.Duplicates)
+
+	// A new stream starts a new sequence
+	tr.reset("sensor")
+	_, ev, ok = track(1)
+	require.False(t, ev)
+	require.True(t, ok)
+}
+
+func TestSequencedStream(t *testing.T) {
+	b := NewLoopbackBroker()
+	r := newLoopbackResponder(t, b)
+	c := newLoopbackClient(b, false)
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	var mutex sync.Mutex
+	var events []SequenceEvent
+	s, err := c.NewSubscriberStream("dev", "sensor", 10, false, time.Minute, StreamOptions{
+		Sequenced: true,
+		OnSequence: func(ev SequenceEvent) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			events = append(events, ev)
+		},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Now().UnixMilli()
+	for _, seq := range []uint64{1, 2, 4, 2, 3, 5} {
+		r.publishStreamMsg(m.StreamMsg{SourceTopic: "sensor.value", Payload: int64(seq), Seq: seq, Time: now})
+	}
+	require.Eventually(t, func() bool { return s.Stats().Received == 6 }, time.Second, time.Millisecond*10)
+
+	// The duplicate is not delivered
+	require.Len(t, s.Channel(), 5)
+	st := s.Stats()
+	require.EqualValues(t, 1, st.Gaps)
+	require.EqualValues(t, 0, st.Lost)
+	require.EqualValues(t, 1, st.Reordered)
+	require.EqualValues(t, 1, st.Duplicates)
+	require.Positive(t, st.Latency)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Len(t, events, 3)
+	require.Equal(t, SequenceEvent{Kind: SequenceGap, Seq: 4, Expected: 3, Missing: 1, Time: time.UnixMilli(now)}, events[0])
+	require.Equal(t, SequenceDuplicate, events[1].Kind)
+	require.Equal(t, SequenceReorder, events[2].Kind)
+	require.EqualValues(t, 3, events[2].Seq)
+}
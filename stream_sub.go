@@ -2,11 +2,14 @@ package idefixgo
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/jaracil/ei"
 	ie "github.com/nayarsystems/idefix-go/errors"
 	m "github.com/nayarsystems/idefix-go/messages"
@@ -14,16 +17,45 @@ import (
 )
 
 // SubscriberStream manages the state and operations for a subscription to a message stream.
+//
+// A stream may subscribe to several device topics (see [Client.NewMultiSubscriberStream]).
+// The topics sharing their first level (e.g. "sensor.temp" and "sensor.hum") are covered by
+// a single remote subscription, and the messages of the device topics not requested are
+// discarded by the stream. Unrelated topics get a remote subscription each, so that the
+// device does not send the messages of the whole bus.
 type SubscriberStream struct {
 	ctx         context.Context
 	cancel      context.CancelCauseFunc
 	timeout     time.Duration
 	c           *Client
 	buffer      chan *m.Message
-	topic       string
 	address     string
-	subId       string
+	mutex       sync.Mutex
+	topics      []string     // Topics and patterns requested by the user
+	subs        []*remoteSub // Remote subscriptions covering the topics
+	topicsMutex sync.Mutex   // Serializes the changes of the topic set
 	payloadOnly bool
+	opts        StreamOptions
+	spill       *spillQueue
+	seq         *seqTracker
+	received    atomic.Uint64
+	dropped     atomic.Uint64
+}
+
+// remoteSub is the remote subscription of a [SubscriberStream]
+type remoteSub struct {
+	target    string // Topic subscribed on the device
+	subtopics bool   // The subtopics of target are included
+	id        string
+	pubTopic  string
+}
+
+// key identifies the sequence of the remote subscription
+func (sub *remoteSub) key() string {
+	if sub.subtopics {
+		return sub.target + ".*"
+	}
+	return sub.target
 }
 
 // NewSubscriberStream creates a new SubscriberStream for the specified topic.
@@ -32,96 +64,521 @@ type SubscriberStream struct {
 // The SubscriberStream allows the client to receive messages published to the specified topic.
 // It can handle both payload-only messages and full message structures based on the
 // value of the payloadOnly parameter.
-func (c *Client) NewSubscriberStream(address string, topic string, capacity uint, payloadOnly bool, timeout time.Duration) (*SubscriberStream, error) {
+//
+// The messages received while the channel buffer is full are handled according to the
+// overflow policy of the optional [StreamOptions] (see [OverflowPolicy]). By default the
+// stream waits for the consumer, holding the rest of the messages received by the client.
+// Sequenced streams detect the lost, reordered and duplicated messages (see [SubscriberStream.Stats]).
+func (c *Client) NewSubscriberStream(address string, topic string, capacity uint, payloadOnly bool, timeout time.Duration, opts ...StreamOptions) (*SubscriberStream, error) {
+	return c.NewMultiSubscriberStream(address, []string{topic}, capacity, payloadOnly, timeout, opts...)
+}
+
+// NewMultiSubscriberStream creates a SubscriberStream receiving the messages of a set of device
+// topics through a single channel (see [Client.NewSubscriberStream]). Topics can be added and
+// removed later on with [SubscriberStream.AddTopics] and [SubscriberStream.RemoveTopics].
+//
+// A topic ending in ".*" is a prefix pattern: "sensor.*" receives the messages of "sensor"
+// and all its subtopics (e.g. "sensor.temp" or "sensor.temp.max"), as the device bus does
+// with hierarchical topics. A lone "*" receives all the device messages.
+//
+// The stream is kept alive by the [StreamManager] of the client, and lasts until it is closed
+// or lost. It survives the connection losses the client recovers from (see [ClientOptions.Reconnect]).
+func (c *Client) NewMultiSubscriberStream(address string, topics []string, capacity uint, payloadOnly bool, timeout time.Duration, opts ...StreamOptions) (*SubscriberStream, error) {
 	s := &SubscriberStream{
 		address:     address,
 		timeout:     timeout,
-		topic:       topic,
 		c:           c,
 		buffer:      make(chan *m.Message, capacity),
 		payloadOnly: payloadOnly,
 	}
+	if len(opts) > 0 {
+		s.opts = opts[0]
+	}
+	if err := s.opts.validate(payloadOnly); err != nil {
+		return nil, err
+	}
+	if s.opts.Sequenced {
+		s.seq = newSeqTracker()
+	}
+
+	// The stream outlives the connection losses the client recovers from (see StreamManager)
+	s.ctx, s.cancel = context.WithCancelCause(c.pctx)
+	if s.opts.Overflow == OverflowSpill {
+		s.spill = newSpillQueue(s.opts.SpillDir, s.opts.SpillMax)
+		go s.spill.pump(s.ctx, s.buffer, func(err error) {
+			s.c.Logger().Error("can't read stream spill file", "address", s.address, "error", err)
+			s.cancel(err)
+			s.c.StreamManager().lost(s, err)
+		})
+	}
+
+	if err := s.AddTopics(topics...); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	c.StreamManager().add(s)
+	return s, nil
+}
+
+// parseTopicPattern returns the device topic of a topic pattern and whether its subtopics are included
+func parseTopicPattern(pattern string) (target string, subtopics bool) {
+	if pattern == "*" {
+		return "", true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, ".*"); ok {
+		return prefix, true
+	}
+	return pattern, false
+}
+
+// coveringTopics returns the device subscriptions receiving the messages of a set of topic
+// patterns. The patterns are grouped by their first level, each group being covered by a
+// subscription (see coveringTopic). A lone "*" covers all of them.
+func coveringTopics(patterns []string) []*remoteSub {
+	if slices.Contains(patterns, "*") {
+		return []*remoteSub{{target: "", subtopics: true}}
+	}
+	var roots []string
+	groups := map[string][]string{}
+	for _, pattern := range patterns {
+		topic, _ := parseTopicPattern(pattern)
+		root, _, _ := strings.Cut(topic, ".")
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], pattern)
+	}
+	subs := make([]*remoteSub, 0, len(roots))
+	for _, root := range roots {
+		target, subtopics := coveringTopic(groups[root])
+		subs = append(subs, &remoteSub{target: target, subtopics: subtopics})
+	}
+	return subs
+}
+
+// coveringTopic returns the device subscription receiving the messages of a set of topic
+// patterns: a single topic is subscribed as it is, and several ones through the subtopics of
+// their longest common prefix (e.g. "sensor.temp" and "sensor.hum.*" through "sensor.*").
+func coveringTopic(patterns []string) (target string, subtopics bool) {
+	if len(patterns) == 1 {
+		return parseTopicPattern(patterns[0])
+	}
+	var prefix []string
+	for i, pattern := range patterns {
+		topic, _ := parseTopicPattern(pattern)
+		var parts []string
+		if topic != "" {
+			parts = strings.Split(topic, ".")
+		}
+		if i == 0 {
+			prefix = parts
+			continue
+		}
+		n := 0
+		for n < len(prefix) && n < len(parts) && prefix[n] == parts[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return strings.Join(prefix, "."), true
+}
+
+// matchTopic reports whether a device topic is one of the topic patterns
+func matchTopic(patterns []string, topic string) bool {
+	for _, pattern := range patterns {
+		target, subtopics := parseTopicPattern(pattern)
+		if topic == target || (subtopics && (target == "" || strings.HasPrefix(topic, target+"."))) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddTopics subscribes the stream to more device topics or patterns. The topics already
+// subscribed are skipped.
+//
+// If the remote subscriptions of the stream do not cover the new topics, they are replaced
+// or new ones are added (see [SubscriberStream]). On error the topics of the stream are left
+// unchanged.
+func (s *SubscriberStream) AddTopics(topics ...string) error {
+	s.topicsMutex.Lock()
+	defer s.topicsMutex.Unlock()
+	if s.ctx.Err() != nil {
+		return ie.ErrContextClosed.With("stream closed")
+	}
+	newTopics := s.Topics()
+	for _, pattern := range topics {
+		if !slices.Contains(newTopics, pattern) {
+			newTopics = append(newTopics, pattern)
+		}
+	}
+	return s.setTopics(newTopics)
+}
+
+// RemoveTopics unsubscribes the stream from some of its device topics or patterns.
+// The stream keeps running even if it has no topics left.
+func (s *SubscriberStream) RemoveTopics(topics ...string) error {
+	s.topicsMutex.Lock()
+	defer s.topicsMutex.Unlock()
+	var errs []error
+	newTopics := s.Topics()
+	for _, pattern := range topics {
+		i := slices.Index(newTopics, pattern)
+		if i < 0 {
+			errs = append(errs, ie.ErrNotFound.Withf("topic %s not subscribed", pattern))
+			continue
+		}
+		newTopics = slices.Delete(newTopics, i, i+1)
+	}
+	if err := s.setTopics(newTopics); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// setTopics changes the topic set of the stream, replacing the remote subscriptions which do
+// not match the new set. It must be called with topicsMutex held.
+func (s *SubscriberStream) setTopics(topics []string) error {
+	old := s.remoteSubs()
+
+	var subs, added []*remoteSub
+	var sticky []any
+	for _, want := range coveringTopics(topics) {
+		i := slices.IndexFunc(old, func(sub *remoteSub) bool {
+			return sub.target == want.target && sub.subtopics == want.subtopics
+		})
+		if i >= 0 {
+			subs = append(subs, old[i])
+			continue
+		}
+		res, err := s.register(want)
+		if err != nil {
+			for _, sub := range added {
+				s.unregister(sub)
+			}
+			return err
+		}
+		subs = append(subs, want)
+		added = append(added, want)
+		sticky = append(sticky, res.StickyPayload)
+	}
+
+	s.mutex.Lock()
+	s.topics = topics
+	s.subs = subs
+	s.mutex.Unlock()
+
+	for _, sub := range old {
+		if slices.Contains(subs, sub) {
+			continue
+		}
+		if err := s.unregister(sub); err != nil {
+			s.c.Logger().Warn("can't delete replaced stream", "address", s.address, "stream_id", s.subID(sub), "error", err)
+		}
+	}
+	for i, sub := range added {
+		if sticky[i] != nil && sub.target != "" && matchTopic(topics, sub.target) {
+			s.deliver(&m.Message{To: sub.target, Data: sticky[i]})
+		}
+	}
+	return nil
+}
 
-	s.ctx, s.cancel = context.WithCancelCause(c.ctx)
+// Topics returns the device topics and patterns the stream is subscribed to.
+func (s *SubscriberStream) Topics() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.topics)
+}
 
-	res := m.StreamCreateSubResMsg{}
-	err := s.c.Call2(address, &m.Message{To: m.TopicRemoteSubscribe, Data: m.StreamCreateMsg{
-		TargetTopic: topic,
-		Timeout:     timeout,
-		PayloadOnly: s.payloadOnly,
-	}}, &res, time.Second*5)
+// register creates the remote subscription on the device and subscribes
+// to the public topic where the device will publish the messages.
+func (s *SubscriberStream) register(sub *remoteSub) (*m.StreamCreateSubResMsg, error) {
+	res := &m.StreamCreateSubResMsg{}
+	err := s.c.Call2(s.address, &m.Message{To: m.TopicRemoteSubscribe, Data: m.StreamCreateMsg{
+		TargetTopic:    sub.target,
+		Timeout:        s.timeout,
+		PayloadOnly:    s.wirePayloadOnly(sub),
+		AllowSubtopics: sub.subtopics,
+		Sequenced:      s.opts.Sequenced,
+		QoS:            s.opts.QoS,
+	}}, res, time.Second*5)
 	if err != nil {
 		return nil, err
 	}
 
-	if res.StickyPayload != nil {
-		s.buffer <- &m.Message{To: s.topic, Data: res.StickyPayload}
+	// A new remote stream numbers its messages from the start
+	if s.seq != nil {
+		s.seq.reset(sub.key())
+	}
+	pubTopic := fmt.Sprintf("%s/%s", m.MqttPublicPrefix, res.PublicTopic)
+	err = s.c.transport.Subscribe(pubTopic, s.opts.QoS, func(topic string, payload []byte) {
+		s.receiveMessage(sub, topic, payload)
+	})
+	if err != nil {
+		return nil, ie.ErrInternal.With(err.Error())
 	}
 
-	pubtopic := fmt.Sprintf("%s/%s", m.MqttPublicPrefix, res.PublicTopic)
+	s.mutex.Lock()
+	oldPubTopic := sub.pubTopic
+	sub.id = res.Id
+	sub.pubTopic = pubTopic
+	s.mutex.Unlock()
 
-	c.client.Subscribe(pubtopic, 0, s.receiveMessage)
+	if oldPubTopic != "" && oldPubTopic != pubTopic {
+		s.c.transport.Unsubscribe(oldPubTopic)
+	}
+	return res, nil
+}
 
-	s.subId = res.Id
-	go s.keepalive()
+// unregister deletes the remote subscription from the device
+func (s *SubscriberStream) unregister(sub *remoteSub) error {
+	s.mutex.Lock()
+	id, pubTopic := sub.id, sub.pubTopic
+	s.mutex.Unlock()
 
-	return s, nil
+	if s.seq != nil {
+		s.seq.reset(sub.key())
+	}
+	s.c.transport.Unsubscribe(pubTopic)
+	_, err := s.c.Call(s.address, &m.Message{To: m.TopicRemoteUnsubscribe, Data: m.StreamDeleteMsg{
+		Id: id,
+	}}, time.Second*5)
+	return err
 }
 
-func (s *SubscriberStream) handleMsg(msg any) {
-	if s.payloadOnly {
-		s.buffer <- &m.Message{To: s.topic, Data: msg}
+// wirePayloadOnly reports whether the device sends the bare payloads. The payload-only
+// streams receiving subtopics get the whole messages, as the source topic is needed to
+// filter and to deliver them.
+func (s *SubscriberStream) wirePayloadOnly(sub *remoteSub) bool {
+	return s.payloadOnly && !sub.subtopics
+}
+
+// restore re-issues the remote subscriptions after the client recovers from
+// a connection loss.
+func (s *SubscriberStream) restore() error {
+	for _, sub := range s.remoteSubs() {
+		if _, err := s.register(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SubscriberStream) remoteSubs() []*remoteSub {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.subs)
+}
+
+// id returns the id of the first remote subscription
+func (s *SubscriberStream) id() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.subs) == 0 {
+		return ""
+	}
+	return s.subs[0].id
+}
+
+func (s *SubscriberStream) subID(sub *remoteSub) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return sub.id
+}
+
+func (s *SubscriberStream) handleMsg(sub *remoteSub, msg any) {
+	if s.wirePayloadOnly(sub) {
+		s.deliver(&m.Message{To: sub.target, Data: msg})
 		return
 	}
 
 	topic, err := ei.N(msg).M("s").String()
 	if err != nil {
-		topic = s.topic
+		topic = sub.target
 	}
 
 	payload, err := ei.N(msg).M("p").Raw()
 	if err != nil {
-		fmt.Println("Error getting payload", err)
+		s.c.Logger().Warn("stream message without payload", "address", s.address, "stream_id", s.subID(sub), "error", err)
 		return
 	}
 
-	s.buffer <- &m.Message{To: topic, Data: payload}
-}
+	if s.seq != nil {
+		if seq, err := ei.N(msg).M("seq").Uint64(); err == nil {
+			var ts time.Time
+			if ms, err := ei.N(msg).M("ts").Int64(); err == nil {
+				ts = time.UnixMilli(ms)
+			}
+			ev, ok := s.seq.track(sub.key(), seq, ts)
+			if ev != nil {
+				s.c.Logger().Debug("stream sequence "+ev.Kind.String(), "address", s.address, "stream_id", s.subID(sub), "seq", ev.Seq, "expected", ev.Expected)
+				if s.opts.OnSequence != nil {
+					s.opts.OnSequence(*ev)
+				}
+			}
+			if !ok {
+				s.received.Add(1)
+				return
+			}
+		}
+	}
 
-func (s *SubscriberStream) receiveMessage(client mqtt.Client, msg mqtt.Message) {
-	if strings.HasPrefix(msg.Topic(), m.MqttPublicPrefix+"/") {
-		var tmp any
-		err := msgpack.Unmarshal(msg.Payload(), &tmp)
-		if err != nil {
-			fmt.Println("Error unmarshalling message", err, msg.Payload())
+	// A remote subscription including subtopics may cover more topics than the requested ones
+	if sub.subtopics {
+		s.mutex.Lock()
+		requested := matchTopic(s.topics, topic)
+		s.mutex.Unlock()
+		if !requested {
 			return
 		}
-		s.handleMsg(tmp)
 	}
+	s.deliver(&m.Message{To: topic, Data: payload})
 }
 
-func (s *SubscriberStream) keepalive() {
-	t := time.NewTicker(s.timeout / 4)
-	defer s.Close()
-
-	for {
+// deliver puts a message in the channel buffer, applying the overflow policy if it is full
+func (s *SubscriberStream) deliver(msg *m.Message) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	s.received.Add(1)
+	switch s.opts.Overflow {
+	case OverflowDropNewest:
 		select {
-		case <-s.ctx.Done():
+		case s.buffer <- msg:
+		default:
+			s.drop(msg)
+		}
+
+	case OverflowDropOldest:
+		if cap(s.buffer) == 0 {
+			// Nothing is buffered, so the new message is the only one that can be dropped
+			select {
+			case s.buffer <- msg:
+			default:
+				s.drop(msg)
+			}
 			return
-		case <-t.C:
-			err := s.c.Call2(s.address, &m.Message{To: m.TopicRemoteSubscribe, Data: m.StreamCreateMsg{
-				Id:          s.subId,
-				Timeout:     s.timeout,
-				PayloadOnly: s.payloadOnly,
-			}}, nil, time.Second*5)
-			if err != nil && !ie.ErrTimeout.Is(err) {
-				s.cancel(err)
+		}
+		for {
+			select {
+			case s.buffer <- msg:
+				return
+			default:
+			}
+			select {
+			case old := <-s.buffer:
+				s.drop(old)
+			default:
+			}
+		}
+
+	case OverflowSpill:
+		// Once spilling, the messages keep going to the spill file until it is drained, to preserve their order
+		if s.spill.len() == 0 {
+			select {
+			case s.buffer <- msg:
 				return
+			default:
 			}
 		}
+		if err := s.spill.push(msg); err != nil {
+			s.dropped.Add(1)
+			s.c.dropInbound("stream_spill", msg.To, err)
+		}
+
+	default:
+		select {
+		case s.buffer <- msg:
+		case <-s.ctx.Done():
+		}
+	}
+}
+
+func (s *SubscriberStream) drop(msg *m.Message) {
+	s.dropped.Add(1)
+	s.c.dropInbound("stream_overflow", msg.To, nil)
+}
+
+// Stats returns the message counters and the buffer fill level of the stream.
+func (s *SubscriberStream) Stats() StreamStats {
+	st := StreamStats{
+		Received: s.received.Load(),
+		Dropped:  s.dropped.Load(),
+		Buffered: len(s.buffer),
+		Capacity: cap(s.buffer),
+	}
+	if s.spill != nil {
+		st.Spilled = s.spill.len()
+	}
+	if s.seq != nil {
+		s.seq.stats(&st)
+	}
+	return st
+}
+
+func (s *SubscriberStream) receiveMessage(sub *remoteSub, topic string, payload []byte) {
+	if strings.HasPrefix(topic, m.MqttPublicPrefix+"/") {
+		var tmp any
+		err := msgpack.Unmarshal(payload, &tmp)
+		if err != nil {
+			s.c.Logger().Warn("can't decode stream message", "address", s.address, "stream_id", s.subID(sub), "topic", topic, "error", err)
+			return
+		}
+		s.handleMsg(sub, tmp)
 	}
 }
 
+func (s *SubscriberStream) describe() (string, []string, bool) {
+	return s.address, s.Topics(), false
+}
+
+func (s *SubscriberStream) refreshInterval() time.Duration {
+	return s.timeout / 4
+}
+
+func (s *SubscriberStream) context() context.Context {
+	return s.ctx
+}
+
+func (s *SubscriberStream) lose(err error) {
+	s.cancel(err)
+	s.Close()
+}
+
+// refresh renews the remote subscriptions, subscribing again to the ones the device does
+// not know (e.g. because it rebooted)
+func (s *SubscriberStream) refresh() (restored bool, err error) {
+	for _, sub := range s.remoteSubs() {
+		subRestored, err := s.refreshSub(sub)
+		if err != nil {
+			return restored, err
+		}
+		restored = restored || subRestored
+	}
+	return restored, nil
+}
+
+func (s *SubscriberStream) refreshSub(sub *remoteSub) (restored bool, err error) {
+	err = s.c.Call2(s.address, &m.Message{To: m.TopicRemoteSubscribe, Data: m.StreamCreateMsg{
+		Id:             s.subID(sub),
+		Timeout:        s.timeout,
+		PayloadOnly:    s.wirePayloadOnly(sub),
+		AllowSubtopics: sub.subtopics,
+		Sequenced:      s.opts.Sequenced,
+		QoS:            s.opts.QoS,
+	}}, nil, time.Second*5)
+	if isInvalidStreamID(err) {
+		s.c.Logger().Info("stream unknown to the device, subscribing again", "address", s.address, "stream_id", s.subID(sub), "topic", sub.key())
+		if _, err = s.register(sub); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, err
+}
+
 // Channel returns a read-only channel that streams messages from the subscriber.
 func (s *SubscriberStream) Channel() <-chan *m.Message {
 	return s.buffer
@@ -132,14 +589,20 @@ func (s *SubscriberStream) Context() context.Context {
 	return s.ctx
 }
 
-// Close terminates the 'SubscriberStream' by canceling the stream and unsubscribing from the remote topic.
+// Close terminates the 'SubscriberStream' by canceling the stream and unsubscribing from the remote topics.
 func (s *SubscriberStream) Close() error {
 	defer s.cancel(fmt.Errorf("closed by user"))
-	_, err := s.c.Call(s.address, &m.Message{To: m.TopicRemoteUnsubscribe, Data: m.StreamDeleteMsg{
-		Id: s.subId,
-	}}, time.Second*5)
-	if err != nil {
-		return err
+	s.c.StreamManager().remove(s)
+	s.mutex.Lock()
+	subs := s.subs
+	s.subs = nil
+	s.mutex.Unlock()
+
+	var errs []error
+	for _, sub := range subs {
+		if err := s.unregister(sub); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
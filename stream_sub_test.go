@@ -0,0 +1,49 @@
+package idefixgo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoveringTopic(t *testing.T) {
+	cases := []struct {
+		patterns  []string
+		target    string
+		subtopics bool
+	}{
+		{[]string{"sensor.temp"}, "sensor.temp", false},
+		{[]string{"sensor.*"}, "sensor", true},
+		{[]string{"*"}, "", true},
+		{[]string{"sensor.temp", "sensor.hum.*"}, "sensor", true},
+		{[]string{"sensor", "sensor.temp"}, "sensor", true},
+		{[]string{"sensor.temp.max", "sensor.temperature"}, "sensor", true},
+		{[]string{"sensor.temp", "alarm.*"}, "", true},
+	}
+	for _, c := range cases {
+		target, subtopics := coveringTopic(c.patterns)
+		require.Equal(t, c.target, target, c.patterns)
+		require.Equal(t, c.subtopics, subtopics, c.patterns)
+	}
+
+	// Unrelated topics are not merged into a subscription to the whole device bus
+	keys := func(subs []*remoteSub) []string {
+		var res []string
+		for _, sub := range subs {
+			res = append(res, sub.key())
+		}
+		return res
+	}
+	require.Equal(t, []string{"sensor.*", "alarm.*"}, keys(coveringTopics([]string{"sensor.temp", "alarm.*", "sensor.hum"})))
+	require.Equal(t, []string{"sensor.temp", "gps.pos"}, keys(coveringTopics([]string{"sensor.temp", "gps.pos"})))
+	require.Equal(t, []string{".*"}, keys(coveringTopics([]string{"sensor.temp", "*"})))
+	require.Empty(t, coveringTopics(nil))
+
+	patterns := []string{"sensor.temp", "alarm.*"}
+	require.True(t, matchTopic(patterns, "sensor.temp"))
+	require.False(t, matchTopic(patterns, "sensor.temp.max"))
+	require.True(t, matchTopic(patterns, "alarm"))
+	require.True(t, matchTopic(patterns, "alarm.fire.kitchen"))
+	require.False(t, matchTopic(patterns, "alarms"))
+	require.True(t, matchTopic([]string{"*"}, "other"))
+}
@@ -0,0 +1,137 @@
+package idefixgo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer and the meter used by the client
+const instrumentationName = "github.com/nayarsystems/idefix-go"
+
+// Attribute keys of the client spans and metrics
+const (
+	AttrRemoteAddress = attribute.Key("idefix.remote_address")
+	AttrTo            = attribute.Key("idefix.to")
+	AttrEncoding      = attribute.Key("idefix.encoding")
+	AttrFlags         = attribute.Key("idefix.flags")
+	AttrErrorCode     = attribute.Key("idefix.error_code")
+	AttrDropReason    = attribute.Key("idefix.drop_reason")
+)
+
+// telemetry holds the OpenTelemetry instruments of a client
+type telemetry struct {
+	tracer                trace.Tracer
+	callDuration          metric.Float64Histogram
+	publishedBytes        metric.Int64Counter
+	receivedBytes         metric.Int64Counter
+	messageSize           metric.Int64Histogram
+	compressionSavedBytes metric.Int64Counter
+	droppedMessages       metric.Int64Counter
+	unreceived            metric.Int64Counter
+}
+
+// newTelemetry creates the instruments using the providers of the options,
+// falling back to the global ones (which are no-op unless configured).
+func newTelemetry(opts *ClientOptions) *telemetry {
+	tp := opts.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	mp := opts.MeterProvider
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+
+	// Instrument creation only fails on invalid names or units, so the errors are
+	// ignored: a no-op instrument is returned in that case.
+	t := &telemetry{tracer: tp.Tracer(instrumentationName)}
+	t.callDuration, _ = meter.Float64Histogram("idefix.client.call.duration",
+		metric.WithUnit("s"), metric.WithDescription("Duration of the requests, including their retries"))
+	t.publishedBytes, _ = meter.Int64Counter("idefix.client.published",
+		metric.WithUnit("By"), metric.WithDescription("Bytes published to the broker"))
+	t.receivedBytes, _ = meter.Int64Counter("idefix.client.received",
+		metric.WithUnit("By"), metric.WithDescription("Bytes received from the broker"))
+	t.messageSize, _ = meter.Int64Histogram("idefix.client.message.size",
+		metric.WithUnit("By"), metric.WithDescription("Size of the published and received payloads"))
+	t.compressionSavedBytes, _ = meter.Int64Counter("idefix.client.compression.saved",
+		metric.WithUnit("By"), metric.WithDescription("Bytes saved by compressing the published messages"))
+	t.droppedMessages, _ = meter.Int64Counter("idefix.client.inbound.dropped",
+		metric.WithUnit("{message}"), metric.WithDescription("Inbound messages discarded by the client"))
+	t.unreceived, _ = meter.Int64Counter("idefix.client.unreceived",
+		metric.WithUnit("{message}"), metric.WithDescription("Inbound messages delivered to no subscriber"))
+	return t
+}
+
+var (
+	directionIn  = metric.WithAttributes(attribute.String("idefix.direction", "in"))
+	directionOut = metric.WithAttributes(attribute.String("idefix.direction", "out"))
+)
+
+// telemetry returns the client instruments, creating them on first use
+func (c *Client) telemetry() *telemetry {
+	c.telemetryOnce.Do(func() {
+		c.tel = newTelemetry(c.opts)
+	})
+	return c.tel
+}
+
+// traceCall runs a request inside a span and records its duration
+func (c *Client) traceCall(ctx context.Context, remoteAddress string, msg *m.Message, call CallFunc) (*m.Message, error) {
+	tel := c.telemetry()
+	attrs := []attribute.KeyValue{
+		AttrRemoteAddress.String(remoteAddress),
+		AttrTo.String(msg.To),
+		AttrEncoding.String(c.opts.Encoding),
+	}
+	ctx, span := tel.tracer.Start(ctx, remoteAddress+"."+msg.To,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	defer span.End()
+
+	start := time.Now()
+	res, err := call(ctx, remoteAddress, msg)
+	if err != nil {
+		code := errorCode(err)
+		attrs = append(attrs, AttrErrorCode.Int(code))
+		span.SetAttributes(AttrErrorCode.Int(code))
+		span.SetStatus(codes.Error, err.Error())
+	}
+	// The remote address is left out of the metrics to keep their cardinality bounded
+	tel.callDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attrs[1:]...))
+	return res, err
+}
+
+// dropInbound counts and logs an inbound message discarded for the given reason
+func (c *Client) dropInbound(reason string, topic string, err error) {
+	c.telemetry().droppedMessages.Add(c.ctx, 1, metric.WithAttributes(AttrDropReason.String(reason)))
+	if err != nil {
+		c.Logger().Warn("inbound message dropped", "reason", reason, "topic", topic, "error", err)
+		return
+	}
+	c.Logger().Debug("inbound message dropped", "reason", reason, "topic", topic)
+}
+
+// errorCode returns the IdefixError code of err ([ie.ErrUnknown] if it is not an IdefixError)
+func errorCode(err error) int {
+	var value ie.IdefixError
+	if errors.As(err, &value) {
+		return value.Code
+	}
+	var ptr *ie.IdefixError
+	if errors.As(err, &ptr) && ptr != nil {
+		return ptr.Code
+	}
+	if parsed, perr := ie.Parse(err.Error()); perr == nil {
+		return parsed.Code
+	}
+	return ie.ErrUnknown.Code
+}
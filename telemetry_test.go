@@ -0,0 +1,121 @@
+package idefixgo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.opentelemetry.io/otel/attribute"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+// sumMetric returns the sum of the data points of an Int64 counter
+func sumMetric(t *testing.T, reader *sdkmetric.ManualReader, name string) int64 {
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	var total int64
+	for _, sm := range rm.ScopeMetrics {
+		for _, metric := range sm.Metrics {
+			if metric.Name != name {
+				continue
+			}
+			for _, dp := range metric.Data.(metricdata.Sum[int64]).DataPoints {
+				total += dp.Value
+			}
+		}
+	}
+	return total
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTelemetry(t *testing.T) {
+	b := NewLoopbackBroker()
+	r := newLoopbackResponder(t, b)
+
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	c := newLoopbackClient(b, false)
+	c.opts.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	c.opts.MeterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	_, err := c.Call("idefix", &m.Message{To: "echo", Data: map[string]any{}}, time.Second)
+	require.NoError(t, err)
+	_, err = c.Call("idefix", &m.Message{To: "unknown"}, time.Second)
+	require.Error(t, err)
+
+	ended := spans.Ended()
+	require.Len(t, ended, 3)
+	require.Equal(t, "idefix.login", ended[0].Name())
+	require.Equal(t, "echo", spanAttr(ended[1], AttrTo).AsString())
+	require.Equal(t, "idefix", spanAttr(ended[1], AttrRemoteAddress).AsString())
+	require.Equal(t, "m", spanAttr(ended[1], AttrFlags).AsString())
+	require.EqualValues(t, ie.ErrNotImplemented.Code, spanAttr(ended[2], AttrErrorCode).AsInt64())
+
+	require.Positive(t, sumMetric(t, reader, "idefix.client.published"))
+	require.Positive(t, sumMetric(t, reader, "idefix.client.received"))
+
+	// Inbound messages nobody waits for, or which can't be decoded
+	responseTopic := fmt.Sprintf("%s/%s/r/m", m.MqttIdefixPrefix, c.sessionID)
+	r.tr.Publish(context.Background(), responseTopic, 1, []byte("invalid"))
+	require.Eventually(t, func() bool {
+		return sumMetric(t, reader, "idefix.client.inbound.dropped") == 1
+	}, time.Second, time.Millisecond*10)
+	data, err := msgpack.Marshal(&m.Message{To: "nobody"})
+	require.NoError(t, err)
+	r.tr.Publish(context.Background(), responseTopic, 1, data)
+	require.Eventually(t, func() bool {
+		return sumMetric(t, reader, "idefix.client.unreceived") == 1
+	}, time.Second, time.Millisecond*10)
+
+	// Compression savings
+	c.opts.Encoding = "mg"
+	require.NoError(t, c.Publish("dev", &m.Message{To: "data", Data: strings.Repeat("a", 4096)}))
+	require.Greater(t, sumMetric(t, reader, "idefix.client.compression.saved"), int64(3000))
+}
+
+func TestTelemetryNoop(t *testing.T) {
+	b := NewLoopbackBroker()
+	newLoopbackResponder(t, b)
+
+	c := newLoopbackClient(b, false)
+	c.opts.TracerProvider = tracenoop.NewTracerProvider()
+	c.opts.MeterProvider = metricnoop.NewMeterProvider()
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	_, err := c.Call("idefix", &m.Message{To: "echo", Data: map[string]any{}}, time.Second)
+	require.NoError(t, err)
+}
+
+func TestErrorCode(t *testing.T) {
+	notFound := ie.ErrNotFound.With("address")
+	require.Equal(t, ie.ErrNotFound.Code, errorCode(notFound))
+	require.Equal(t, ie.ErrNotFound.Code, errorCode(&notFound))
+
+	// Wrapped errors keep their code
+	require.Equal(t, ie.ErrNotFound.Code, errorCode(fmt.Errorf("call: %w", notFound)))
+	require.Equal(t, ie.ErrNotFound.Code, errorCode(fmt.Errorf("call: %w", &notFound)))
+	require.Equal(t, ie.ErrTimeout.Code, errorCode(fmt.Errorf("%s", ie.ErrTimeout.Error())))
+	require.Equal(t, ie.ErrUnknown.Code, errorCode(fmt.Errorf("not an idefix error")))
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	idf "github.com/nayarsystems/idefix-go"
+	m "github.com/nayarsystems/idefix-go/messages"
 	"github.com/spf13/cobra"
 )
 
@@ -17,6 +19,10 @@ func init() {
 	cmdAuthStore.Flags().StringP("token", "t", "", "Token")
 	cmdAuth.AddCommand(cmdAuthStore)
 
+	cmdAuthSession.Flags().Duration("duration", time.Hour*24, "Session duration")
+	cmdAuthSession.Flags().Bool("clear", false, "Delete the stored session, so the next commands log in again")
+	cmdAuth.AddCommand(cmdAuthSession)
+
 	rootCmd.AddCommand(cmdAuth)
 }
 
@@ -80,3 +86,55 @@ func cmdAuthStoreRunE(cmd *cobra.Command, args []string) error {
 
 	return cmdAuthShowRunE(cmd, args)
 }
+
+var cmdAuthSession = &cobra.Command{
+	Use:   "session <name>",
+	Short: "Create a session stored in the configuration, so the commands using it skip the login",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  cmdAuthSessionRunE,
+}
+
+func cmdAuthSessionRunE(cmd *cobra.Command, args []string) error {
+	cfg, err := idf.ReadConfig(args[0])
+	if err != nil {
+		return err
+	}
+
+	clearSession, _ := cmd.Flags().GetBool("clear")
+	if clearSession {
+		if cfg.SkipLogin && cfg.SessionID != "" {
+			ic := idf.NewClient(rootctx, cfg)
+			if err := ic.Connect(); err == nil {
+				_, err = ic.SessionDelete(&m.SessionDeleteMsg{Session: cfg.SessionID})
+				ic.Disconnect()
+				if err != nil {
+					fmt.Printf("Can't delete session %s: %v\n", cfg.SessionID, err)
+				}
+			}
+		}
+		cfg.SessionID = ""
+		cfg.SkipLogin = false
+		if err := idf.UpdateConfig(cfg); err != nil {
+			return err
+		}
+		fmt.Println("Session cleared")
+		return nil
+	}
+
+	// Log in again, the stored session may have expired
+	cfg.SessionID = ""
+	cfg.SkipLogin = false
+	ic := idf.NewClient(rootctx, cfg)
+	if err := ic.Connect(); err != nil {
+		return err
+	}
+	defer ic.Disconnect()
+
+	duration, _ := cmd.Flags().GetDuration("duration")
+	session, err := ic.DelegateSession(duration)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Session %s stored, valid for %v\n", session, duration)
+	return nil
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/jaracil/ei"
+	idefixgo "github.com/nayarsystems/idefix-go"
 	"github.com/spf13/cobra"
 )
 
@@ -22,6 +25,10 @@ func init() {
 	cmdStream.Flags().StringP("address", "a", "", "Device address")
 	cmdStream.Flags().BoolP("timestamp", "t", false, "Show timestamp (-t)")
 	cmdStream.Flags().Bool("timestamp-with-delta", false, "Show timestamp with delta")
+	cmdStream.Flags().String("overflow", "block", "Behaviour when the stream buffer is full: block, drop-oldest, drop-newest or spill")
+	cmdStream.Flags().Uint("buffer", 100, "Stream buffer size")
+	cmdStream.Flags().Bool("sequenced", false, "Number the stream messages to detect the lost ones")
+	cmdStream.Flags().Uint8("qos", 0, "MQTT QoS of the stream (0 to 2)")
 
 	rootCmd.AddCommand(cmdStream)
 }
@@ -33,8 +40,10 @@ var cmdLog = &cobra.Command{
 }
 
 var cmdStream = &cobra.Command{
-	Use:   "stream",
+	Use:   "stream <topic>...",
 	Short: "Stream device messages",
+	Long:  "Stream the messages of one or more device topics. A topic ending in \".*\" also streams its subtopics (e.g. \"sensor.*\").",
+	Args:  cobra.MinimumNArgs(1),
 	RunE:  cmdStreamRunE,
 }
 
@@ -144,8 +153,25 @@ func cmdStreamRunE(cmd *cobra.Command, args []string) error {
 		timestampLevel = 0
 	}
 
-	if len(args) != 1 {
-		return fmt.Errorf("stream only supports one topic")
+	overflowName, err := cmd.Flags().GetString("overflow")
+	if err != nil {
+		return err
+	}
+	overflow, err := parseOverflowPolicy(overflowName)
+	if err != nil {
+		return err
+	}
+	capacity, err := cmd.Flags().GetUint("buffer")
+	if err != nil {
+		return err
+	}
+	sequenced, err := cmd.Flags().GetBool("sequenced")
+	if err != nil {
+		return err
+	}
+	qos, err := cmd.Flags().GetUint8("qos")
+	if err != nil {
+		return err
 	}
 
 	ic, err := getConnectedClient()
@@ -154,7 +180,11 @@ func cmdStreamRunE(cmd *cobra.Command, args []string) error {
 	}
 	defer ic.Disconnect()
 
-	s, err := ic.NewSubscriberStream(addr, args[0], 100, false, time.Minute*10)
+	s, err := ic.NewMultiSubscriberStream(addr, args, capacity, false, time.Minute*10, idefixgo.StreamOptions{
+		Overflow:  overflow,
+		Sequenced: sequenced,
+		QoS:       qos,
+	})
 	if err != nil {
 		log.Fatalln("Cannot open stream:", err)
 	}
@@ -162,13 +192,19 @@ func cmdStreamRunE(cmd *cobra.Command, args []string) error {
 	defer s.Close()
 
 	var lastLogTime time.Time
+	var lastStats idefixgo.StreamStats
+	statsTicker := time.NewTicker(time.Second)
+	defer statsTicker.Stop()
 
-	fmt.Printf("-- Streaming %s %s --\n", addr, args[0])
+	fmt.Printf("-- Streaming %s %s --\n", addr, strings.Join(args, " "))
 	for {
 		select {
 		case k := <-s.Channel():
 			fmt.Print(generateStreamLine(timestampLevel, k.To, k.Data, &lastLogTime))
 
+		case <-statsTicker.C:
+			lastStats = warnStreamStats(s.Stats(), lastStats)
+
 		case <-s.Context().Done():
 			return s.Context().Err()
 
@@ -178,6 +214,32 @@ func cmdStreamRunE(cmd *cobra.Command, args []string) error {
 	}
 }
 
+func parseOverflowPolicy(name string) (idefixgo.OverflowPolicy, error) {
+	for _, p := range []idefixgo.OverflowPolicy{idefixgo.OverflowBlock, idefixgo.OverflowDropOldest, idefixgo.OverflowDropNewest, idefixgo.OverflowSpill} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overflow policy %q", name)
+}
+
+// warnStreamStats prints a warning on stderr when the stream starts falling behind the
+// device or loses messages, and returns the stats to compare with on the next call
+func warnStreamStats(stats idefixgo.StreamStats, last idefixgo.StreamStats) idefixgo.StreamStats {
+	if stats.Gaps > last.Gaps {
+		fmt.Fprintf(os.Stderr, "%s %d messages missing (%d lost in total)\n", colorize("WARNING:", colorYellow), stats.Lost-min(last.Lost, stats.Lost), stats.Lost)
+	}
+	switch {
+	case stats.Dropped > last.Dropped:
+		fmt.Fprintf(os.Stderr, "%s %d messages dropped (%d total)\n", colorize("WARNING:", colorYellow), stats.Dropped-last.Dropped, stats.Dropped)
+	case stats.Spilled > 0 && last.Spilled == 0:
+		fmt.Fprintf(os.Stderr, "%s %d messages spilled to disk\n", colorize("WARNING:", colorYellow), stats.Spilled)
+	case stats.FillLevel() >= 0.8 && last.FillLevel() < 0.8:
+		fmt.Fprintf(os.Stderr, "%s stream buffer %.0f%% full\n", colorize("WARNING:", colorYellow), stats.FillLevel()*100)
+	}
+	return stats
+}
+
 // ANSI color codes
 const (
 	colorRed     = 31
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"sync"
 	"time"
 
-	"github.com/jaracil/ei"
+	idefixgo "github.com/nayarsystems/idefix-go"
 	"github.com/songgao/water"
 	"github.com/spf13/cobra"
 	"github.com/vishvananda/netlink"
@@ -53,59 +57,57 @@ func cmdTunRunE(cmd *cobra.Command, args []string) error {
 	}
 	defer ic.Disconnect()
 
-	subStream, err := ic.NewSubscriberStream(addr, "tun.evt.frame", 100, true, time.Second*30)
-	if err != nil {
-		return err
-	}
-
-	pubStream, err := ic.NewPublisherStream(addr, "tun.cmd.frame", 100, true, time.Second*30)
+	conn, err := ic.NewStreamConn(addr, idefixgo.StreamConnOptions{
+		ReadTopic:  "tun.evt.frame",
+		WriteTopic: "tun.cmd.frame",
+		Timeout:    time.Second * 30,
+	})
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
+	stop := context.AfterFunc(ic.Context(), func() { conn.Close() })
+	defer stop()
 
+	// The error stopping the interface reader, which closes the connection
+	readerErr := make(chan error, 1)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer conn.Close()
 
 		b := make([]byte, 1500)
 		for {
 			n, err := iface.Read(b)
 			if err != nil {
 				if ic.Context().Err() == nil {
-					fmt.Println("Error reading from interface", err)
+					readerErr <- fmt.Errorf("reading from interface: %w", err)
 				}
 				return
 			}
-			data := map[string]interface{}{"frame": b[:n]}
-			pubStream.Publish(data, "")
+			if _, err := conn.Write(b[:n]); err != nil {
+				readerErr <- fmt.Errorf("writing to device: %w", err)
+				return
+			}
 		}
 	}()
 
 	fmt.Println("Connected")
+	frame := make([]byte, 65535)
 	for {
-		select {
-		case frame, ok := <-subStream.Channel():
-			if !ok {
-				return fmt.Errorf("subStream closed")
-			}
-
-			framebytes, err := ei.N(frame.Data).M("frame").Bytes()
-			if err != nil {
-				fmt.Println("Error getting frame bytes", err)
-				continue
+		n, err := conn.Read(frame)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
+				select {
+				case err := <-readerErr:
+					return err
+				default:
+					return nil
+				}
 			}
-
-			iface.Write(framebytes)
-
-		case <-pubStream.Context().Done():
-			return pubStream.Context().Err()
-
-		case <-subStream.Context().Done():
-			return subStream.Context().Err()
-
-		case <-ic.Context().Done():
-			return nil
+			return err
 		}
+		iface.Write(frame[:n])
 	}
 }
 
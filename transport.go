@@ -0,0 +1,50 @@
+package idefixgo
+
+import (
+	"context"
+)
+
+// TransportHandler is a function type that handles the messages received
+// by a [Transport] on a subscribed topic.
+type TransportHandler func(topic string, payload []byte)
+
+// Transport abstracts the publish/subscribe connection used by the [Client]
+// to reach Idefix. The default implementation uses MQTT (paho), but any
+// other transport with the same topic semantics can be plugged in through
+// [ClientOptions.Transport].
+//
+// Implementations must allow Connect to be called again after a Disconnect
+// or a connection loss.
+type Transport interface {
+	// Connect establishes the connection using the given client identifier.
+	Connect(clientID string) error
+
+	// Disconnect closes the connection. The connection lost handler is not called.
+	Disconnect()
+
+	// IsConnected reports whether the connection is currently established.
+	IsConnected() bool
+
+	// Publish sends the payload to the given topic. It blocks until the message
+	// has been delivered to the transport (according to the qos) or the context is done.
+	Publish(ctx context.Context, topic string, qos byte, payload []byte) error
+
+	// Subscribe registers a handler for the messages received on the given topic.
+	// Topics may contain MQTT wildcards ('+' and '#').
+	Subscribe(topic string, qos byte, handler TransportHandler) error
+
+	// Unsubscribe removes the subscriptions of the given topics.
+	Unsubscribe(topics ...string) error
+
+	// SetConnectionLostHandler sets the function called when the connection
+	// is lost unexpectedly.
+	SetConnectionLostHandler(handler func(err error))
+}
+
+// BrokerReporter is implemented by the transports able to report which broker
+// they are connected to (e.g. the default MQTT transport, which fails over between
+// [ClientOptions.Broker] and [ClientOptions.Brokers]).
+type BrokerReporter interface {
+	// ActiveBroker returns the broker of the current (or last) connection.
+	ActiveBroker() string
+}
@@ -0,0 +1,255 @@
+package idefixgo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ErrLoopbackNotConnected is returned by a [LoopbackTransport] when it is used while disconnected.
+var ErrLoopbackNotConnected = errors.New("loopback transport not connected")
+
+// LoopbackBroker is an in-process message broker that routes the messages published
+// by its [LoopbackTransport] instances, following the MQTT topic matching rules.
+//
+// It allows running clients (and the services they talk to) inside the same process
+// without network access, which is mainly useful for testing.
+type LoopbackBroker struct {
+	mutex      sync.RWMutex
+	transports map[*LoopbackTransport]struct{}
+}
+
+// NewLoopbackBroker returns a new empty [LoopbackBroker].
+func NewLoopbackBroker() *LoopbackBroker {
+	return &LoopbackBroker{
+		transports: make(map[*LoopbackTransport]struct{}),
+	}
+}
+
+// NewTransport returns a new [LoopbackTransport] attached to the broker.
+func (b *LoopbackBroker) NewTransport() *LoopbackTransport {
+	return &LoopbackTransport{
+		b:    b,
+		subs: make(map[string]TransportHandler),
+	}
+}
+
+// DropAll simulates an unexpected connection loss on every connected transport.
+func (b *LoopbackBroker) DropAll() {
+	b.mutex.RLock()
+	transports := make([]*LoopbackTransport, 0, len(b.transports))
+	for t := range b.transports {
+		transports = append(transports, t)
+	}
+	b.mutex.RUnlock()
+
+	for _, t := range transports {
+		t.Drop(errors.New("connection dropped by broker"))
+	}
+}
+
+func (b *LoopbackBroker) attach(t *LoopbackTransport) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.transports[t] = struct{}{}
+}
+
+func (b *LoopbackBroker) detach(t *LoopbackTransport) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.transports, t)
+}
+
+func (b *LoopbackBroker) publish(topic string, payload []byte) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for t := range b.transports {
+		t.deliver(topic, payload)
+	}
+}
+
+type loopbackMsg struct {
+	topic   string
+	payload []byte
+}
+
+// LoopbackTransport is a [Transport] implementation connected to a [LoopbackBroker].
+//
+// As with MQTT, incoming messages are handled sequentially, in order, on a goroutine
+// owned by the transport, so handlers may publish messages without deadlocking.
+type LoopbackTransport struct {
+	b           *LoopbackBroker
+	mutex       sync.Mutex
+	connected   bool
+	clientID    string
+	subs        map[string]TransportHandler
+	queue       []loopbackMsg
+	wake        chan struct{}
+	done        chan struct{}
+	lostHandler func(err error)
+}
+
+// ClientID returns the identifier used by the last connection.
+func (t *LoopbackTransport) ClientID() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.clientID
+}
+
+func (t *LoopbackTransport) Connect(clientID string) error {
+	t.mutex.Lock()
+	if t.connected {
+		t.mutex.Unlock()
+		return nil
+	}
+	t.connected = true
+	t.clientID = clientID
+	t.subs = make(map[string]TransportHandler)
+	t.queue = nil
+	t.wake = make(chan struct{}, 1)
+	t.done = make(chan struct{})
+	go t.dispatch(t.wake, t.done)
+	t.mutex.Unlock()
+
+	t.b.attach(t)
+	return nil
+}
+
+func (t *LoopbackTransport) Disconnect() {
+	t.disconnect()
+}
+
+// Drop simulates an unexpected connection loss: the transport gets disconnected
+// and the connection lost handler is called with the given error.
+func (t *LoopbackTransport) Drop(err error) {
+	if !t.disconnect() {
+		return
+	}
+	t.mutex.Lock()
+	handler := t.lostHandler
+	t.mutex.Unlock()
+	if handler != nil {
+		go handler(err)
+	}
+}
+
+func (t *LoopbackTransport) disconnect() bool {
+	t.b.detach(t)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.connected {
+		return false
+	}
+	t.connected = false
+	close(t.done)
+	return true
+}
+
+func (t *LoopbackTransport) IsConnected() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.connected
+}
+
+func (t *LoopbackTransport) Publish(ctx context.Context, topic string, qos byte, payload []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !t.IsConnected() {
+		return ErrLoopbackNotConnected
+	}
+	t.b.publish(topic, append([]byte(nil), payload...))
+	return nil
+}
+
+func (t *LoopbackTransport) Subscribe(topic string, qos byte, handler TransportHandler) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.connected {
+		return ErrLoopbackNotConnected
+	}
+	t.subs[topic] = handler
+	return nil
+}
+
+func (t *LoopbackTransport) Unsubscribe(topics ...string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.connected {
+		return ErrLoopbackNotConnected
+	}
+	for _, topic := range topics {
+		delete(t.subs, topic)
+	}
+	return nil
+}
+
+func (t *LoopbackTransport) SetConnectionLostHandler(handler func(err error)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.lostHandler = handler
+}
+
+func (t *LoopbackTransport) deliver(topic string, payload []byte) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.connected {
+		return
+	}
+	t.queue = append(t.queue, loopbackMsg{topic: topic, payload: payload})
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (t *LoopbackTransport) dispatch(wake, done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-wake:
+		}
+		for {
+			t.mutex.Lock()
+			if len(t.queue) == 0 || t.done != done {
+				t.mutex.Unlock()
+				break
+			}
+			msg := t.queue[0]
+			t.queue = t.queue[1:]
+			var handlers []TransportHandler
+			for filter, handler := range t.subs {
+				if topicMatches(filter, msg.topic) {
+					handlers = append(handlers, handler)
+				}
+			}
+			t.mutex.Unlock()
+
+			for _, handler := range handlers {
+				handler(msg.topic, msg.payload)
+			}
+		}
+	}
+}
+
+// topicMatches reports whether an MQTT topic matches a subscription filter,
+// which may contain single level ('+') and multi level ('#') wildcards.
+func topicMatches(filter, topic string) bool {
+	fl := strings.Split(filter, "/")
+	tl := strings.Split(topic, "/")
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tl) {
+			return false
+		}
+		if f != "+" && f != tl[i] {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
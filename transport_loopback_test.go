@@ -0,0 +1,177 @@
+package idefixgo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// loopbackResponder answers the messages sent by msgpack encoded clients.
+// It handles "idefix.login" and "idefix.echo", and emulates a device
+// serving remote streams for the rest of the addresses.
+type loopbackResponder struct {
+	t           *testing.T
+	tr          *LoopbackTransport
+	logins      atomic.Int32
+	rejectLogin atomic.Bool // answer the logins with an invalid token error
+	mutex       sync.Mutex
+	subs        map[string]string // stream id -> public topic
+}
+
+func newLoopbackResponder(t *testing.T, b *LoopbackBroker) *loopbackResponder {
+	r := &loopbackResponder{t: t, tr: b.NewTransport(), subs: map[string]string{}}
+	require.NoError(t, r.tr.Connect("responder"))
+	require.NoError(t, r.tr.Subscribe(m.MqttIdefixPrefix+"/+/t/+", 1, r.handle))
+	t.Cleanup(r.tr.Disconnect)
+	return r
+}
+
+func (r *loopbackResponder) handle(topic string, payload []byte) {
+	session := strings.Split(topic, "/")[1]
+	var msg m.Message
+	if err := msgpack.Unmarshal(payload, &msg); err != nil {
+		return
+	}
+	res := &m.Message{To: msg.Res}
+	switch msg.To {
+	case "idefix.login":
+		r.logins.Add(1)
+		if r.rejectLogin.Load() {
+			res.Err = ie.ErrInvalidToken.Error()
+			break
+		}
+		res.Data = true
+	case "idefix.echo":
+		res.Data = msg.Data
+	case "dev.streams.cmd.sub":
+		req := m.StreamCreateMsg{}
+		if err := m.ParseMsg(msg.Data, &req); err != nil {
+			return
+		}
+		r.mutex.Lock()
+		id := req.Id
+		if id == "" {
+			id = fmt.Sprintf("sub%d", len(r.subs))
+			r.subs[id] = "dev/" + id
+		}
+		r.mutex.Unlock()
+		res.Data = map[string]any{"id": id, "pub": "dev/" + id}
+	case "dev.streams.cmd.unsub":
+		res.Data = map[string]any{}
+	default:
+		res.Err = "[3] Not implemented"
+	}
+	data, err := msgpack.Marshal(res)
+	if err != nil {
+		return
+	}
+	r.tr.Publish(context.Background(), fmt.Sprintf("%s/%s/r/m", m.MqttIdefixPrefix, session), 1, data)
+}
+
+// publishStream emits a message on every remote subscription created so far
+func (r *loopbackResponder) publishStream(payload any) {
+	r.publishStreamMsg(m.StreamMsg{SourceTopic: "sensor.value", Payload: payload})
+}
+
+// publishStreamMsg emits a stream envelope on every remote subscription created so far
+func (r *loopbackResponder) publishStreamMsg(msg m.StreamMsg) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, pub := range r.subs {
+		data, err := msgpack.Marshal(msg)
+		require.NoError(r.t, err)
+		r.tr.Publish(context.Background(), m.MqttPublicPrefix+"/"+pub, 0, data)
+	}
+}
+
+func newLoopbackClient(b *LoopbackBroker, reconnect bool) *Client {
+	return NewClient(context.Background(), &ClientOptions{
+		Encoding:             "m",
+		Address:              "test",
+		Token:                "testToken",
+		Transport:            b.NewTransport(),
+		Reconnect:            reconnect,
+		ReconnectMinInterval: time.Millisecond * 10,
+		ReconnectMaxInterval: time.Millisecond * 50,
+	})
+}
+
+func TestLoopbackCall(t *testing.T) {
+	b := NewLoopbackBroker()
+	r := newLoopbackResponder(t, b)
+
+	c := newLoopbackClient(b, false)
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+	require.EqualValues(t, 1, r.logins.Load())
+
+	res, err := c.Call("idefix", &m.Message{To: "echo", Data: map[string]any{"hello": "world"}}, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "world", res.Data.(map[string]any)["hello"])
+
+	resp := map[string]any{}
+	err = c.Call2("idefix", &m.Message{To: "echo", Data: map[string]any{"n": 1}}, &resp, time.Second)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, resp["n"])
+
+	_, err = c.Call("idefix", &m.Message{To: "unknown"}, time.Second)
+	require.Error(t, err)
+}
+
+func TestLoopbackDisconnect(t *testing.T) {
+	b := NewLoopbackBroker()
+	newLoopbackResponder(t, b)
+
+	c := newLoopbackClient(b, false)
+	require.NoError(t, c.Connect())
+
+	b.DropAll()
+	require.Eventually(t, func() bool { return c.Status() == Disconnected }, time.Second, time.Millisecond*10)
+	require.Error(t, c.Context().Err())
+}
+
+func TestLoopbackReconnect(t *testing.T) {
+	b := NewLoopbackBroker()
+	r := newLoopbackResponder(t, b)
+
+	c := newLoopbackClient(b, true)
+	var statuses atomic.Int32
+	c.ConnectionStatusHandler = func(c *Client, cs ConnectionStatus) {
+		statuses.Add(1)
+	}
+	require.NoError(t, c.Connect())
+	defer c.Disconnect()
+
+	s, err := c.NewSubscriberStream("dev", "sensor", 10, false, time.Minute)
+	require.NoError(t, err)
+
+	c.opts.Transport.(*LoopbackTransport).Drop(fmt.Errorf("test"))
+	require.Eventually(t, func() bool { return statuses.Load() == 3 }, time.Second, time.Millisecond*10)
+	require.Equal(t, Connected, c.Status())
+	require.NoError(t, c.Context().Err())
+	require.EqualValues(t, 2, r.logins.Load())
+
+	_, err = c.Call("idefix", &m.Message{To: "echo", Data: map[string]any{}}, time.Second)
+	require.NoError(t, err)
+
+	// The stream must be registered again on the device
+	require.Eventually(t, func() bool { return s.id() == "sub1" }, time.Second, time.Millisecond*10)
+	r.publishStream("hello")
+	select {
+	case msg := <-s.Channel():
+		require.Equal(t, "sensor.value", msg.To)
+		require.Equal(t, "hello", msg.Data)
+	case <-time.After(time.Second):
+		t.Fatal("stream message not received")
+	}
+	require.NoError(t, s.Context().Err())
+}
@@ -0,0 +1,225 @@
+package idefixgo
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	ie "github.com/nayarsystems/idefix-go/errors"
+)
+
+// Credentials shared by the devices, used when no username and password are configured
+const (
+	defaultMqttUsername = "device"
+	defaultMqttPassword = "77dev22p1"
+)
+
+// mqttTransport is the default [Transport] implementation, built on top of the paho MQTT client.
+type mqttTransport struct {
+	opts         *ClientOptions
+	mutex        sync.Mutex
+	client       mqtt.Client
+	lostHandler  func(err error)
+	attempt      string // broker of the last connection attempt
+	activeBroker string
+}
+
+func newMqttTransport(opts *ClientOptions) *mqttTransport {
+	return &mqttTransport{opts: opts}
+}
+
+func (t *mqttTransport) Connect(clientID string) error {
+	brokers := t.opts.brokers()
+	if len(brokers) == 0 {
+		return ie.ErrInvalidParams.With("no broker configured")
+	}
+
+	opts := mqtt.NewClientOptions()
+	for _, broker := range brokers {
+		opts.AddBroker(broker)
+	}
+	opts.SetCleanSession(true)
+	// Brokers are tried in order, the last attempt before connecting is the active one
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+		t.mutex.Lock()
+		t.attempt = broker.String()
+		t.mutex.Unlock()
+		return tlsCfg
+	})
+
+	if len(t.opts.WebsocketHeaders) > 0 {
+		headers := http.Header{}
+		for k, v := range t.opts.WebsocketHeaders {
+			headers.Set(k, v)
+		}
+		opts.SetHTTPHeaders(headers)
+	}
+	if t.opts.WebsocketProxy != "" {
+		proxy, err := url.Parse(t.opts.WebsocketProxy)
+		if err != nil {
+			return ie.ErrInvalidParams.Withf("invalid websocket proxy: %v", err)
+		}
+		opts.SetWebsocketOptions(&mqtt.WebsocketOptions{Proxy: http.ProxyURL(proxy)})
+	}
+
+	username, password := t.opts.Username, t.opts.Password
+	if username == "" && password == "" {
+		username, password = defaultMqttUsername, defaultMqttPassword
+	}
+	opts.SetUsername(username)
+	opts.SetPassword(password)
+
+	tlsConfig, err := newTLSConfig(t.opts)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	opts.SetClientID(clientID)
+	opts.SetConnectionLostHandler(t.connectionLost)
+	if t.opts.Reconnect {
+		// Reconnections are handled by the client itself, since the session
+		// must be restored (response topic subscription and login) afterwards.
+		opts.SetAutoReconnect(false)
+	}
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	token.Wait()
+	if token.Error() != nil {
+		return token.Error()
+	}
+
+	t.mutex.Lock()
+	t.client = client
+	t.activeBroker = t.attempt
+	t.mutex.Unlock()
+	return nil
+}
+
+// ActiveBroker returns the broker of the current (or last) connection.
+func (t *mqttTransport) ActiveBroker() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.activeBroker
+}
+
+func (t *mqttTransport) Disconnect() {
+	if client := t.getClient(); client != nil {
+		client.Disconnect(200)
+	}
+}
+
+func (t *mqttTransport) IsConnected() bool {
+	client := t.getClient()
+	return client != nil && client.IsConnected()
+}
+
+func (t *mqttTransport) Publish(ctx context.Context, topic string, qos byte, payload []byte) error {
+	client := t.getClient()
+	if client == nil {
+		return mqtt.ErrNotConnected
+	}
+	token := client.Publish(topic, qos, false, payload)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-token.Done():
+	}
+	return token.Error()
+}
+
+func (t *mqttTransport) Subscribe(topic string, qos byte, handler TransportHandler) error {
+	client := t.getClient()
+	if client == nil {
+		return mqtt.ErrNotConnected
+	}
+	token := client.Subscribe(topic, qos, func(_ mqtt.Client, msg mqtt.Message) {
+		handler(msg.Topic(), msg.Payload())
+	})
+	token.Wait()
+	return token.Error()
+}
+
+func (t *mqttTransport) Unsubscribe(topics ...string) error {
+	client := t.getClient()
+	if client == nil {
+		return mqtt.ErrNotConnected
+	}
+	token := client.Unsubscribe(topics...)
+	token.Wait()
+	return token.Error()
+}
+
+func (t *mqttTransport) SetConnectionLostHandler(handler func(err error)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.lostHandler = handler
+}
+
+func (t *mqttTransport) connectionLost(_ mqtt.Client, err error) {
+	t.mutex.Lock()
+	handler := t.lostHandler
+	t.mutex.Unlock()
+	if handler != nil {
+		handler(err)
+	}
+}
+
+func (t *mqttTransport) getClient() mqtt.Client {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.client
+}
+
+// newTLSConfig builds the TLS configuration from the client options.
+// It returns nil if no TLS option is set, so the MQTT client defaults are used.
+func newTLSConfig(opts *ClientOptions) (*tls.Config, error) {
+	if len(opts.CACert) == 0 && len(opts.ClientCert) == 0 && len(opts.ClientKey) == 0 &&
+		opts.ServerName == "" && opts.MinTLSVersion == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName: opts.ServerName,
+	}
+
+	if len(opts.CACert) > 0 {
+		certpool := x509.NewCertPool()
+		if !certpool.AppendCertsFromPEM(opts.CACert) {
+			return nil, ie.ErrInvalidParams.With("invalid CA certificate")
+		}
+		config.RootCAs = certpool
+	}
+
+	if len(opts.ClientCert) > 0 || len(opts.ClientKey) > 0 {
+		cert, err := tls.X509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, ie.ErrInvalidParams.Withf("invalid client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.MinTLSVersion != "" {
+		version, ok := tlsVersions[opts.MinTLSVersion]
+		if !ok {
+			return nil, ie.ErrInvalidParams.Withf("unknown TLS version %q", opts.MinTLSVersion)
+		}
+		config.MinVersion = version
+	}
+
+	return config, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
@@ -0,0 +1,173 @@
+package idefixgo
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCert returns a self-signed PEM encoded certificate and its key
+func newTestCert(t *testing.T) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestTLSConfig(t *testing.T) {
+	config, err := newTLSConfig(&ClientOptions{})
+	require.NoError(t, err)
+	require.Nil(t, config)
+
+	cert, key := newTestCert(t)
+	config, err = newTLSConfig(&ClientOptions{
+		CACert:        cert,
+		ClientCert:    cert,
+		ClientKey:     key,
+		ServerName:    "broker.local",
+		MinTLSVersion: "1.3",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, config.RootCAs)
+	require.Len(t, config.Certificates, 1)
+	require.Equal(t, "broker.local", config.ServerName)
+	require.EqualValues(t, tls.VersionTLS13, config.MinVersion)
+
+	_, err = newTLSConfig(&ClientOptions{ClientCert: cert})
+	require.Error(t, err)
+	_, err = newTLSConfig(&ClientOptions{CACert: []byte("invalid")})
+	require.Error(t, err)
+	_, err = newTLSConfig(&ClientOptions{MinTLSVersion: "2.0"})
+	require.Error(t, err)
+}
+
+// serveFakeMqtt accepts an MQTT connection on a packet stream, checking the
+// CONNECT packet and answering it with a CONNACK. Other packets are ignored.
+func serveFakeMqtt(read func() ([]byte, error), write func([]byte) error) {
+	for {
+		data, err := read()
+		if err != nil {
+			return
+		}
+		cp, err := packets.ReadPacket(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if _, ok := cp.(*packets.ConnectPacket); !ok {
+			continue
+		}
+		ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+		var buf bytes.Buffer
+		if err := ack.Write(&buf); err != nil {
+			return
+		}
+		if err := write(buf.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+// newFakeMqttBroker starts a TCP broker which accepts any connection
+func newFakeMqttBroker(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serveFakeMqtt(func() ([]byte, error) {
+					cp, err := packets.ReadPacket(conn)
+					if err != nil {
+						return nil, err
+					}
+					var buf bytes.Buffer
+					err = cp.Write(&buf)
+					return buf.Bytes(), err
+				}, func(b []byte) error {
+					_, err := conn.Write(b)
+					return err
+				})
+			}()
+		}
+	}()
+	return "tcp://" + l.Addr().String()
+}
+
+func TestMqttFailover(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	down := "tcp://" + l.Addr().String()
+	l.Close()
+	up := newFakeMqttBroker(t)
+
+	tr := newMqttTransport(&ClientOptions{Broker: down, Brokers: []string{down, up}})
+	require.NoError(t, tr.Connect("failover"))
+	defer tr.Disconnect()
+	require.Equal(t, up, tr.ActiveBroker())
+
+	tr = newMqttTransport(&ClientOptions{})
+	require.Error(t, tr.Connect("nobroker"))
+}
+
+func TestMqttWebsocket(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	upgrader := websocket.Upgrader{Subprotocols: []string{"mqtt"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serveFakeMqtt(func() ([]byte, error) {
+			_, data, err := conn.ReadMessage()
+			return data, err
+		}, func(b []byte) error {
+			return conn.WriteMessage(websocket.BinaryMessage, b)
+		})
+	}))
+	defer srv.Close()
+
+	broker := "ws" + strings.TrimPrefix(srv.URL, "http") + "/mqtt"
+	tr := newMqttTransport(&ClientOptions{
+		Broker:           broker,
+		WebsocketHeaders: map[string]string{"X-Site": "plant1"},
+	})
+	require.NoError(t, tr.Connect("ws"))
+	defer tr.Disconnect()
+	require.Equal(t, broker, tr.ActiveBroker())
+	require.Equal(t, "plant1", (<-headers).Get("X-Site"))
+}
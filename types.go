@@ -1,7 +1,13 @@
 package idefixgo
 
 import (
+	"log/slog"
+	"slices"
+	"time"
+
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ClientOptions defines the configuration options for initializing a Client.
@@ -9,15 +15,58 @@ import (
 // These options include connection details, security settings, metadata, and other parameters
 // that influence how the Client interacts with the MQTT broker
 type ClientOptions struct {
-	Broker    string                 `json:"broker"`              // The address or URL of the MQTT broker the client will connect to.
-	Encoding  string                 `json:"encoding"`            // Specifies the data encoding format to be used.
-	CACert    []byte                 `json:"cacert,omitempty"`    // A byte slice containing the Certificate Authority (CA) certificate for secure communication.
-	Address   string                 `json:"address"`             // The specific client address or identifier used for communications.
-	Token     string                 `json:"token"`               // A security token for authenticating the client to the broker.
-	Meta      map[string]interface{} `json:"meta,omitempty"`      // A map containing additional metadata.
-	SessionID string                 `json:"session,omitempty"`   // A string representing the session ID for the client's connection, useful for session management.
-	Groups    []string               `json:"groups,omitempty"`    // A list of group identifiers to which the client belongs, used for access control.
-	NoCreate  bool                   `json:"noCreate,omitempty"`  // A boolean flag indicating whether the login should avoid creating a new user if one does not exist.
-	SkipLogin bool                   `json:"skipLogin,omitempty"` // A boolean flag indicating whether the client should skip the login process. In this case a SessionID must be provided.
+	Broker    string                 `json:"broker"`                                   // The address or URL of the MQTT broker the client will connect to (tcp://, ssl://, ws:// or wss://).
+	Encoding  string                 `json:"encoding"`                                 // Specifies the data encoding format to be used: 'j' (JSON), 'm' (msgpack) or 'c' (CBOR), optionally followed by 'z' (zstd) or 'g' (gzip) compression.
+	CACert    []byte                 `json:"cacert,omitempty"`                         // A byte slice containing the Certificate Authority (CA) certificate for secure communication.
+	Address   string                 `json:"address"`                                  // The specific client address or identifier used for communications.
+	Token     string                 `json:"token"`                                    // A security token for authenticating the client to the broker.
+	Meta      map[string]interface{} `json:"meta,omitempty"`                           // A map containing additional metadata.
+	SessionID string                 `json:"session,omitempty" mapstructure:"session"` // A string representing the session ID for the client's connection, useful for session management.
+	Groups    []string               `json:"groups,omitempty"`                         // A list of group identifiers to which the client belongs, used for access control.
+	NoCreate  bool                   `json:"noCreate,omitempty"`                       // A boolean flag indicating whether the login should avoid creating a new user if one does not exist.
+	SkipLogin bool                   `json:"skipLogin,omitempty"`                      // A boolean flag indicating whether the client should skip the login process. In this case a SessionID must be provided.
+
+	Brokers          []string          `json:"brokers,omitempty"`          // Fallback brokers, tried in order after Broker when connecting (and reconnecting, see Reconnect).
+	WebsocketHeaders map[string]string `json:"websocketHeaders,omitempty"` // Additional HTTP headers sent when connecting to a ws:// or wss:// broker.
+	WebsocketProxy   string            `json:"websocketProxy,omitempty"`   // Proxy URL used to reach ws:// or wss:// brokers. Defaults to the environment proxy settings (HTTPS_PROXY...).
+
+	Username      string `json:"username,omitempty"`      // MQTT broker username. Defaults to the shared device credentials.
+	Password      string `json:"password,omitempty"`      // MQTT broker password. Defaults to the shared device credentials.
+	ClientCert    []byte `json:"clientCert,omitempty"`    // PEM encoded client certificate, used along with ClientKey for mutual TLS authentication.
+	ClientKey     []byte `json:"clientKey,omitempty"`     // PEM encoded private key of ClientCert.
+	ServerName    string `json:"serverName,omitempty"`    // Overrides the server name used to verify the broker certificate (and sent through SNI).
+	MinTLSVersion string `json:"minTlsVersion,omitempty"` // Minimum TLS version accepted ("1.0", "1.1", "1.2" or "1.3"). Defaults to the Go default.
+
+	Reconnect            bool          `json:"reconnect,omitempty"`            // A boolean flag enabling automatic reconnection (and session restore) when the connection to the broker is lost.
+	ReconnectMinInterval time.Duration `json:"reconnectMinInterval,omitempty"` // Initial delay between reconnection attempts. Defaults to 1 second.
+	ReconnectMaxInterval time.Duration `json:"reconnectMaxInterval,omitempty"` // Maximum delay between reconnection attempts. Defaults to 1 minute.
+
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"` // Retry policy of the idempotent cloud commands. Defaults to DefaultRetryPolicy (see WithRetryPolicy for per request policies).
+
+	RateLimit         *RateLimit           `json:"rateLimit,omitempty"`         // Optional limit shared by all the requests.
+	AddressRateLimit  *RateLimit           `json:"addressRateLimit,omitempty"`  // Optional limit applied to the requests of each remote address separately.
+	AddressRateLimits map[string]RateLimit `json:"addressRateLimits,omitempty"` // Limits of specific remote addresses, overriding AddressRateLimit.
+
+	CompressionThreshold int `json:"compressionThreshold,omitempty"` // Minimum size (in bytes) of the encoded messages to be compressed. Zero (the default) compresses every message; see RecommendedCompressionThreshold.
+
+	Logger *slog.Logger `json:"-"` // Logger of the client diagnostics. Defaults to slog.Default().
+
+	TracerProvider trace.TracerProvider `json:"-"` // OpenTelemetry tracer provider of the client spans. Defaults to the global provider.
+	MeterProvider  metric.MeterProvider `json:"-"` // OpenTelemetry meter provider of the client metrics. Defaults to the global provider.
+
+	Outbox Outbox `json:"-"` // Optional persistent queue of the messages published while disconnected (e.g. an outbox.SqliteOutbox), replayed in order once connected.
+
+	Transport Transport `json:"-"` // An optional transport used instead of the default MQTT one (e.g. a [LoopbackTransport] for tests).
 	vp        *viper.Viper
 }
+
+// brokers returns the ordered list of brokers to connect to, without duplicates.
+func (o *ClientOptions) brokers() []string {
+	brokers := make([]string, 0, len(o.Brokers)+1)
+	for _, b := range append([]string{o.Broker}, o.Brokers...) {
+		if b != "" && !slices.Contains(brokers, b) {
+			brokers = append(brokers, b)
+		}
+	}
+	return brokers
+}
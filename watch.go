@@ -0,0 +1,213 @@
+package idefixgo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	ie "github.com/nayarsystems/idefix-go/errors"
+	m "github.com/nayarsystems/idefix-go/messages"
+)
+
+const (
+	// DefaultWatchBuffer is the capacity of the channel returned by [Client.WatchEvents] by default
+	DefaultWatchBuffer = 100
+	// DefaultWatchDedupSize is the number of recent event UIDs remembered by [Client.WatchEvents] by default
+	DefaultWatchDedupSize = 1000
+)
+
+// CursorStore persists the continuation ID of an event subscription, so that it can be
+// resumed from the same point after a restart.
+type CursorStore interface {
+	// LoadCursor returns the saved continuation ID, or an empty string if there is none
+	LoadCursor() (string, error)
+	// SaveCursor saves the continuation ID following the last delivered events
+	SaveCursor(cursor string) error
+}
+
+// FileCursorStore is a [CursorStore] keeping the continuation ID in the file at the given path
+type FileCursorStore string
+
+func (f FileCursorStore) LoadCursor() (string, error) {
+	data, err := os.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (f FileCursorStore) SaveCursor(cursor string) error {
+	// Write and rename, so that a crash never leaves a truncated cursor behind
+	tmp, err := os.CreateTemp(filepath.Dir(string(f)), filepath.Base(string(f))+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(cursor); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), string(f))
+}
+
+// WatchOptions defines how [Client.WatchEvents] follows the events.
+type WatchOptions struct {
+	Cursor           CursorStore   // Optional store of the continuation ID. A saved cursor takes precedence over the query one.
+	Buffer           uint          // Capacity of the events channel. Defaults to DefaultWatchBuffer.
+	DedupSize        uint          // Number of recent event UIDs remembered to drop duplicates. Defaults to DefaultWatchDedupSize.
+	MinRetryInterval time.Duration // Initial delay before retrying after an error. Defaults to 1 second.
+	MaxRetryInterval time.Duration // Maximum delay before retrying after an error. Defaults to 1 minute.
+}
+
+// WatchEvents returns a channel receiving the events matching the query as they arrive.
+//
+// The events are long-polled (during query.Timeout, or [DefaultPollTimeout] if unset) starting
+// from query.ContinuationID, or from the cursor saved in opts.Cursor. After each page the new
+// continuation ID is saved in opts.Cursor, so a later watch resumes where this one stopped.
+// Events already delivered (by UID) are dropped, and transient errors (e.g. timeouts or a
+// connection loss) are retried with an exponential backoff.
+//
+// The channel is closed when the context is done, when the client is disconnected, or when
+// the cloud rejects the query (e.g. an unknown domain or a permission error).
+func (c *Client) WatchEvents(ctx context.Context, query m.EventsGetMsg, opts ...WatchOptions) (<-chan *m.Event, error) {
+	if query.UID != "" {
+		return nil, ie.ErrInvalidParams.With("can't watch an event UID")
+	}
+	if c.ctx == nil || c.ctx.Err() != nil {
+		return nil, ie.ErrContextClosed.With("client is not connected")
+	}
+
+	w := &eventWatcher{c: c, query: query}
+	if len(opts) > 0 {
+		w.opts = opts[0]
+	}
+	if w.opts.Buffer == 0 {
+		w.opts.Buffer = DefaultWatchBuffer
+	}
+	if w.opts.DedupSize == 0 {
+		w.opts.DedupSize = DefaultWatchDedupSize
+	}
+	if w.query.Timeout <= 0 {
+		w.query.Timeout = DefaultPollTimeout
+	}
+	if w.opts.Cursor != nil {
+		cursor, err := w.opts.Cursor.LoadCursor()
+		if err != nil {
+			return nil, ie.ErrInternal.Withf("can't load cursor: %v", err)
+		}
+		if cursor != "" {
+			w.query.ContinuationID = cursor
+		}
+	}
+
+	w.ctx, w.cancel = context.WithCancel(ctx)
+	context.AfterFunc(c.ctx, w.cancel)
+	w.ch = make(chan *m.Event, w.opts.Buffer)
+	w.seen = make(map[string]struct{}, w.opts.DedupSize)
+	go w.run()
+	return w.ch, nil
+}
+
+type eventWatcher struct {
+	c      *Client
+	query  m.EventsGetMsg
+	opts   WatchOptions
+	ctx    context.Context
+	cancel context.CancelFunc
+	ch     chan *m.Event
+	seen   map[string]struct{}
+	recent []string // UIDs in the seen set, oldest first
+}
+
+func (w *eventWatcher) run() {
+	defer close(w.ch)
+	defer w.cancel()
+	b := newBackoff(w.opts.MinRetryInterval, w.opts.MaxRetryInterval)
+
+	for w.ctx.Err() == nil {
+		callCtx, cancel := context.WithTimeout(w.ctx, w.query.Timeout+pageCallMargin)
+		res, err := w.c.EventsGet(&w.query, callCtx)
+		cancel()
+		if err != nil {
+			if w.ctx.Err() != nil {
+				return
+			}
+			if !watchRetryable(err) {
+				w.c.Logger().Error("event watch stopped", "domain", w.query.Domain, "address", w.query.Address, "error", err)
+				return
+			}
+			w.c.Logger().Debug("can't get events, retrying", "domain", w.query.Domain, "address", w.query.Address, "error", err)
+			t := time.NewTimer(b.next())
+			select {
+			case <-w.ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+			continue
+		}
+		b.reset()
+
+		for _, e := range res.Events {
+			if w.duplicated(e.UID) {
+				continue
+			}
+			select {
+			case w.ch <- e:
+			case <-w.ctx.Done():
+				return
+			}
+		}
+
+		if res.ContinuationID != "" && res.ContinuationID != w.query.ContinuationID {
+			w.query.ContinuationID = res.ContinuationID
+			if w.opts.Cursor != nil {
+				if err := w.opts.Cursor.SaveCursor(res.ContinuationID); err != nil {
+					w.c.Logger().Warn("can't save cursor", "domain", w.query.Domain, "address", w.query.Address, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// duplicated reports whether an event UID was already delivered, remembering it otherwise
+func (w *eventWatcher) duplicated(uid string) bool {
+	if uid == "" {
+		return false
+	}
+	if _, ok := w.seen[uid]; ok {
+		return true
+	}
+	if uint(len(w.recent)) >= w.opts.DedupSize {
+		delete(w.seen, w.recent[0])
+		w.recent = w.recent[1:]
+	}
+	w.seen[uid] = struct{}{}
+	w.recent = append(w.recent, uid)
+	return false
+}
+
+// watchRetryable reports whether an events.get error may go away by itself
+func watchRetryable(err error) bool {
+	for _, fatal := range []ie.IdefixError{
+		ie.ErrInvalidParams,
+		ie.ErrNotAuthorized,
+		ie.ErrPermissionDenied,
+		ie.ErrMissingDomain,
+		ie.ErrDomainNotFound,
+		ie.ErrAddressNotFound,
+	} {
+		if fatal.Is(err) {
+			return false
+		}
+	}
+	return true
+}
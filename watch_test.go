@@ -0,0 +1,131 @@
+package idefixgo_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ifx "github.com/nayarsystems/idefix-go"
+	ie "github.com/nayarsystems/idefix-go/errors"
+	"github.com/nayarsystems/idefix-go/idefixtest"
+	m "github.com/nayarsystems/idefix-go/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func newEvent(uid string) *m.Event {
+	return &m.Event{EventMsg: m.EventMsg{UID: uid}, Domain: "acme", Address: "dev1"}
+}
+
+func receiveEvents(t *testing.T, ch <-chan *m.Event, n int) []string {
+	var uids []string
+	for range n {
+		select {
+		case e, ok := <-ch:
+			require.True(t, ok, "channel closed")
+			uids = append(uids, e.UID)
+		case <-time.After(time.Second * 2):
+			require.FailNow(t, "timeout waiting for events", "got %v", uids)
+		}
+	}
+	return uids
+}
+
+func TestWatchEvents(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.CreateAddress("dev1", "t", "acme")
+	s.AddEvents(newEvent("e0"), newEvent("e1"))
+
+	c, err := s.Connect(context.Background(), "admin", "adminToken")
+	require.NoError(t, err)
+	defer c.Disconnect()
+
+	// The first request fails with a transient error, and the second one has its cursor reset,
+	// so the cloud answers already delivered events
+	var calls atomic.Int32
+	c.Use(func(next ifx.CallFunc) ifx.CallFunc {
+		return func(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+			if msg.To != m.CmdEventsGet {
+				return next(ctx, remoteAddress, msg)
+			}
+			switch calls.Add(1) {
+			case 1:
+				return nil, ie.ErrTryAgain
+			case 3:
+				msg.Data.(map[string]any)["cid"] = ""
+			}
+			return next(ctx, remoteAddress, msg)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cursor := ifx.FileCursorStore(filepath.Join(t.TempDir(), "cursor"))
+	ch, err := c.WatchEvents(ctx, m.EventsGetMsg{Domain: "acme", Timeout: time.Millisecond * 200}, ifx.WatchOptions{
+		Cursor:           cursor,
+		MinRetryInterval: time.Millisecond * 10,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"e0", "e1"}, receiveEvents(t, ch, 2))
+
+	require.Eventually(t, func() bool { return calls.Load() > 3 }, time.Second*2, time.Millisecond*10)
+	s.AddEvents(newEvent("e2"))
+	require.Equal(t, []string{"e2"}, receiveEvents(t, ch, 1))
+
+	cancel()
+	for range ch {
+	}
+	saved, err := cursor.LoadCursor()
+	require.NoError(t, err)
+	require.NotEmpty(t, saved)
+
+	// A new watch resumes from the saved cursor
+	s.AddEvents(newEvent("e3"))
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	ch, err = c.WatchEvents(ctx, m.EventsGetMsg{Domain: "acme"}, ifx.WatchOptions{Cursor: cursor})
+	require.NoError(t, err)
+	require.Equal(t, []string{"e3"}, receiveEvents(t, ch, 1))
+
+	// Disconnecting the client closes the channel
+	c.Disconnect()
+	select {
+	case _, ok := <-ch:
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		require.Fail(t, "channel not closed")
+	}
+}
+
+func TestWatchEventsErrors(t *testing.T) {
+	s, err := idefixtest.NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+
+	c, err := s.Connect(context.Background(), "admin", "adminToken")
+	require.NoError(t, err)
+	defer c.Disconnect()
+
+	_, err = c.WatchEvents(context.Background(), m.EventsGetMsg{UID: "e0"})
+	require.ErrorIs(t, err, ie.ErrInvalidParams)
+
+	// Rejected queries close the channel
+	c.Use(func(next ifx.CallFunc) ifx.CallFunc {
+		return func(ctx context.Context, remoteAddress string, msg *m.Message) (*m.Message, error) {
+			if msg.To == m.CmdEventsGet {
+				return nil, ie.ErrPermissionDenied
+			}
+			return next(ctx, remoteAddress, msg)
+		}
+	})
+	ch, err := c.WatchEvents(context.Background(), m.EventsGetMsg{Domain: "acme"})
+	require.NoError(t, err)
+	select {
+	case _, ok := <-ch:
+		require.False(t, ok)
+	case <-time.After(time.Second * 2):
+		require.Fail(t, "channel not closed")
+	}
+}